@@ -10,9 +10,10 @@ import (
 
 // Compiler compiles and optimizes templates.
 type Compiler struct {
-	cache     *CompilationCache
-	loader    TemplateLoader
-	optimizer *Optimizer
+	cache      *CompilationCache
+	loader     TemplateLoader
+	optimizer  *Optimizer
+	autoEscape func(slug string) bool
 }
 
 // TemplateLoader defines the interface for loading template source.
@@ -27,6 +28,7 @@ type CompiledTemplate struct {
 	Dependencies []string
 	CacheKey     string
 	IsOptimized  bool
+	AutoEscape   bool // Output is auto-escaped, decided once at compile time
 }
 
 // CompilationCache provides thread-safe caching of compiled templates.
@@ -80,6 +82,13 @@ func New(loader TemplateLoader) *Compiler {
 	}
 }
 
+// SetAutoEscapeFunc sets the function that decides, when a template is
+// compiled, whether its output is auto-escaped. The result is cached in
+// CompiledTemplate.AutoEscape along with the template.
+func (c *Compiler) SetAutoEscapeFunc(fn func(slug string) bool) {
+	c.autoEscape = fn
+}
+
 // NewCompiler is an alias for New.
 func NewCompiler(loader TemplateLoader) *Compiler {
 	return New(loader)
@@ -116,6 +125,7 @@ func (c *Compiler) Compile(slug string) (*CompiledTemplate, error) {
 		Dependencies: deps,
 		CacheKey:     cacheKey,
 		IsOptimized:  true,
+		AutoEscape:   c.autoEscape != nil && c.autoEscape(slug),
 	}
 
 	// Cache it
@@ -198,7 +208,16 @@ func (c *Compiler) resolveNodeDependencies(
 		return c.resolveIfNodeDeps(n, resolve)
 
 	case *parser.RangeNode:
-		return c.resolveChildren(n.Body, resolve)
+		if err := c.resolveChildren(n.Body, resolve); err != nil {
+			return err
+		}
+		return c.resolveChildren(n.Else, resolve)
+
+	case *parser.WithNode:
+		if err := c.resolveChildren(n.Body, resolve); err != nil {
+			return err
+		}
+		return c.resolveChildren(n.Else, resolve)
 
 	case *parser.BlockNode:
 		return c.resolveChildren(n.Body, resolve)
@@ -239,6 +258,12 @@ func (c *Compiler) resolveIfNodeDeps(n *parser.IfNode, resolve func(*parser.Temp
 		return err
 	}
 
+	for _, clause := range n.ElseIfs {
+		if err := c.resolveChildren(clause.Body, resolve); err != nil {
+			return err
+		}
+	}
+
 	if n.Else != nil {
 		return c.resolveChildren(n.Else, resolve)
 	}
@@ -89,6 +89,43 @@ func TestCompiler_CompileWithCache(t *testing.T) {
 	}
 }
 
+func TestCompiler_AutoEscapeDecidedOnce(t *testing.T) {
+	loader := newMockLoader()
+	loader.add("page", &parser.Template{
+		Nodes: []parser.Node{
+			&parser.TextNode{Value: "Page"},
+		},
+	})
+
+	compiler := New(loader)
+	calls := 0
+	compiler.SetAutoEscapeFunc(func(slug string) bool {
+		calls++
+		return slug == "page"
+	})
+
+	for i := 0; i < 3; i++ {
+		compiled, err := compiler.Compile("page")
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		if !compiled.AutoEscape {
+			t.Error("expected AutoEscape to be cached as true")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected auto-escape to be decided once, got %d calls", calls)
+	}
+
+	compiler.ClearCache()
+	if _, err := compiler.Compile("page"); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected auto-escape to be decided again after ClearCache, got %d calls", calls)
+	}
+}
+
 func TestCompiler_ResolveDependencies_Include(t *testing.T) {
 	loader := newMockLoader()
 	loader.add("header", &parser.Template{
@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"github.com/toutaio/toutago-fith-renderer/lexer"
 	"github.com/toutaio/toutago-fith-renderer/parser"
 )
 
@@ -33,6 +34,9 @@ func (o *Optimizer) optimizeNode(node parser.Node) parser.Node {
 	case *parser.TextNode:
 		return n
 
+	case *parser.CommentNode:
+		return nil // Comments produce no output
+
 	case *parser.VariableNode:
 		return n
 
@@ -42,6 +46,15 @@ func (o *Optimizer) optimizeNode(node parser.Node) parser.Node {
 	case *parser.RangeNode:
 		return o.optimizeRange(n)
 
+	case *parser.WithNode:
+		return o.optimizeWith(n)
+
+	case *parser.SetNode:
+		return &parser.SetNode{Position: n.Position, Variable: n.Variable, Value: o.foldExpr(n.Value)}
+
+	case *parser.TernaryNode, *parser.BinaryOpNode, *parser.UnaryOpNode:
+		return o.foldExpr(n)
+
 	case *parser.IncludeNode:
 		return n
 
@@ -58,8 +71,11 @@ func (o *Optimizer) optimizeNode(node parser.Node) parser.Node {
 
 // optimizeIf optimizes if nodes, performing constant folding and dead code elimination.
 func (o *Optimizer) optimizeIf(n *parser.IfNode) parser.Node {
+	elseIfs, elseNodes := o.optimizeElseIfs(n.ElseIfs, n.Else)
+	condition := o.foldExpr(n.Condition)
+
 	// Check if condition is a constant boolean
-	if constVal, isConst := o.isConstantBool(n.Condition); isConst {
+	if constVal, isConst := o.isConstantBool(condition); isConst {
 		if constVal {
 			// Condition is always true - return then branch
 			if len(n.Then) == 1 {
@@ -68,48 +84,107 @@ func (o *Optimizer) optimizeIf(n *parser.IfNode) parser.Node {
 			// Multiple nodes - keep if structure but mark as optimized
 			return &parser.IfNode{
 				Position:  n.Position,
-				Condition: n.Condition,
+				Condition: condition,
 				Then:      o.optimizeNodes(n.Then),
 				Else:      nil, // Dead code eliminated
 			}
 		}
-		// Condition is always false - return else branch or nothing
-		if len(n.Else) == 1 {
-			return o.optimizeNode(n.Else[0])
+		// Condition is always false - the first else if branch takes its place
+		if len(elseIfs) > 0 {
+			return o.optimizeIf(&parser.IfNode{
+				Position:  elseIfs[0].Position,
+				Condition: elseIfs[0].Condition,
+				Then:      elseIfs[0].Body,
+				ElseIfs:   elseIfs[1:],
+				Else:      elseNodes,
+			})
+		}
+		// Otherwise return else branch or nothing
+		if len(elseNodes) == 1 {
+			return elseNodes[0]
 		}
-		if len(n.Else) > 0 {
+		if len(elseNodes) > 0 {
 			return &parser.IfNode{
 				Position:  n.Position,
 				Condition: &parser.LiteralNode{Position: n.Position, Value: false},
 				Then:      nil, // Dead code eliminated
-				Else:      o.optimizeNodes(n.Else),
+				Else:      elseNodes,
 			}
 		}
 		return nil // Entire if block eliminated
 	}
 
 	// Not constant - optimize branches
-	var elseNodes []parser.Node
-	if n.Else != nil {
-		elseNodes = o.optimizeNodes(n.Else)
-	}
 	return &parser.IfNode{
 		Position:  n.Position,
-		Condition: n.Condition,
+		Condition: condition,
 		Then:      o.optimizeNodes(n.Then),
+		ElseIfs:   elseIfs,
 		Else:      elseNodes,
 	}
 }
 
+// optimizeElseIfs optimizes else if branches and the else branch. Branches
+// with a constant false condition are dropped; a branch with a constant true
+// condition becomes the else branch, and the branches after it are dropped.
+func (o *Optimizer) optimizeElseIfs(
+	clauses []*parser.ElseIfClause,
+	elseNodes []parser.Node,
+) ([]*parser.ElseIfClause, []parser.Node) {
+	var optimized []*parser.ElseIfClause
+	for _, clause := range clauses {
+		condition := o.foldExpr(clause.Condition)
+		constVal, isConst := o.isConstantBool(condition)
+		if !isConst {
+			optimized = append(optimized, &parser.ElseIfClause{
+				Position:  clause.Position,
+				Condition: condition,
+				Body:      o.optimizeNodes(clause.Body),
+			})
+			continue
+		}
+		if constVal {
+			return optimized, o.optimizeNodes(clause.Body)
+		}
+	}
+
+	if elseNodes != nil {
+		elseNodes = o.optimizeNodes(elseNodes)
+	}
+	return optimized, elseNodes
+}
+
 // optimizeRange optimizes range nodes.
 func (o *Optimizer) optimizeRange(n *parser.RangeNode) parser.Node {
-	// Optimize loop body
+	var elseNodes []parser.Node
+	if n.Else != nil {
+		elseNodes = o.optimizeNodes(n.Else)
+	}
+
+	// Optimize loop body and else branch
 	return &parser.RangeNode{
 		Position:   n.Position,
 		Variable:   n.Variable,
 		KeyVar:     n.KeyVar,
-		Collection: n.Collection,
+		Collection: o.foldExpr(n.Collection),
 		Body:       o.optimizeNodes(n.Body),
+		Else:       elseNodes,
+	}
+}
+
+// optimizeWith optimizes with nodes. The body is kept in its own node so
+// that its scope is preserved.
+func (o *Optimizer) optimizeWith(n *parser.WithNode) parser.Node {
+	var elseNodes []parser.Node
+	if n.Else != nil {
+		elseNodes = o.optimizeNodes(n.Else)
+	}
+
+	return &parser.WithNode{
+		Position: n.Position,
+		Value:    o.foldExpr(n.Value),
+		Body:     o.optimizeNodes(n.Body),
+		Else:     elseNodes,
 	}
 }
 
@@ -143,3 +218,55 @@ func (o *Optimizer) isConstantBool(node parser.Node) (value, ok bool) {
 	}
 	return false, false
 }
+
+// foldExpr folds conditional expressions decided by a literal: a ternary
+// with a literal condition becomes the chosen branch, and a ?? b with a
+// literal a becomes a, or b if a is nil. Operands are folded first.
+func (o *Optimizer) foldExpr(node parser.Node) parser.Node {
+	switch n := node.(type) {
+	case *parser.TernaryNode:
+		condition, then, otherwise := o.foldExpr(n.Condition), o.foldExpr(n.Then), o.foldExpr(n.Else)
+		if lit, ok := condition.(*parser.LiteralNode); ok {
+			if isTruthyLiteral(lit.Value) {
+				return then
+			}
+			return otherwise
+		}
+		return &parser.TernaryNode{Position: n.Position, Condition: condition, Then: then, Else: otherwise}
+
+	case *parser.BinaryOpNode:
+		left, right := o.foldExpr(n.Left), o.foldExpr(n.Right)
+		if lit, ok := left.(*parser.LiteralNode); ok && n.Operator == lexer.TokenCoalesce {
+			if lit.Value != nil {
+				return left
+			}
+			return right
+		}
+		return &parser.BinaryOpNode{Position: n.Position, Operator: n.Operator, Left: left, Right: right}
+
+	case *parser.UnaryOpNode:
+		return &parser.UnaryOpNode{Position: n.Position, Operator: n.Operator, Operand: o.foldExpr(n.Operand)}
+
+	default:
+		return node
+	}
+}
+
+// isTruthyLiteral reports whether a literal value is truthy, following the
+// runtime's rules for the types literals can have.
+func isTruthyLiteral(val interface{}) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
@@ -1,8 +1,10 @@
 package compiler
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/toutaio/toutago-fith-renderer/lexer"
 	"github.com/toutaio/toutago-fith-renderer/parser"
 )
 
@@ -24,6 +26,20 @@ func TestOptimizer_OptimizeText(t *testing.T) {
 	}
 }
 
+func TestOptimizer_RemovesComments(t *testing.T) {
+	tmpl := &parser.Template{
+		Nodes: []parser.Node{
+			&parser.CommentNode{Text: "note"},
+			&parser.TextNode{Value: "Hello"},
+		},
+	}
+
+	optimized := NewOptimizer().Optimize(tmpl)
+	if len(optimized.Nodes) != 1 {
+		t.Errorf("expected comment to be removed, got %d nodes", len(optimized.Nodes))
+	}
+}
+
 func TestOptimizer_OptimizeIfTrue(t *testing.T) {
 	opt := NewOptimizer()
 	tmpl := &parser.Template{
@@ -180,6 +196,248 @@ func TestOptimizer_OptimizeRange(t *testing.T) {
 	}
 }
 
+func TestOptimizer_OptimizeElseIf(t *testing.T) {
+	dynamic := &parser.VariableNode{Path: []string{".", "A"}}
+	text := func(v string) []parser.Node { return []parser.Node{&parser.TextNode{Value: v}} }
+
+	tests := []struct {
+		name        string
+		node        *parser.IfNode
+		wantText    string // Set when the chain folds to a single text node
+		wantElseIfs int
+		wantElse    string
+	}{
+		{
+			name: "false condition promotes first else if",
+			node: &parser.IfNode{
+				Condition: &parser.LiteralNode{Value: false},
+				Then:      text("then"),
+				ElseIfs: []*parser.ElseIfClause{
+					{Condition: &parser.LiteralNode{Value: true}, Body: text("first")},
+					{Condition: dynamic, Body: text("second")},
+				},
+				Else: text("else"),
+			},
+			wantText: "first",
+		},
+		{
+			name: "false else ifs fall through to else",
+			node: &parser.IfNode{
+				Condition: &parser.LiteralNode{Value: false},
+				Then:      text("then"),
+				ElseIfs: []*parser.ElseIfClause{
+					{Condition: &parser.LiteralNode{Value: false}, Body: text("first")},
+				},
+				Else: text("else"),
+			},
+			wantText: "else",
+		},
+		{
+			name: "true else if replaces the rest of the chain",
+			node: &parser.IfNode{
+				Condition: dynamic,
+				Then:      text("then"),
+				ElseIfs: []*parser.ElseIfClause{
+					{Condition: &parser.LiteralNode{Value: false}, Body: text("dead")},
+					{Condition: dynamic, Body: text("kept")},
+					{Condition: &parser.LiteralNode{Value: true}, Body: text("always")},
+					{Condition: dynamic, Body: text("unreachable")},
+				},
+				Else: text("else"),
+			},
+			wantElseIfs: 1,
+			wantElse:    "always",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			optimized := NewOptimizer().Optimize(&parser.Template{Nodes: []parser.Node{tt.node}})
+			if len(optimized.Nodes) != 1 {
+				t.Fatalf("expected 1 node, got %d", len(optimized.Nodes))
+			}
+
+			if tt.wantText != "" {
+				textNode, ok := optimized.Nodes[0].(*parser.TextNode)
+				if !ok || textNode.Value != tt.wantText {
+					t.Fatalf("expected TextNode %q, got %#v", tt.wantText, optimized.Nodes[0])
+				}
+				return
+			}
+
+			ifNode, ok := optimized.Nodes[0].(*parser.IfNode)
+			if !ok {
+				t.Fatalf("expected IfNode, got %T", optimized.Nodes[0])
+			}
+			if len(ifNode.ElseIfs) != tt.wantElseIfs {
+				t.Errorf("expected %d else if branches, got %d", tt.wantElseIfs, len(ifNode.ElseIfs))
+			}
+			if len(ifNode.Else) != 1 || ifNode.Else[0].(*parser.TextNode).Value != tt.wantElse {
+				t.Errorf("expected else %q, got %#v", tt.wantElse, ifNode.Else)
+			}
+		})
+	}
+}
+
+func TestOptimizer_OptimizeRangeElse(t *testing.T) {
+	tmpl := &parser.Template{
+		Nodes: []parser.Node{
+			&parser.RangeNode{
+				Collection: &parser.VariableNode{Path: []string{".", "Items"}},
+				Body:       []parser.Node{&parser.TextNode{Value: "Item"}},
+				Else: []parser.Node{
+					&parser.IfNode{
+						Condition: &parser.LiteralNode{Value: false},
+						Then:      []parser.Node{&parser.TextNode{Value: "dead"}},
+					},
+					&parser.TextNode{Value: "Empty"},
+				},
+			},
+		},
+	}
+
+	optimized := NewOptimizer().Optimize(tmpl)
+
+	rangeNode, ok := optimized.Nodes[0].(*parser.RangeNode)
+	if !ok {
+		t.Fatal("expected RangeNode")
+	}
+	if len(rangeNode.Else) != 1 {
+		t.Errorf("expected dead if removed from else branch, got %d nodes", len(rangeNode.Else))
+	}
+}
+
+func TestOptimizer_OptimizeWithAndSet(t *testing.T) {
+	tmpl := &parser.Template{
+		Nodes: []parser.Node{
+			&parser.SetNode{Variable: "x", Value: &parser.LiteralNode{Value: 1}},
+			&parser.WithNode{
+				Value: &parser.VariableNode{Path: []string{".", "User"}},
+				Body: []parser.Node{
+					&parser.IfNode{
+						Condition: &parser.LiteralNode{Value: false},
+						Then:      []parser.Node{&parser.TextNode{Value: "dead"}},
+					},
+					&parser.SetNode{Variable: "y", Value: &parser.LiteralNode{Value: 2}},
+				},
+				Else: []parser.Node{&parser.TextNode{Value: "guest"}},
+			},
+		},
+	}
+
+	optimized := NewOptimizer().Optimize(tmpl)
+	if len(optimized.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(optimized.Nodes))
+	}
+	if _, ok := optimized.Nodes[0].(*parser.SetNode); !ok {
+		t.Errorf("expected SetNode kept, got %T", optimized.Nodes[0])
+	}
+
+	withNode, ok := optimized.Nodes[1].(*parser.WithNode)
+	if !ok {
+		t.Fatalf("expected WithNode, got %T", optimized.Nodes[1])
+	}
+	if len(withNode.Body) != 1 {
+		t.Fatalf("expected dead if removed from body, got %d nodes", len(withNode.Body))
+	}
+	if _, ok := withNode.Body[0].(*parser.SetNode); !ok {
+		t.Errorf("expected SetNode kept in body, got %T", withNode.Body[0])
+	}
+	if len(withNode.Else) != 1 {
+		t.Errorf("expected else branch kept, got %d nodes", len(withNode.Else))
+	}
+}
+
+func TestOptimizer_FoldConditionals(t *testing.T) {
+	user := &parser.VariableNode{Path: []string{".", "User"}}
+	tests := []struct {
+		name string
+		node parser.Node
+		want parser.Node
+	}{
+		{
+			name: "ternary with true condition",
+			node: &parser.TernaryNode{
+				Condition: &parser.LiteralNode{Value: true},
+				Then:      &parser.LiteralNode{Value: "yes"},
+				Else:      user,
+			},
+			want: &parser.LiteralNode{Value: "yes"},
+		},
+		{
+			name: "ternary with falsy condition",
+			node: &parser.TernaryNode{
+				Condition: &parser.LiteralNode{Value: ""},
+				Then:      &parser.LiteralNode{Value: "yes"},
+				Else:      user,
+			},
+			want: user,
+		},
+		{
+			name: "coalesce with nil left",
+			node: &parser.BinaryOpNode{
+				Operator: lexer.TokenCoalesce,
+				Left:     &parser.LiteralNode{Value: nil},
+				Right:    user,
+			},
+			want: user,
+		},
+		{
+			name: "coalesce with zero left",
+			node: &parser.BinaryOpNode{
+				Operator: lexer.TokenCoalesce,
+				Left:     &parser.LiteralNode{Value: 0},
+				Right:    user,
+			},
+			want: &parser.LiteralNode{Value: 0},
+		},
+		{
+			name: "nested fold",
+			node: &parser.TernaryNode{
+				Condition: &parser.BinaryOpNode{
+					Operator: lexer.TokenCoalesce,
+					Left:     &parser.LiteralNode{Value: nil},
+					Right:    &parser.LiteralNode{Value: false},
+				},
+				Then: user,
+				Else: &parser.LiteralNode{Value: "no"},
+			},
+			want: &parser.LiteralNode{Value: "no"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := &parser.Template{Nodes: []parser.Node{tt.node}}
+			optimized := NewOptimizer().Optimize(tmpl)
+			if !reflect.DeepEqual(optimized.Nodes[0], tt.want) {
+				t.Errorf("expected %#v, got %#v", tt.want, optimized.Nodes[0])
+			}
+		})
+	}
+
+	// A ternary with a non-literal condition is kept.
+	ternary := &parser.TernaryNode{Condition: user, Then: user, Else: user}
+	optimized := NewOptimizer().Optimize(&parser.Template{Nodes: []parser.Node{ternary}})
+	if _, ok := optimized.Nodes[0].(*parser.TernaryNode); !ok {
+		t.Errorf("expected TernaryNode kept, got %T", optimized.Nodes[0])
+	}
+
+	// Folded conditions feed dead code elimination.
+	ifNode := &parser.IfNode{
+		Condition: &parser.BinaryOpNode{
+			Operator: lexer.TokenCoalesce,
+			Left:     &parser.LiteralNode{Value: nil},
+			Right:    &parser.LiteralNode{Value: false},
+		},
+		Then: []parser.Node{&parser.TextNode{Value: "dead"}},
+	}
+	optimized = NewOptimizer().Optimize(&parser.Template{Nodes: []parser.Node{ifNode}})
+	if len(optimized.Nodes) != 0 {
+		t.Errorf("expected folded if removed, got %d nodes", len(optimized.Nodes))
+	}
+}
+
 func TestOptimizer_OptimizeBlock(t *testing.T) {
 	opt := NewOptimizer()
 	tmpl := &parser.Template{
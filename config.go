@@ -31,24 +31,102 @@ type Config struct {
 	LeftDelimiter string
 
 	// RightDelimiter is the closing delimiter for template expressions.
+	// A "]]" delimiter is matched only outside indexes and list literals,
+	// so "[[.Items[0]]]" works.
 	// Default: "}}"
 	RightDelimiter string
 
+	// TrimBlocks removes the first newline after a control tag
+	// (if, else, range, end, block, ...) or comment.
+	// Default: false
+	TrimBlocks bool
+
+	// LStripBlocks removes spaces and tabs from the start of a line up to a
+	// control tag or comment. With TrimBlocks, a line holding only a control
+	// tag is removed from the output.
+	// Default: false
+	LStripBlocks bool
+
 	// CacheEnabled enables template compilation caching.
 	// Default: true
 	CacheEnabled bool
 
-	// AutoEscape enables automatic HTML escaping of variables.
+	// AutoEscape enables contextual auto-escaping of output values for every template.
+	// Values are escaped for where they land: HTML text, attribute values,
+	// URL attributes, <script> and <style> content.
 	// Default: false (manual escaping via htmlEscape function)
 	AutoEscape bool
 
-	// StrictMode causes the engine to fail on undefined variables.
-	// Default: false (undefined variables render as empty string)
+	// AutoEscapeExtensions enables auto-escaping only for templates whose file
+	// extension is listed (e.g., [".html", ".htm"]), leaving .txt or .csv output raw.
+	// Included templates, layouts and blocks follow their own file's extension.
+	// Ignored when AutoEscape is true.
+	// Default: nil
+	AutoEscapeExtensions []string
+
+	// StrictMode causes the engine to fail on undefined variables, missing
+	// fields and map keys, and field access on nil values. The error wraps a
+	// *runtime.UndefinedError naming the path, template, position and the
+	// closest existing name.
+	// Default: false (undefined and nil values render as empty string)
 	StrictMode bool
 
+	// StrictComparisons makes comparing operands that cannot be compared,
+	// like a string and a number or a number and a time.Time, fail the
+	// render instead of being unequal and unordered.
+	// Default: false
+	StrictComparisons bool
+
+	// DecimalArithmetic computes arithmetic involving floats exactly in
+	// decimal before rounding the result back to float64, so 0.1 + 0.2
+	// renders as 0.3 and sums of prices do not drift.
+	// Default: false
+	DecimalArithmetic bool
+
+	// FieldTags lists struct tag keys that name fields in template paths,
+	// checked in order, e.g. []string{"fith", "json"} lets {{.User.first_name}}
+	// resolve a field tagged `json:"first_name"`. Go field names keep working
+	// and a tag name of "-" hides the field.
+	// Default: nil (Go field names only)
+	FieldTags []string
+
+	// CaseInsensitiveFields matches struct fields ignoring case when no
+	// field matches exactly. Map keys are always matched exactly.
+	// Default: false
+	CaseInsensitiveFields bool
+
+	// DisableMethodCalls stops templates from calling methods of data values,
+	// such as .User.FullName or .User.HasRole "admin", for sandboxing
+	// untrusted templates. Paths naming a method are then undefined.
+	// Default: false (exported methods can be called)
+	DisableMethodCalls bool
+
 	// MaxIncludeDepth limits the depth of template includes to prevent infinite recursion.
+	// Limits.MaxIncludeDepth replaces it when set; either is reported as ErrorTypeLimit.
 	// Default: 100
 	MaxIncludeDepth int
+
+	// Limits bounds the resources a single render may use. Exceeding a limit
+	// fails the render with an ErrorTypeLimit error naming the limit.
+	// Default: no limits
+	Limits Limits
+}
+
+// Limits bounds the resources a single render may use, for templates written
+// by untrusted authors. Zero fields mean no limit.
+type Limits struct {
+	// MaxOutputBytes limits the size of the rendered output.
+	MaxOutputBytes int64
+
+	// MaxLoopIterations limits the total number of range iterations across the render.
+	MaxLoopIterations int64
+
+	// MaxNodes limits the number of AST nodes (statements and expressions) evaluated.
+	MaxNodes int64
+
+	// MaxIncludeDepth limits how deeply includes and layouts may be nested,
+	// replacing Config.MaxIncludeDepth when set.
+	MaxIncludeDepth int
 }
 
 // DefaultConfig returns a Config with sensible defaults.
@@ -83,6 +161,11 @@ func (c *Config) Validate() error {
 		return NewError(ErrorTypeTemplate, "MaxIncludeDepth must be at least 1")
 	}
 
+	if c.Limits.MaxOutputBytes < 0 || c.Limits.MaxLoopIterations < 0 ||
+		c.Limits.MaxNodes < 0 || c.Limits.MaxIncludeDepth < 0 {
+		return NewError(ErrorTypeTemplate, "limits cannot be negative")
+	}
+
 	if len(c.Extensions) == 0 {
 		c.Extensions = []string{".html", ".tpl", ".txt"}
 	}
@@ -117,6 +117,17 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid - negative limit",
+			config: Config{
+				TemplateDir:     "templates",
+				LeftDelimiter:   "{{",
+				RightDelimiter:  "}}",
+				MaxIncludeDepth: 100,
+				Limits:          Limits{MaxLoopIterations: -1},
+			},
+			wantErr: true,
+		},
 		{
 			name: "auto-fills extensions",
 			config: Config{
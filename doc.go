@@ -104,6 +104,7 @@ String functions: upper, lower, title, trim, truncate, replace
 Array functions: join, first, last, len
 Logic functions: default
 Encoding: urlEncode, htmlEscape
+Trusted values: safe, raw, safeHTML, safeURL, safeJS, safeCSS, safeAttr
 Date: date
 
 # Error Handling
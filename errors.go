@@ -2,7 +2,10 @@
 package fith
 
 import (
+	"errors"
 	"fmt"
+
+	"github.com/toutaio/toutago-fith-renderer/runtime"
 )
 
 // Error represents a template error with context information.
@@ -31,6 +34,9 @@ const (
 	ErrorTypeLoader
 	// ErrorTypeFunction indicates a function-related error.
 	ErrorTypeFunction
+	// ErrorTypeLimit indicates that a render exceeded one of Config.Limits
+	// or Config.MaxIncludeDepth.
+	ErrorTypeLimit
 )
 
 // Error implements the error interface.
@@ -62,6 +68,8 @@ func (t ErrorType) String() string {
 		return "LoaderError"
 	case ErrorTypeFunction:
 		return "FunctionError"
+	case ErrorTypeLimit:
+		return "LimitError"
 	default:
 		return "UnknownError"
 	}
@@ -94,3 +102,24 @@ func WrapError(errType ErrorType, message string, cause error) *Error {
 		Cause:   cause,
 	}
 }
+
+// locatedError is implemented by runtime errors that know where in a
+// template they occurred (e.g., *runtime.UndefinedError).
+type locatedError interface {
+	Location() (slug string, line, column int)
+}
+
+// wrapRuntimeError wraps a runtime error, copying its template location when
+// available. Limit violations are reported as ErrorTypeLimit.
+func wrapRuntimeError(message string, cause error) *Error {
+	err := WrapError(ErrorTypeRuntime, message, cause)
+	var limitErr *runtime.LimitError
+	if errors.As(cause, &limitErr) {
+		err.Type = ErrorTypeLimit
+	}
+	var located locatedError
+	if errors.As(cause, &located) {
+		err.Slug, err.Line, err.Column = located.Location()
+	}
+	return err
+}
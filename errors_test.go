@@ -128,6 +128,7 @@ func TestErrorTypeString(t *testing.T) {
 		{ErrorTypeRuntime, "RuntimeError"},
 		{ErrorTypeLoader, "LoaderError"},
 		{ErrorTypeFunction, "FunctionError"},
+		{ErrorTypeLimit, "LimitError"},
 		{ErrorTypeUnknown, "UnknownError"},
 		{ErrorType(999), "UnknownError"},
 	}
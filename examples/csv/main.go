@@ -5,6 +5,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/toutaio/toutago-fith-renderer"
@@ -30,11 +31,11 @@ func main() {
 		},
 	}
 
-	output, err := engine.RenderString(csvTemplate, users)
-	if err != nil {
+	// Stream straight to stdout instead of building the whole CSV in memory
+	if _, err := engine.RenderStringTo(os.Stdout, csvTemplate, users); err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println(output)
+	fmt.Println()
 
 	// Example 2: Sales report with calculations
 	fmt.Println("=== Example 2: Sales Report ===")
@@ -68,7 +69,7 @@ func main() {
 		},
 	}
 
-	output, err = engine.RenderString(salesTemplate, sales)
+	output, err := engine.RenderString(salesTemplate, sales)
 	if err != nil {
 		log.Fatal(err)
 	}
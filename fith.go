@@ -25,14 +25,18 @@
 //	Conditionals:    {{if .IsActive}}...{{end}}
 //	Loops:           {{range .Items}}...{{end}}
 //	Functions:       {{upper .Name}}
-//	Filters:         {{.Name | upper | trim}}
+//	Filters:         {{.Name | upper | truncate 20}}
 //	Includes:        {{include "header"}}
 //	Layouts:         {{extends "layout"}} {{block "content"}}...{{end}}
 package fith
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/fs"
+	"path"
+	"strings"
 	"sync"
 
 	"github.com/toutaio/toutago-fith-renderer/compiler"
@@ -71,6 +75,7 @@ func New(config *Config) (*Engine, error) {
 
 	// Initialize compiler
 	engine.compiler = compiler.NewCompiler(engine.loader)
+	engine.compiler.SetAutoEscapeFunc(engine.shouldAutoEscape)
 
 	return engine, nil
 }
@@ -85,10 +90,16 @@ func NewWithDefaults() (*Engine, error) {
 func (e *Engine) initializeLoader() {
 	if e.config.TemplateFS != nil {
 		// Use embedded filesystem
-		e.loader = loader.NewEmbedLoader(e.config.TemplateFS, ".", e.config.Extensions)
+		l := loader.NewEmbedLoader(e.config.TemplateFS, ".", e.config.Extensions)
+		l.SetDelimiters(e.config.LeftDelimiter, e.config.RightDelimiter)
+		l.SetBlockTrimming(e.config.TrimBlocks, e.config.LStripBlocks)
+		e.loader = l
 	} else {
 		// Use directory loader
-		e.loader = loader.NewFileSystemLoader(e.config.TemplateDir, e.config.Extensions)
+		l := loader.NewFileSystemLoader(e.config.TemplateDir, e.config.Extensions)
+		l.SetDelimiters(e.config.LeftDelimiter, e.config.RightDelimiter)
+		l.SetBlockTrimming(e.config.TrimBlocks, e.config.LStripBlocks)
+		e.loader = l
 	}
 }
 
@@ -105,27 +116,67 @@ func (e *Engine) initializeLoader() {
 //	}
 //	html, err := engine.Render("home", data)
 func (e *Engine) Render(slug string, data interface{}) (string, error) {
+	return e.RenderContext(context.Background(), slug, data)
+}
+
+// RenderContext is like Render but stops once ctx is cancelled or its
+// deadline passes. Range loops, includes and layouts check ctx as they run,
+// and the returned error wraps ctx.Err() along with the template and line
+// that was executing. ctx is also passed to functions registered with
+// RegisterContextFunction.
+//
+// Example:
+//
+//	html, err := engine.RenderContext(r.Context(), "reports/list", data)
+//	if errors.Is(err, context.Canceled) {
+//	    return // client went away
+//	}
+func (e *Engine) RenderContext(ctx context.Context, slug string, data interface{}) (string, error) {
+	var buf strings.Builder
+	if _, err := e.RenderToContext(ctx, &buf, slug, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderTo renders a template directly to w and returns the number of bytes written.
+//
+// Output is streamed through a small buffer instead of being collected in
+// memory, so large outputs can go straight to an http.ResponseWriter or file.
+// Execution stops at the first write error. On error, output rendered before
+// the failure may already have been written to w.
+//
+// Example:
+//
+//	n, err := engine.RenderTo(w, "reports/export", data)
+func (e *Engine) RenderTo(w io.Writer, slug string, data interface{}) (int64, error) {
+	return e.RenderToContext(context.Background(), w, slug, data)
+}
+
+// RenderToContext is like RenderTo but honours cancellation of ctx.
+// See RenderContext.
+func (e *Engine) RenderToContext(ctx context.Context, w io.Writer, slug string, data interface{}) (int64, error) {
 	// Compile the template
 	compiled, err := e.compile(slug)
 	if err != nil {
-		return "", WrapError(ErrorTypeCompilation, fmt.Sprintf("failed to compile template '%s'", slug), err)
+		return 0, WrapError(ErrorTypeCompilation, fmt.Sprintf("failed to compile template '%s'", slug), err)
 	}
 
 	// Create runtime context
-	ctx := runtime.NewContext(data)
-	ctx.Set("@slug", slug)
+	dataCtx := runtime.NewContext(data)
+	dataCtx.Set("@slug", slug)
 
 	// Create runtime and register functions
-	rt := runtime.NewRuntime(ctx)
-	e.copyFunctionsToRuntime(rt)
+	rt := e.newRuntime(ctx, dataCtx, compiled.AutoEscape)
+	rt.SetTemplateName(slug)
 
 	// Execute the template
-	output, err := e.execute(rt, compiled.AST)
+	n, err := e.execute(rt, w, compiled.AST)
 	if err != nil {
-		return "", WrapError(ErrorTypeRuntime, fmt.Sprintf("failed to execute template '%s'", slug), err)
+		return n, wrapRuntimeError(fmt.Sprintf("failed to execute template '%s'", slug), err)
 	}
 
-	return output, nil
+	return n, nil
 }
 
 // RenderString renders a template string directly without loading from a file.
@@ -136,26 +187,47 @@ func (e *Engine) Render(slug string, data interface{}) (string, error) {
 //	    "Name": "World",
 //	})
 func (e *Engine) RenderString(template string, data interface{}) (string, error) {
+	return e.RenderStringContext(context.Background(), template, data)
+}
+
+// RenderStringContext is like RenderString but honours cancellation of ctx.
+// See RenderContext.
+func (e *Engine) RenderStringContext(ctx context.Context, template string, data interface{}) (string, error) {
+	var buf strings.Builder
+	if _, err := e.RenderStringToContext(ctx, &buf, template, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderStringTo renders a template string directly to w and returns the
+// number of bytes written. See RenderTo for streaming behaviour.
+func (e *Engine) RenderStringTo(w io.Writer, template string, data interface{}) (int64, error) {
+	return e.RenderStringToContext(context.Background(), w, template, data)
+}
+
+// RenderStringToContext is like RenderStringTo but honours cancellation of ctx.
+// See RenderContext.
+func (e *Engine) RenderStringToContext(ctx context.Context, w io.Writer, template string, data interface{}) (int64, error) {
 	// Parse the template
 	tmpl, err := e.parseString(template)
 	if err != nil {
-		return "", WrapError(ErrorTypeTemplate, "failed to parse template string", err)
+		return 0, WrapError(ErrorTypeTemplate, "failed to parse template string", err)
 	}
 
 	// Create runtime context
-	ctx := runtime.NewContext(data)
+	dataCtx := runtime.NewContext(data)
 
 	// Create runtime and register functions
-	rt := runtime.NewRuntime(ctx)
-	e.copyFunctionsToRuntime(rt)
+	rt := e.newRuntime(ctx, dataCtx, e.config.AutoEscape)
 
 	// Execute the template
-	output, err := e.execute(rt, tmpl)
+	n, err := e.execute(rt, w, tmpl)
 	if err != nil {
-		return "", WrapError(ErrorTypeRuntime, "failed to execute template string", err)
+		return n, wrapRuntimeError("failed to execute template string", err)
 	}
 
-	return output, nil
+	return n, nil
 }
 
 // RegisterFunction registers a custom function that can be used in templates.
@@ -181,6 +253,30 @@ func (e *Engine) RegisterFunction(name string, fn runtime.Function) {
 	e.functions.Register(name, fn)
 }
 
+// RegisterContextFunction registers a custom function that receives the
+// context.Context passed to RenderContext (context.Background() for Render).
+//
+// Example:
+//
+//	engine.RegisterContextFunction("currentUser", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+//	    return ctx.Value(userKey{}), nil
+//	})
+func (e *Engine) RegisterContextFunction(name string, fn runtime.ContextFunction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.functions.RegisterContext(name, fn)
+}
+
+// SetPipeArg sets where pipelines pass the piped value to the named function.
+// By default it is the first argument, so {{.Body | truncate 100}} calls
+// truncate(.Body, 100). Functions set to runtime.PipeLast receive it last
+// instead, like the built-in date: {{.Created | date "YYYY-MM-DD"}}.
+func (e *Engine) SetPipeArg(name string, pos runtime.PipeArg) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.functions.SetPipeArg(name, pos)
+}
+
 // ClearCache clears all compiled template caches.
 func (e *Engine) ClearCache() {
 	e.compiler.ClearCache()
@@ -199,7 +295,12 @@ func (e *Engine) compile(slug string) (*compiler.CompiledTemplate, error) {
 		if err != nil {
 			return nil, err
 		}
-		return e.compiler.CompileWithoutCache(tmpl)
+		compiled, err := e.compiler.CompileWithoutCache(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		compiled.AutoEscape = e.shouldAutoEscape(slug)
+		return compiled, nil
 	}
 
 	// Use compiler's caching
@@ -208,18 +309,78 @@ func (e *Engine) compile(slug string) (*compiler.CompiledTemplate, error) {
 
 // parseString parses a template string.
 func (e *Engine) parseString(source string) (*parser.Template, error) {
-	l := lexer.New(source)
+	l := lexer.NewWithDelimiters(source, e.config.LeftDelimiter, e.config.RightDelimiter)
+	l.SetTrimBlocks(e.config.TrimBlocks)
+	l.SetLStripBlocks(e.config.LStripBlocks)
 	p := parser.New(l)
 	return p.Parse()
 }
 
-// execute executes a parsed template with the given runtime.
-func (e *Engine) execute(rt *runtime.Runtime, tmpl *parser.Template) (string, error) {
+// newRuntime creates a composition-aware runtime wired to the engine's loader,
+// so include, extends and block directives resolve through the same compile cache.
+func (e *Engine) newRuntime(ctx context.Context, data *runtime.Context, autoEscape bool) *runtime.CompositionRuntime {
+	rt := runtime.NewCompositionRuntime(data, &compiledLoader{engine: e})
+	rt.SetCancelContext(ctx)
+	rt.SetMaxIncludeDepth(e.config.MaxIncludeDepth)
+	rt.SetAutoEscape(autoEscape)
+	rt.SetStrictMode(e.config.StrictMode)
+	rt.SetStrictComparisons(e.config.StrictComparisons)
+	rt.SetDecimalArithmetic(e.config.DecimalArithmetic)
+	rt.SetMethodCalls(!e.config.DisableMethodCalls)
+	rt.SetFieldOptions(runtime.FieldOptions{
+		Tags:            e.config.FieldTags,
+		CaseInsensitive: e.config.CaseInsensitiveFields,
+	})
+	rt.SetLimits(runtime.Limits(e.config.Limits))
+	e.copyFunctionsToRuntime(rt.Runtime)
+	return rt
+}
+
+// shouldAutoEscape reports whether output of the given template is auto-escaped,
+// either engine-wide or because its extension is listed in AutoEscapeExtensions.
+// It is called when the template is compiled; renders use CompiledTemplate.AutoEscape.
+func (e *Engine) shouldAutoEscape(slug string) bool {
+	if e.config.AutoEscape {
+		return true
+	}
+	if len(e.config.AutoEscapeExtensions) == 0 {
+		return false
+	}
+
+	ext := e.templateExtension(slug)
+	for _, escapeExt := range e.config.AutoEscapeExtensions {
+		if strings.EqualFold(escapeExt, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateExtension returns the extension the loader resolves slug to,
+// following the same order as the loaders (configured extensions first).
+func (e *Engine) templateExtension(slug string) string {
+	for _, ext := range e.config.Extensions {
+		if e.loader.Exists(slug + ext) {
+			return ext
+		}
+		if strings.HasSuffix(slug, ext) {
+			return ext
+		}
+	}
+	return path.Ext(slug)
+}
+
+// execute executes a parsed template with the given runtime, streaming the
+// output to w, and returns the number of bytes written.
+func (e *Engine) execute(rt *runtime.CompositionRuntime, w io.Writer, tmpl *parser.Template) (int64, error) {
+	rt.SetOutput(w)
 	err := rt.ExecuteTemplate(tmpl)
-	if err != nil {
-		return "", err
+
+	// Flush whatever was rendered, even if execution failed part way
+	if flushErr := rt.Flush(); err == nil {
+		err = flushErr
 	}
-	return rt.Output(), nil
+	return rt.BytesWritten(), err
 }
 
 // copyFunctionsToRuntime copies all registered functions to the runtime.
@@ -232,7 +393,39 @@ func (e *Engine) copyFunctionsToRuntime(rt *runtime.Runtime) {
 	// to optimize this by sharing the function registry.
 	for name, fn := range e.functions.AllFunctions() {
 		rt.RegisterFunction(name, fn)
+		rt.SetPipeArg(name, e.functions.PipeArg(name))
 	}
+	for name, fn := range e.functions.AllContextFunctions() {
+		rt.RegisterContextFunction(name, fn)
+		rt.SetPipeArg(name, e.functions.PipeArg(name))
+	}
+}
+
+// compiledLoader adapts the engine to runtime.Loader, returning compiled
+// (optimized and, if enabled, cached) templates for includes and layouts.
+type compiledLoader struct {
+	engine *Engine
+}
+
+// Load compiles the template identified by slug and returns its AST.
+func (l *compiledLoader) Load(slug string) (*parser.Template, error) {
+	compiled, err := l.engine.compile(slug)
+	if err != nil {
+		return nil, err
+	}
+	return compiled.AST, nil
+}
+
+// AutoEscape reports whether the template identified by slug is auto-escaped,
+// as decided when it was compiled.
+func (l *compiledLoader) AutoEscape(slug string) bool {
+	compiled, err := l.engine.compile(slug)
+	return err == nil && compiled.AutoEscape
+}
+
+// Exists checks if a template exists in the engine's loader.
+func (l *compiledLoader) Exists(slug string) bool {
+	return l.engine.loader.Exists(slug)
 }
 
 // Config returns a copy of the engine's configuration.
@@ -1,10 +1,16 @@
 package fith
 
 import (
+	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/runtime"
 )
 
 //go:embed testdata/*.html
@@ -324,6 +330,26 @@ func TestRegisterFunction(t *testing.T) {
 	}
 }
 
+func TestSetPipeArg(t *testing.T) {
+	engine, err := NewWithDefaults()
+	if err != nil {
+		t.Fatalf("NewWithDefaults() error = %v", err)
+	}
+
+	engine.RegisterFunction("prefix", func(args ...interface{}) (interface{}, error) {
+		return fmt.Sprint(args[0]) + fmt.Sprint(args[1]), nil
+	})
+	engine.SetPipeArg("prefix", runtime.PipeLast)
+
+	got, err := engine.RenderString(`{{.Name | prefix "Dr. " | upper}}`, map[string]interface{}{"Name": "Who"})
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if want := "DR. WHO"; got != want {
+		t.Errorf("RenderString() = %q, want %q", got, want)
+	}
+}
+
 func TestExists(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -452,3 +478,547 @@ func BenchmarkRenderWithCache(b *testing.B) {
 		}
 	}
 }
+
+func TestRenderComposition(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	templates := map[string]string{
+		"layout.html": `<main>{{block "content"}}Default{{end}}</main>`,
+		"header.html": `<h1>{{shout .Title}}</h1>`,
+		"page.html":   `{{extends "layout"}}{{block "content"}}{{include "header"}}{{shout .Body}}{{end}}`,
+		"loop.html":   `{{range .Items}}{{include "item"}}{{end}}`,
+		"item.html":   `<li>{{.}}</li>`,
+		"deep-a.html": `{{include "deep-b"}}`,
+		"deep-b.html": `{{include "deep-c"}}`,
+		"deep-c.html": `C`,
+	}
+
+	for name, content := range templates {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create test template: %v", err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.TemplateDir = tmpDir
+	config.MaxIncludeDepth = 1
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	engine.RegisterFunction("shout", func(args ...interface{}) (interface{}, error) {
+		return fmt.Sprint(args[0]) + "!", nil
+	})
+
+	got, err := engine.Render("page", map[string]interface{}{"Title": "Hi", "Body": "text"})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := "<main><h1>Hi!</h1>text!</main>"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	got, err = engine.Render("loop", map[string]interface{}{"Items": []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := "<li>a</li><li>b</li>"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	got, err = engine.RenderString(`[{{include "item"}}]`, "x")
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if want := "[<li>x</li>]"; got != want {
+		t.Errorf("RenderString() = %q, want %q", got, want)
+	}
+
+	_, err = engine.Render("deep-a", nil)
+	var fe *Error
+	if !errors.As(err, &fe) || fe.Type != ErrorTypeLimit {
+		t.Errorf("expected ErrorTypeLimit when MaxIncludeDepth is exceeded, got %v", err)
+	}
+}
+
+func TestAutoEscapeConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	templates := map[string]string{
+		"page.html":  `<p>{{.Value}}</p>`,
+		"export.txt": `{{.Value}}`,
+		"card.html":  `<p>{{include "export"}}</p>`,
+		"mixed.txt":  `{{.Value}}{{include "page"}}`,
+		"base.html":  `<h1>{{.Value}}</h1>{{block "body"}}{{end}}`,
+		"note.txt":   `{{extends "base"}}{{block "body"}}{{.Value}}{{end}}`,
+	}
+
+	for name, content := range templates {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create test template: %v", err)
+		}
+	}
+
+	data := map[string]interface{}{"Value": "<b>"}
+
+	t.Run("per extension", func(t *testing.T) {
+		config := DefaultConfig()
+		config.TemplateDir = tmpDir
+		config.AutoEscapeExtensions = []string{".html"}
+		engine, err := New(&config)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		got, err := engine.Render("page", data)
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if want := "<p>&lt;b&gt;</p>"; got != want {
+			t.Errorf("Render() = %q, want %q", got, want)
+		}
+
+		got, err = engine.Render("export", data)
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if want := "<b>"; got != want {
+			t.Errorf("Render() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("includes and layouts use their own extension", func(t *testing.T) {
+		config := DefaultConfig()
+		config.TemplateDir = tmpDir
+		config.AutoEscapeExtensions = []string{".html"}
+		engine, err := New(&config)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		tests := []struct {
+			slug string
+			want string
+		}{
+			{"card", "<p><b></p>"},
+			{"mixed", "<b><p>&lt;b&gt;</p>"},
+			{"note", "<h1>&lt;b&gt;</h1><b>"},
+		}
+		for _, tt := range tests {
+			got, err := engine.Render(tt.slug, data)
+			if err != nil {
+				t.Fatalf("Render(%q) error = %v", tt.slug, err)
+			}
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.slug, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("engine wide", func(t *testing.T) {
+		config := DefaultConfig()
+		config.TemplateDir = tmpDir
+		config.AutoEscape = true
+		engine, err := New(&config)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		got, err := engine.RenderString(`<a href="{{.Value}}">`, map[string]interface{}{"Value": "javascript:x"})
+		if err != nil {
+			t.Fatalf("RenderString() error = %v", err)
+		}
+		if want := `<a href="#ZfithZ">`; got != want {
+			t.Errorf("RenderString() = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestStrictMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "profile.html"), []byte("Hi\n{{.User.Nmae}}"), 0o644); err != nil {
+		t.Fatalf("failed to create test template: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"User": map[string]interface{}{"Name": "Alice"},
+	}
+
+	config := DefaultConfig()
+	config.TemplateDir = tmpDir
+	lenient, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got, err := lenient.Render("profile", data)
+	if err != nil {
+		t.Fatalf("Render() error in lenient mode = %v", err)
+	}
+	if got != "Hi\n" {
+		t.Errorf("Render() = %q, want %q", got, "Hi\n")
+	}
+
+	config.StrictMode = true
+	strict, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err = strict.Render("profile", data)
+	var fithErr *Error
+	if !errors.As(err, &fithErr) {
+		t.Fatalf("expected *Error, got %T: %v", err, err)
+	}
+	if fithErr.Slug != "profile" || fithErr.Line != 2 || fithErr.Column != 3 {
+		t.Errorf("expected location profile:2:3, got %s:%d:%d", fithErr.Slug, fithErr.Line, fithErr.Column)
+	}
+
+	var undefined *runtime.UndefinedError
+	if !errors.As(err, &undefined) {
+		t.Fatalf("expected *runtime.UndefinedError in chain, got %v", err)
+	}
+	if undefined.Path != ".User.Nmae" || undefined.Suggestion != "Name" {
+		t.Errorf("unexpected undefined error: %v", undefined)
+	}
+}
+
+type accountView struct{ Owner string }
+
+func (a accountView) Title() string { return "Account of " + a.Owner }
+
+func (a accountView) Balance() (int, error) { return 0, errors.New("account locked") }
+
+func TestMethodCalls(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "account.html"), []byte("{{.Title}}\n{{.Balance}}"), 0o644); err != nil {
+		t.Fatalf("failed to create test template: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.TemplateDir = tmpDir
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got, err := engine.RenderString("{{.Title}}", accountView{Owner: "Ada"})
+	if err != nil || got != "Account of Ada" {
+		t.Errorf("RenderString() = %q, %v, want %q", got, err, "Account of Ada")
+	}
+
+	_, err = engine.Render("account", accountView{Owner: "Ada"})
+	var fithErr *Error
+	if !errors.As(err, &fithErr) {
+		t.Fatalf("expected *Error, got %T: %v", err, err)
+	}
+	if fithErr.Slug != "account" || fithErr.Line != 2 {
+		t.Errorf("expected location account:2, got %s:%d", fithErr.Slug, fithErr.Line)
+	}
+	var methodErr *runtime.MethodError
+	if !errors.As(err, &methodErr) || methodErr.Method != "Balance" {
+		t.Errorf("expected *runtime.MethodError for Balance in chain, got %v", err)
+	}
+
+	config.DisableMethodCalls = true
+	sandboxed, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	got, err = sandboxed.RenderString("[{{.Title}}]", accountView{Owner: "Ada"})
+	if err != nil || got != "[]" {
+		t.Errorf("RenderString() with methods disabled = %q, %v, want %q", got, err, "[]")
+	}
+}
+
+type apiUser struct {
+	FirstName string `json:"first_name"`
+	Token     string `json:"-"`
+}
+
+func TestFieldTags(t *testing.T) {
+	config := DefaultConfig()
+	config.TemplateDir = t.TempDir()
+	config.StrictMode = true
+	config.FieldTags = []string{"fith", "json"}
+	config.CaseInsensitiveFields = true
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	user := apiUser{FirstName: "Ada", Token: "secret"}
+	got, err := engine.RenderString("{{.first_name}} {{.FirstName}} {{.firstname}}", user)
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if got != "Ada Ada Ada" {
+		t.Errorf("RenderString() = %q, want %q", got, "Ada Ada Ada")
+	}
+
+	if _, err := engine.RenderString("{{.Token}}", user); err == nil {
+		t.Error("expected error for field hidden by its tag")
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	config := DefaultConfig()
+	config.TemplateDir = t.TempDir()
+	config.DecimalArithmetic = true
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	data := map[string]interface{}{"Prices": []float64{0.1, 0.2}}
+	got, err := engine.RenderString("{{.Prices[0] + .Prices[1]}} {{7 / 2}}", data)
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if got != "0.3 3" {
+		t.Errorf("RenderString() = %q, want %q", got, "0.3 3")
+	}
+}
+
+func TestCustomDelimiters(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "app.html"), []byte(`<div id="app">{{ count }}</div><%.Title%>`), 0o644); err != nil {
+		t.Fatalf("failed to create test template: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.TemplateDir = tmpDir
+	config.LeftDelimiter = "<%"
+	config.RightDelimiter = "%>"
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	data := map[string]interface{}{"Title": "Hello"}
+
+	got, err := engine.Render("app", data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := `<div id="app">{{ count }}</div>Hello`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	got, err = engine.RenderString(`{{ vue }} <% upper .Title %>`, data)
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if want := `{{ vue }} HELLO`; got != want {
+		t.Errorf("RenderString() = %q, want %q", got, want)
+	}
+}
+
+func TestCustomDelimiters_Brackets(t *testing.T) {
+	config := DefaultConfig()
+	config.TemplateDir = t.TempDir()
+	config.LeftDelimiter = "[["
+	config.RightDelimiter = "]]"
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	data := map[string]interface{}{"Items": []string{"a", "b"}}
+
+	got, err := engine.RenderString(`{{ vue }} [[.Items[0]]] [[range ["x", .Items[1]]]][[.]][[end]]`, data)
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if want := `{{ vue }} a xb`; got != want {
+		t.Errorf("RenderString() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderTo(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "rows.txt"), []byte("{{range .}}{{.}}\n{{end}}"), 0o644); err != nil {
+		t.Fatalf("failed to create test template: %v", err)
+	}
+
+	engine, err := NewWithDir(tmpDir)
+	if err != nil {
+		t.Fatalf("NewWithDir() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := engine.RenderTo(&buf, "rows", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("RenderTo() error = %v", err)
+	}
+	if buf.String() != "a\nb\n" || n != 4 {
+		t.Errorf("RenderTo() = %q (%d bytes), want %q (4 bytes)", buf.String(), n, "a\nb\n")
+	}
+
+	buf.Reset()
+	n, err = engine.RenderStringTo(&buf, "Hello {{.}}", "World")
+	if err != nil {
+		t.Fatalf("RenderStringTo() error = %v", err)
+	}
+	if buf.String() != "Hello World" || n != 11 {
+		t.Errorf("RenderStringTo() = %q (%d bytes), want %q (11 bytes)", buf.String(), n, "Hello World")
+	}
+
+	if _, err := engine.RenderTo(&buf, "missing", nil); err == nil {
+		t.Error("expected error for missing template")
+	}
+}
+
+func TestRenderContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"list.html": "<ul>\n{{range .}}<li>{{.}}</li>{{end}}</ul>",
+		"page.html": "{{include \"list\" .}}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create test template: %v", err)
+		}
+	}
+
+	engine, err := NewWithDir(tmpDir)
+	if err != nil {
+		t.Fatalf("NewWithDir() error = %v", err)
+	}
+
+	out, err := engine.RenderContext(context.Background(), "page", []int{1, 2})
+	if err != nil {
+		t.Fatalf("RenderContext() error = %v", err)
+	}
+	if out != "<ul>\n<li>1</li><li>2</li></ul>" {
+		t.Errorf("RenderContext() = %q", out)
+	}
+
+	t.Run("cancelled in loop", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		engine.RegisterContextFunction("tick", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			cancel()
+			return "", nil
+		})
+
+		_, err := engine.RenderStringContext(ctx, "a\n{{range .}}{{tick}}{{end}}", []int{1, 2})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		var fithErr *Error
+		if !errors.As(err, &fithErr) || fithErr.Line != 2 {
+			t.Errorf("expected *Error at line 2, got %#v", err)
+		}
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+
+		var buf bytes.Buffer
+		_, err := engine.RenderToContext(ctx, &buf, "page", []int{1, 2})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+		var fithErr *Error
+		if !errors.As(err, &fithErr) || fithErr.Slug != "page" {
+			t.Errorf("expected *Error naming template %q, got %#v", "page", err)
+		}
+	})
+
+	t.Run("context function", func(t *testing.T) {
+		type key struct{}
+		engine.RegisterContextFunction("requestID", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			return ctx.Value(key{}), nil
+		})
+
+		ctx := context.WithValue(context.Background(), key{}, "req-42")
+		out, err := engine.RenderStringContext(ctx, "{{requestID}}", nil)
+		if err != nil {
+			t.Fatalf("RenderStringContext() error = %v", err)
+		}
+		if out != "req-42" {
+			t.Errorf("RenderStringContext() = %q, want %q", out, "req-42")
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "email.html"), []byte("Hi\n{{range .}}{{.}}{{end}}"), 0o644); err != nil {
+		t.Fatalf("failed to create test template: %v", err)
+	}
+
+	engine, err := New(&Config{
+		TemplateDir:  tmpDir,
+		CacheEnabled: true,
+		Limits:       Limits{MaxLoopIterations: 2},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := engine.Render("email", []int{1, 2}); err != nil {
+		t.Fatalf("Render() within limits error = %v", err)
+	}
+
+	_, err = engine.Render("email", []int{1, 2, 3})
+	var fithErr *Error
+	if !errors.As(err, &fithErr) || fithErr.Type != ErrorTypeLimit {
+		t.Fatalf("expected ErrorTypeLimit error, got %v", err)
+	}
+	if fithErr.Slug != "email" || fithErr.Line != 2 {
+		t.Errorf("expected location email:2, got %s:%d", fithErr.Slug, fithErr.Line)
+	}
+
+	var limitErr *runtime.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != runtime.LimitLoopIterations {
+		t.Errorf("expected %s limit error, got %v", runtime.LimitLoopIterations, err)
+	}
+}
+
+func TestWhitespaceControl(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := "items:\n{{range .}}\n  - {{.}}\n{{end}}\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "list.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create test template: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.TemplateDir = tmpDir
+	config.Extensions = []string{".yaml"}
+	config.TrimBlocks = true
+	config.LStripBlocks = true
+	engine, err := New(&config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	want := "items:\n  - a\n  - b\n"
+
+	got, err := engine.Render("list", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	got, err = engine.RenderString(content, []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("RenderString() = %q, want %q", got, want)
+	}
+
+	got, err = engine.RenderString("a:\n  {{- range .}} {{.}} {{- end}}", []string{"b", "c"})
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if want := "a: b c"; got != want {
+		t.Errorf("RenderString() = %q, want %q", got, want)
+	}
+}
@@ -2,40 +2,102 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// Default delimiters for template expressions.
+const (
+	DefaultLeftDelim  = "{{"
+	DefaultRightDelim = "}}"
+)
+
+// Comment markers. A comment is written as {{/* ... */}} and may span lines.
+const (
+	commentStart = "/*"
+	commentEnd   = "*/"
+)
+
+// whitespace holds the characters removed by trim markers.
+const whitespace = " \t\r\n"
+
 // Lexer tokenizes template input into a stream of tokens.
 // It maintains position information for error reporting.
 type Lexer struct {
-	input     string // The input string being lexed
-	pos       int    // Current position in input (bytes)
-	line      int    // Current line number (1-indexed)
-	column    int    // Current column number (1-indexed)
-	start     int    // Start position of current token
-	startLine int    // Line number at token start
-	startCol  int    // Column number at token start
-	inExpr    bool   // True if inside template expression {{...}}
+	input        string // The input string being lexed
+	pos          int    // Current position in input (bytes)
+	line         int    // Current line number (1-indexed)
+	column       int    // Current column number (1-indexed)
+	start        int    // Start position of current token
+	startLine    int    // Line number at token start
+	startCol     int    // Column number at token start
+	inExpr       bool   // True if inside template expression {{...}}
+	leftDelim    string // Opening delimiter, e.g. "{{"
+	rightDelim   string // Closing delimiter, e.g. "}}"
+	keepComments bool   // Emit TokenComment instead of discarding comments
+	trimBlocks   bool   // Remove the first newline after a control tag
+	lstripBlocks bool   // Remove whitespace before a control tag at the start of a line
+	controlTag   bool   // True if the current expression is a control tag
+	trimSpace    bool   // Skip whitespace before the next text ("-}}" marker)
+	trimNewline  bool   // Skip one newline before the next text (TrimBlocks)
+	braceDepth   int    // Open { of map literals in the current expression
+	bracketDepth int    // Open [ of indexes and list literals in the current expression
 }
 
-// New creates a new Lexer for the given input string.
+// New creates a new Lexer for the given input string using the default {{ }} delimiters.
 func New(input string) *Lexer {
+	return NewWithDelimiters(input, DefaultLeftDelim, DefaultRightDelim)
+}
+
+// NewWithDelimiters creates a new Lexer that recognizes expressions between
+// the given delimiters, e.g. "[%" and "%]". Empty delimiters fall back to the defaults.
+func NewWithDelimiters(input, left, right string) *Lexer {
+	if left == "" {
+		left = DefaultLeftDelim
+	}
+	if right == "" {
+		right = DefaultRightDelim
+	}
 	return &Lexer{
-		input:  input,
-		pos:    0,
-		line:   1,
-		column: 1,
+		input:      input,
+		pos:        0,
+		line:       1,
+		column:     1,
+		leftDelim:  left,
+		rightDelim: right,
 	}
 }
 
+// SetKeepComments controls whether {{/* ... */}} comments are returned as
+// TokenComment tokens (for formatters or doc generators) or discarded.
+// Comments inside expressions are always discarded.
+func (l *Lexer) SetKeepComments(keep bool) {
+	l.keepComments = keep
+}
+
+// SetTrimBlocks removes the first newline after a control tag (if, else,
+// elif, range, with, set, end, block, extends) or comment.
+func (l *Lexer) SetTrimBlocks(trim bool) {
+	l.trimBlocks = trim
+}
+
+// SetLStripBlocks removes spaces and tabs between the start of a line and a
+// control tag or comment. Together with SetTrimBlocks, a line holding only a
+// control tag disappears from the output.
+func (l *Lexer) SetLStripBlocks(lstrip bool) {
+	l.lstripBlocks = lstrip
+}
+
 // NextToken returns the next token from the input.
 // Returns TokenEOF when the end of input is reached.
 // Returns a token with TokenError type if invalid syntax is encountered.
 func (l *Lexer) NextToken() (Token, error) {
-	// Skip whitespace if inside expression
+	// Skip whitespace if inside expression, or whitespace trimmed after a tag
 	if l.inExpr {
 		l.skipWhitespace()
+	} else {
+		l.skipTrimmed()
 	}
 
 	// Mark start of new token
@@ -57,24 +119,22 @@ func (l *Lexer) NextToken() (Token, error) {
 	return l.scanExpression()
 }
 
-// scanText scans literal text until we encounter {{.
+// scanText scans literal text until we encounter the opening delimiter.
 func (l *Lexer) scanText() (Token, error) {
 	start := l.pos
 
 	for l.pos < len(l.input) {
 		// Look for opening delimiter
-		if l.pos+1 < len(l.input) && l.input[l.pos] == '{' && l.input[l.pos+1] == '{' {
+		if strings.HasPrefix(l.input[l.pos:], l.leftDelim) {
 			// Found opening delimiter
 			if l.pos > start {
-				// Return text token before delimiter
-				text := l.input[start:l.pos]
-				return l.makeToken(TokenText, text), nil
+				// Return text token before delimiter, minus trimmed whitespace
+				text := l.trimTextBefore(l.input[start:l.pos])
+				if text != "" {
+					return l.makeToken(TokenText, text), nil
+				}
 			}
-			// No text before delimiter, switch to expression mode
-			l.inExpr = true
-			l.advance()
-			l.advance()
-			return l.makeToken(TokenOpenDelim, "{{"), nil
+			return l.scanOpenDelim()
 		}
 
 		l.advance()
@@ -89,16 +149,190 @@ func (l *Lexer) scanText() (Token, error) {
 	return l.makeToken(TokenEOF, ""), nil
 }
 
+// trimTextBefore removes whitespace from the end of text, which precedes the
+// tag at the current position: all of it for a "{{-" marker, or with
+// LStripBlocks the indentation before a control tag that starts a line.
+func (l *Lexer) trimTextBefore(text string) string {
+	if l.hasTrimMarker(l.pos + len(l.leftDelim)) {
+		return strings.TrimRight(text, whitespace)
+	}
+	if !l.lstripBlocks || !l.isControlTag(l.pos) {
+		return text
+	}
+
+	indent := strings.TrimRight(text, " \t")
+	lineStart := strings.HasSuffix(indent, "\n") || (indent == "" && l.startCol == 1)
+	if lineStart {
+		return indent
+	}
+	return text
+}
+
+// scanOpenDelim scans the opening delimiter at the current position, with
+// its optional "-" trim marker, or a whole comment.
+func (l *Lexer) scanOpenDelim() (Token, error) {
+	// The delimiter token starts here, even if trimmed text preceded it
+	l.start, l.startLine, l.startCol = l.pos, l.line, l.column
+
+	l.controlTag = l.isControlTag(l.pos)
+	trim := l.hasTrimMarker(l.pos + len(l.leftDelim))
+
+	// Comments are skipped entirely
+	rest := l.input[l.pos+len(l.leftDelim):]
+	if trim {
+		rest = strings.TrimLeft(rest[1:], whitespace)
+	}
+	if strings.HasPrefix(rest, commentStart) {
+		return l.scanComment()
+	}
+
+	// Switch to expression mode
+	l.inExpr = true
+	l.braceDepth = 0
+	l.bracketDepth = 0
+	l.advanceBytes(len(l.leftDelim))
+	if trim {
+		l.advance() // Skip -
+	}
+	return l.makeToken(TokenOpenDelim, l.leftDelim), nil
+}
+
+// scanComment scans a {{/* ... */}} comment, which may carry trim markers
+// as in {{- /* ... */ -}}. The comment is discarded unless comments are
+// kept, in which case its text is returned.
+func (l *Lexer) scanComment() (Token, error) {
+	l.advanceBytes(len(l.leftDelim))
+	if l.peek() == '-' {
+		l.advance()
+		l.skipWhitespace()
+	}
+	l.advanceBytes(len(commentStart))
+	textStart := l.pos
+
+	for {
+		end := strings.Index(l.input[l.pos:], commentEnd)
+		if end < 0 {
+			return l.errorToken("unterminated comment")
+		}
+		text := l.input[textStart : l.pos+end]
+		l.advanceBytes(end + len(commentEnd))
+
+		if n, trim := l.closeDelimLen(); n > 0 {
+			l.advanceBytes(n)
+			l.endTag(trim)
+			if l.keepComments {
+				return l.makeToken(TokenComment, text), nil
+			}
+			return l.NextToken()
+		}
+	}
+}
+
+// closeDelimLen returns the length of the closing delimiter at the current
+// position, including a " -" trim marker before it, or 0 if there is none.
+func (l *Lexer) closeDelimLen() (n int, trim bool) {
+	rest := l.input[l.pos:]
+	if strings.HasPrefix(rest, l.rightDelim) {
+		return len(l.rightDelim), false
+	}
+	trimmed := strings.TrimLeft(rest, whitespace)
+	if len(trimmed) < len(rest) && strings.HasPrefix(trimmed, "-"+l.rightDelim) {
+		return len(rest) - len(trimmed) + 1 + len(l.rightDelim), true
+	}
+	return 0, false
+}
+
+// endTag records how the text after a closing delimiter is trimmed.
+func (l *Lexer) endTag(trim bool) {
+	l.trimSpace = trim
+	l.trimNewline = l.trimBlocks && l.controlTag
+	l.controlTag = false
+}
+
+// skipTrimmed skips the whitespace removed after a "-}}" marker, or with
+// TrimBlocks the newline after a control tag.
+func (l *Lexer) skipTrimmed() {
+	switch {
+	case l.trimSpace:
+		for l.pos < len(l.input) && strings.IndexByte(whitespace, l.input[l.pos]) >= 0 {
+			l.advance()
+		}
+	case l.trimNewline:
+		if strings.HasPrefix(l.input[l.pos:], "\r\n") {
+			l.advanceBytes(2)
+		} else if l.peek() == '\n' {
+			l.advance()
+		}
+	}
+	l.trimSpace, l.trimNewline = false, false
+}
+
+// hasTrimMarker reports whether a "-" trim marker followed by whitespace is at pos.
+func (l *Lexer) hasTrimMarker(pos int) bool {
+	return pos+1 < len(l.input) && l.input[pos] == '-' &&
+		strings.IndexByte(whitespace, l.input[pos+1]) >= 0
+}
+
+// isControlTag reports whether the tag opening at pos is a comment or starts
+// with a keyword other than include. Such tags produce no output, so
+// TrimBlocks and LStripBlocks remove the whitespace around them.
+func (l *Lexer) isControlTag(pos int) bool {
+	rest := l.input[pos+len(l.leftDelim):]
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	}
+	rest = strings.TrimLeft(rest, whitespace)
+	if strings.HasPrefix(rest, commentStart) {
+		return true
+	}
+
+	end := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		end = len(rest)
+	}
+	tokType, ok := IsKeyword(rest[:end])
+	return ok && tokType != TokenInclude
+}
+
 // scanExpression scans a single token inside a template expression.
 func (l *Lexer) scanExpression() (Token, error) {
+	// Comments inside expressions are skipped
+	if strings.HasPrefix(l.input[l.pos:], commentStart) {
+		end := strings.Index(l.input[l.pos+len(commentStart):], commentEnd)
+		if end < 0 {
+			return l.errorToken("unterminated comment")
+		}
+		l.advanceBytes(len(commentStart) + end + len(commentEnd))
+		return l.NextToken()
+	}
+
 	ch := l.peek()
 
-	// Check for closing delimiter
-	if ch == '}' && l.peekAhead(1) == '}' {
-		l.advance()
+	// Inside a map literal } closes the map, so {{{"a": {"b": 1}}}} ends
+	// with two braces followed by the delimiter
+	if ch == '{' || (ch == '}' && l.braceDepth > 0) {
+		return l.scanBrace(ch), nil
+	}
+
+	// Likewise ] closes an open index or list, so with [[ ]] delimiters
+	// [[.Items[0]]] ends with a bracket followed by the delimiter
+	if ch == ']' && l.bracketDepth > 0 {
+		l.bracketDepth--
 		l.advance()
+		return l.makeToken(TokenRBrack, "]"), nil
+	}
+
+	// Check for closing delimiter, with an optional " -" trim marker before it
+	trim := strings.HasPrefix(l.input[l.pos:], "-"+l.rightDelim) &&
+		l.pos > 0 && strings.IndexByte(whitespace, l.input[l.pos-1]) >= 0
+	if trim || strings.HasPrefix(l.input[l.pos:], l.rightDelim) {
+		if trim {
+			l.advance() // Skip -
+		}
+		l.advanceBytes(len(l.rightDelim))
 		l.inExpr = false
-		return l.makeToken(TokenCloseDelim, "}}"), nil
+		l.endTag(trim)
+		return l.makeToken(TokenCloseDelim, l.rightDelim), nil
 	}
 
 	// Try single character tokens
@@ -126,10 +360,26 @@ func (l *Lexer) scanExpression() (Token, error) {
 		return l.scanIdentifier()
 	}
 
+	// Variables
+	if ch == '$' {
+		return l.scanVariable()
+	}
+
 	// Unknown character
 	return l.errorToken(fmt.Sprintf("unexpected character: %q", ch))
 }
 
+// scanBrace scans the { or } of a map literal, tracking the nesting depth.
+func (l *Lexer) scanBrace(ch byte) Token {
+	l.advance()
+	if ch == '{' {
+		l.braceDepth++
+		return l.makeToken(TokenLBrace, "{")
+	}
+	l.braceDepth--
+	return l.makeToken(TokenRBrace, "}")
+}
+
 // trySingleCharToken attempts to scan a single character token.
 func (l *Lexer) trySingleCharToken(ch byte) (Token, bool) {
 	var tokType TokenType
@@ -150,14 +400,13 @@ func (l *Lexer) trySingleCharToken(ch byte) (Token, bool) {
 		tokType, lexeme = TokenMod, "%"
 	case ',':
 		tokType, lexeme = TokenComma, ","
-	case ':':
-		tokType, lexeme = TokenColon, ":"
 	case '(':
 		tokType, lexeme = TokenLParen, "("
 	case ')':
 		tokType, lexeme = TokenRParen, ")"
 	case '[':
 		tokType, lexeme = TokenLBrack, "["
+		l.bracketDepth++
 	case ']':
 		tokType, lexeme = TokenRBrack, "]"
 	default:
@@ -179,6 +428,14 @@ func (l *Lexer) tryMultiCharOperator(ch byte) (Token, error) {
 		}
 		return l.makeToken(TokenAssign, "="), nil
 
+	case ':':
+		l.advance()
+		if l.peek() == '=' {
+			l.advance()
+			return l.makeToken(TokenDeclare, ":="), nil
+		}
+		return l.makeToken(TokenColon, ":"), nil
+
 	case '!':
 		l.advance()
 		if l.peek() == '=' {
@@ -219,6 +476,18 @@ func (l *Lexer) tryMultiCharOperator(ch byte) (Token, error) {
 			return l.makeToken(TokenOr, "||"), nil
 		}
 		return l.makeToken(TokenPipe, "|"), nil
+
+	case '?':
+		l.advance()
+		switch l.peek() {
+		case '?':
+			l.advance()
+			return l.makeToken(TokenCoalesce, "??"), nil
+		case '.':
+			l.advance()
+			return l.makeToken(TokenSafeDot, "?."), nil
+		}
+		return l.makeToken(TokenQuestion, "?"), nil
 	}
 
 	return Token{}, nil
@@ -299,6 +568,20 @@ func (l *Lexer) scanIdentifier() (Token, error) {
 
 	value := l.input[start:l.pos]
 
+	// A name directly after . or ?. is a field or key, even if it is a
+	// keyword, so {{.range}} and {{.User.with}} still work
+	if start > 0 && l.input[start-1] == '.' && !strings.HasSuffix(l.input[:start], l.leftDelim) {
+		return l.makeToken(TokenIdent, value), nil
+	}
+
+	// "not" followed by "in" is the single operator "not in"
+	if value == "not" {
+		if n := l.inOperatorLength(); n > 0 {
+			l.advanceBytes(n)
+			return l.makeToken(TokenNotIn, "not in"), nil
+		}
+	}
+
 	// Check if it's a keyword
 	if tokType, ok := IsKeyword(value); ok {
 		return l.makeToken(tokType, value), nil
@@ -307,16 +590,52 @@ func (l *Lexer) scanIdentifier() (Token, error) {
 	return l.makeToken(TokenIdent, value), nil
 }
 
+// inOperatorLength returns the length of the whitespace and "in" word
+// that follow, or 0 if the next word is not "in".
+func (l *Lexer) inOperatorLength() int {
+	pos := l.pos
+	for pos < len(l.input) && strings.IndexByte(" \t\r\n", l.input[pos]) >= 0 {
+		pos++
+	}
+	if pos == l.pos || !strings.HasPrefix(l.input[pos:], "in") {
+		return 0
+	}
+	end := pos + len("in")
+	if end < len(l.input) {
+		if ch := rune(l.input[end]); unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '_' {
+			return 0
+		}
+	}
+	return end - l.pos
+}
+
+// scanVariable scans a variable like $item. The token value includes the $.
+func (l *Lexer) scanVariable() (Token, error) {
+	start := l.pos
+	l.advance() // Skip $
+
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		if unicode.IsLetter(rune(ch)) || unicode.IsDigit(rune(ch)) || ch == '_' {
+			l.advance()
+		} else {
+			break
+		}
+	}
+
+	if l.pos == start+1 {
+		return l.errorToken("expected variable name after $")
+	}
+
+	return l.makeToken(TokenVar, l.input[start:l.pos]), nil
+}
+
 // skipWhitespace skips whitespace characters.
 func (l *Lexer) skipWhitespace() {
 	for l.pos < len(l.input) {
 		ch := l.input[l.pos]
 		if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' {
-			if ch == '\n' {
-				l.line++
-				l.column = 0
-			}
-			l.advance()
+			l.advance() // Tracks line and column
 		} else {
 			break
 		}
@@ -354,6 +673,14 @@ func (l *Lexer) advance() {
 	}
 }
 
+// advanceBytes advances past the next n bytes of input.
+func (l *Lexer) advanceBytes(n int) {
+	end := l.pos + n
+	for l.pos < end && l.pos < len(l.input) {
+		l.advance()
+	}
+}
+
 // makeToken creates a token with current position information.
 func (l *Lexer) makeToken(typ TokenType, value string) Token {
 	return Token{
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -139,6 +140,10 @@ func TestLexer_Keywords(t *testing.T) {
 		{"{{include \"header\"}}", TokenInclude},
 		{"{{extends \"layout\"}}", TokenExtends},
 		{"{{block \"content\"}}", TokenBlock},
+		{"{{set $x = 1}}", TokenSet},
+		{"{{with .User}}", TokenWith},
+		{"{{break}}", TokenBreak},
+		{"{{continue}}", TokenContinue},
 	}
 
 	for _, tt := range tests {
@@ -158,6 +163,28 @@ func TestLexer_Keywords(t *testing.T) {
 	}
 }
 
+func TestLexer_KeywordsAsFields(t *testing.T) {
+	for _, input := range []string{"{{.set}}", "{{.User.with}}", "{{.X?.in}}", "{{$v.range}}", "{{@parent.break}}"} {
+		tokens, err := New(input).All()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		field := tokens[len(tokens)-3]
+		if field.Type != TokenIdent {
+			t.Errorf("%s: expected field name as IDENT, got %v", input, field)
+		}
+	}
+
+	tokens, _ := New("{{if .A}}").All()
+	if tokens[1].Type != TokenIf {
+		t.Errorf("expected keyword before a field, got %v", tokens[1])
+	}
+	tokens, _ = NewWithDelimiters("<.if .A.>", "<.", ".>").All()
+	if tokens[1].Type != TokenIf {
+		t.Errorf("expected keyword after a delimiter ending in a dot, got %v", tokens[1])
+	}
+}
+
 func TestLexer_Operators(t *testing.T) {
 	tests := []struct {
 		input string
@@ -187,6 +214,15 @@ func TestLexer_Operators(t *testing.T) {
 		{"{{.A && .B}}", []TokenType{TokenOpenDelim, TokenDot, TokenIdent, TokenAnd, TokenDot, TokenIdent, TokenCloseDelim}},
 		{"{{.A || .B}}", []TokenType{TokenOpenDelim, TokenDot, TokenIdent, TokenOr, TokenDot, TokenIdent, TokenCloseDelim}},
 		{"{{!.Active}}", []TokenType{TokenOpenDelim, TokenNot, TokenDot, TokenIdent, TokenCloseDelim}},
+		{
+			"{{.A ? .B : .C}}",
+			[]TokenType{
+				TokenOpenDelim, TokenDot, TokenIdent, TokenQuestion, TokenDot, TokenIdent,
+				TokenColon, TokenDot, TokenIdent, TokenCloseDelim,
+			},
+		},
+		{"{{.A ?? .B}}", []TokenType{TokenOpenDelim, TokenDot, TokenIdent, TokenCoalesce, TokenDot, TokenIdent, TokenCloseDelim}},
+		{"{{.A?.B}}", []TokenType{TokenOpenDelim, TokenDot, TokenIdent, TokenSafeDot, TokenIdent, TokenCloseDelim}},
 	}
 
 	for _, tt := range tests {
@@ -383,6 +419,33 @@ func TestLexer_LineAndColumn(t *testing.T) {
 	}
 }
 
+func TestLexer_NewlinesInExpression(t *testing.T) {
+	tokens, err := New("{{.A\n\n  .B}}\nx").All()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// .B is on line 3; each newline inside the expression counts once
+	want := []Token{
+		{Type: TokenOpenDelim, Value: "{{", Line: 1, Column: 1},
+		{Type: TokenDot, Value: ".", Line: 1, Column: 3},
+		{Type: TokenIdent, Value: "A", Line: 1, Column: 4},
+		{Type: TokenDot, Value: ".", Line: 3, Column: 3},
+		{Type: TokenIdent, Value: "B", Line: 3, Column: 4},
+		{Type: TokenCloseDelim, Value: "}}", Line: 3, Column: 5},
+		{Type: TokenText, Value: "\nx", Line: 3, Column: 7},
+		{Type: TokenEOF, Line: 4, Column: 2},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(want), len(tokens), tokens)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d: expected %v, got %v", i, want[i], tokens[i])
+		}
+	}
+}
+
 func TestLexer_ErrorUnterminatedString(t *testing.T) {
 	input := `{{"unterminated}`
 	l := New(input)
@@ -412,6 +475,247 @@ func TestLexer_SpecialLoopVars(t *testing.T) {
 	}
 }
 
+func TestLexer_Comments(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Token
+	}{
+		{
+			name:  "discarded between text",
+			input: "a{{/* note */}}b",
+			expected: []Token{
+				{Type: TokenText, Value: "a", Line: 1, Column: 1},
+				{Type: TokenText, Value: "b", Line: 1, Column: 16},
+				{Type: TokenEOF, Line: 1, Column: 17},
+			},
+		},
+		{
+			name:  "multi-line containing delimiters",
+			input: "{{/* {{.Old}}\n}} gone\n*/}}\n{{.Name}}",
+			expected: []Token{
+				{Type: TokenText, Value: "\n", Line: 3, Column: 5},
+				{Type: TokenOpenDelim, Value: "{{", Line: 4, Column: 1},
+				{Type: TokenDot, Value: ".", Line: 4, Column: 3},
+				{Type: TokenIdent, Value: "Name", Line: 4, Column: 4},
+				{Type: TokenCloseDelim, Value: "}}", Line: 4, Column: 8},
+				{Type: TokenEOF, Line: 4, Column: 10},
+			},
+		},
+		{
+			name:  "inside expression",
+			input: "{{.A /* why */ }}",
+			expected: []Token{
+				{Type: TokenOpenDelim, Value: "{{", Line: 1, Column: 1},
+				{Type: TokenDot, Value: ".", Line: 1, Column: 3},
+				{Type: TokenIdent, Value: "A", Line: 1, Column: 4},
+				{Type: TokenCloseDelim, Value: "}}", Line: 1, Column: 16},
+				{Type: TokenEOF, Line: 1, Column: 18},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := New(tt.input).All()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tokens) != len(tt.expected) {
+				t.Fatalf("expected %d tokens, got %d: %v", len(tt.expected), len(tokens), tokens)
+			}
+			for i, exp := range tt.expected {
+				if tokens[i] != exp {
+					t.Errorf("token %d: expected %v, got %v", i, exp, tokens[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLexer_KeepComments(t *testing.T) {
+	l := NewWithDelimiters("x[%/* keep\nme */%]y", "[%", "%]")
+	l.SetKeepComments(true)
+
+	tokens, err := l.All()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Token{
+		{Type: TokenText, Value: "x", Line: 1, Column: 1},
+		{Type: TokenComment, Value: " keep\nme ", Line: 1, Column: 2},
+		{Type: TokenText, Value: "y", Line: 2, Column: 8},
+		{Type: TokenEOF, Line: 2, Column: 9},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, exp := range expected {
+		if tokens[i] != exp {
+			t.Errorf("token %d: expected %v, got %v", i, exp, tokens[i])
+		}
+	}
+}
+
+func TestLexer_ErrorUnterminatedComment(t *testing.T) {
+	for _, input := range []string{"{{/* never closed }}", "{{.A /* never closed }}"} {
+		if _, err := New(input).All(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+// textOf concatenates the text tokens of input, as rendered without expressions.
+func textOf(t *testing.T, l *Lexer) string {
+	t.Helper()
+	tokens, err := l.All()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b strings.Builder
+	for _, tok := range tokens {
+		if tok.Type == TokenText {
+			b.WriteString(tok.Value)
+		}
+	}
+	return b.String()
+}
+
+func TestLexer_TrimMarkers(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"left", "a  \n {{- .X}} b", "a b"},
+		{"right", "a {{.X -}} \n\t b", "a b"},
+		{"both", "[\n  {{- .X -}}\n]", "[]"},
+		{"only whitespace between", "{{.A -}}  \n  {{- .B}}", ""},
+		{"comment", "a\n{{- /* note */ -}}\nb", "ab"},
+		{"minus is not a marker", "a {{-1}} b {{.X - 1}} c", "a  b  c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := textOf(t, New(tt.input)); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLexer_TrimMarkerTokens(t *testing.T) {
+	tokens, err := New("a\n{{- .X -}}\nb").All()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Token{
+		{Type: TokenText, Value: "a", Line: 1, Column: 1},
+		{Type: TokenOpenDelim, Value: "{{", Line: 2, Column: 1},
+		{Type: TokenDot, Value: ".", Line: 2, Column: 5},
+		{Type: TokenIdent, Value: "X", Line: 2, Column: 6},
+		{Type: TokenCloseDelim, Value: "}}", Line: 2, Column: 8},
+		{Type: TokenText, Value: "b", Line: 3, Column: 1},
+		{Type: TokenEOF, Line: 3, Column: 2},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, exp := range expected {
+		if tokens[i] != exp {
+			t.Errorf("token %d: expected %v, got %v", i, exp, tokens[i])
+		}
+	}
+}
+
+func TestLexer_BlockTrimming(t *testing.T) {
+	input := "<ul>\n  {{range .Items}}\n  <li>{{.}}</li>\n  {{end}}\n</ul>\n"
+
+	tests := []struct {
+		name     string
+		trim     bool
+		lstrip   bool
+		expected string
+	}{
+		{"off", false, false, "<ul>\n  \n  <li></li>\n  \n</ul>\n"},
+		{"trim blocks", true, false, "<ul>\n    <li></li>\n  </ul>\n"},
+		{"lstrip blocks", false, true, "<ul>\n\n  <li></li>\n\n</ul>\n"},
+		{"both", true, true, "<ul>\n  <li></li>\n</ul>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(input)
+			l.SetTrimBlocks(tt.trim)
+			l.SetLStripBlocks(tt.lstrip)
+			if got := textOf(t, l); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLexer_BlockTrimmingSkipsOutputTags(t *testing.T) {
+	input := "a\n  {{.Name}}\n  {{include \"x\"}}\n  x {{if .A}}\n{{/* c */}}\nb"
+
+	l := New(input)
+	l.SetTrimBlocks(true)
+	l.SetLStripBlocks(true)
+
+	// Only the if tag (not at line start) and the comment line are trimmed
+	expected := "a\n  \n  \n  x b"
+	if got := textOf(t, l); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestLexer_RangeVariables(t *testing.T) {
+	input := "{{range $k, $v := .Map}}{{$v.Name}}"
+	l := New(input)
+
+	expected := []struct {
+		typ   TokenType
+		value string
+	}{
+		{TokenOpenDelim, "{{"},
+		{TokenRange, "range"},
+		{TokenVar, "$k"},
+		{TokenComma, ","},
+		{TokenVar, "$v"},
+		{TokenDeclare, ":="},
+		{TokenDot, "."},
+		{TokenIdent, "Map"},
+		{TokenCloseDelim, "}}"},
+		{TokenOpenDelim, "{{"},
+		{TokenVar, "$v"},
+		{TokenDot, "."},
+		{TokenIdent, "Name"},
+		{TokenCloseDelim, "}}"},
+		{TokenEOF, ""},
+	}
+
+	for i, exp := range expected {
+		tok, err := l.NextToken()
+		if err != nil {
+			t.Fatalf("token %d: unexpected error: %v", i, err)
+		}
+		if tok.Type != exp.typ || tok.Value != exp.value {
+			t.Errorf("token %d: expected %v(%q), got %v(%q)", i, exp.typ, exp.value, tok.Type, tok.Value)
+		}
+	}
+}
+
+func TestLexer_ErrorEmptyVariable(t *testing.T) {
+	l := New("{{$ }}")
+	_, _ = l.NextToken() // {{
+
+	if _, err := l.NextToken(); err == nil {
+		t.Error("expected error for $ without a name")
+	}
+}
+
 func BenchmarkLexer_SimpleText(b *testing.B) {
 	input := "Hello, World! This is some template text."
 	for i := 0; i < b.N; i++ {
@@ -480,6 +784,31 @@ func TestTokenType_String(t *testing.T) {
 	}
 }
 
+func TestTokenType_StableValues(t *testing.T) {
+	// Token types of the first release keep their values; new ones are appended.
+	tests := []struct {
+		typ  TokenType
+		want int
+	}{
+		{TokenText, 2},
+		{TokenNumber, 5},
+		{TokenCloseDelim, 7},
+		{TokenAssign, 24},
+		{TokenComma, 25},
+		{TokenRBrack, 30},
+		{TokenIf, 31},
+		{TokenElse, 32},
+		{TokenEnd, 33},
+		{TokenBlock, 37},
+	}
+
+	for _, tt := range tests {
+		if int(tt.typ) != tt.want {
+			t.Errorf("%v = %d, want %d", tt.typ, tt.typ, tt.want)
+		}
+	}
+}
+
 func TestLexer_ArithmeticOperators(t *testing.T) {
 	input := "{{.A + .B - .C * .D / .E % .F}}"
 	l := New(input)
@@ -663,3 +992,197 @@ func TestLexer_CommaInExpression(t *testing.T) {
 		t.Errorf("expected 2 commas, got %d", commaCount)
 	}
 }
+
+func TestLexer_Braces(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []TokenType
+	}{
+		{
+			`{{{"a": 1}}}`,
+			[]TokenType{TokenOpenDelim, TokenLBrace, TokenString, TokenColon, TokenNumber, TokenRBrace, TokenCloseDelim, TokenEOF},
+		},
+		{
+			`{{{"a": {"b": 1}}}}x`,
+			[]TokenType{
+				TokenOpenDelim, TokenLBrace, TokenString, TokenColon, TokenLBrace, TokenString, TokenColon, TokenNumber,
+				TokenRBrace, TokenRBrace, TokenCloseDelim, TokenText, TokenEOF,
+			},
+		},
+		{
+			`{{f {} -}} x`,
+			[]TokenType{TokenOpenDelim, TokenIdent, TokenLBrace, TokenRBrace, TokenCloseDelim, TokenText, TokenEOF},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := New(tt.input).All()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tokens) != len(tt.want) {
+				t.Fatalf("expected %d tokens, got %d: %v", len(tt.want), len(tokens), tokens)
+			}
+			for i, wantType := range tt.want {
+				if tokens[i].Type != wantType {
+					t.Errorf("token %d: expected %v, got %v", i, wantType, tokens[i].Type)
+				}
+			}
+		})
+	}
+}
+
+func TestLexer_Membership(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []TokenType
+	}{
+		{`{{"a" in .S}}`, []TokenType{TokenOpenDelim, TokenString, TokenIn, TokenDot, TokenIdent, TokenCloseDelim, TokenEOF}},
+		{"{{.A not\n  in .S}}", []TokenType{TokenOpenDelim, TokenDot, TokenIdent, TokenNotIn, TokenDot, TokenIdent, TokenCloseDelim, TokenEOF}},
+		{`{{not .A}}`, []TokenType{TokenOpenDelim, TokenIdent, TokenDot, TokenIdent, TokenCloseDelim, TokenEOF}},
+		{`{{not index}}`, []TokenType{TokenOpenDelim, TokenIdent, TokenIdent, TokenCloseDelim, TokenEOF}},
+		{`{{inner}}`, []TokenType{TokenOpenDelim, TokenIdent, TokenCloseDelim, TokenEOF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := New(tt.input).All()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tokens) != len(tt.want) {
+				t.Fatalf("expected %d tokens, got %d: %v", len(tt.want), len(tokens), tokens)
+			}
+			for i, wantType := range tt.want {
+				if tokens[i].Type != wantType {
+					t.Errorf("token %d: expected %v, got %v", i, wantType, tokens[i].Type)
+				}
+			}
+		})
+	}
+
+	tokens, _ := New("{{.A not in .S}}").All()
+	if tok := tokens[3]; tok.Value != "not in" || tok.Column != 6 {
+		t.Errorf("expected \"not in\" at column 6, got %v", tok)
+	}
+}
+
+func TestLexer_CustomDelimiters(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		left  string
+		right string
+		want  []Token
+	}{
+		{
+			name:  "square percent",
+			input: "{{ vue }} [% .Name %]",
+			left:  "[%",
+			right: "%]",
+			want: []Token{
+				{Type: TokenText, Value: "{{ vue }} "},
+				{Type: TokenOpenDelim, Value: "[%"},
+				{Type: TokenDot, Value: "."},
+				{Type: TokenIdent, Value: "Name"},
+				{Type: TokenCloseDelim, Value: "%]"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "asp style with operator",
+			input: "<%.A % 2%>",
+			left:  "<%",
+			right: "%>",
+			want: []Token{
+				{Type: TokenOpenDelim, Value: "<%"},
+				{Type: TokenDot, Value: "."},
+				{Type: TokenIdent, Value: "A"},
+				{Type: TokenMod, Value: "%"},
+				{Type: TokenNumber, Value: "2"},
+				{Type: TokenCloseDelim, Value: "%>"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "asymmetric lengths",
+			input: "a<<<.X>b",
+			left:  "<<<",
+			right: ">",
+			want: []Token{
+				{Type: TokenText, Value: "a"},
+				{Type: TokenOpenDelim, Value: "<<<"},
+				{Type: TokenDot, Value: "."},
+				{Type: TokenIdent, Value: "X"},
+				{Type: TokenCloseDelim, Value: ">"},
+				{Type: TokenText, Value: "b"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "double brackets with index",
+			input: "[[.Items[0]]]",
+			left:  "[[",
+			right: "]]",
+			want: []Token{
+				{Type: TokenOpenDelim, Value: "[["},
+				{Type: TokenDot, Value: "."},
+				{Type: TokenIdent, Value: "Items"},
+				{Type: TokenLBrack, Value: "["},
+				{Type: TokenNumber, Value: "0"},
+				{Type: TokenRBrack, Value: "]"},
+				{Type: TokenCloseDelim, Value: "]]"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "double brackets with nested list",
+			input: "[[ [[1]][0] ]]",
+			left:  "[[",
+			right: "]]",
+			want: []Token{
+				{Type: TokenOpenDelim, Value: "[["},
+				{Type: TokenLBrack, Value: "["},
+				{Type: TokenLBrack, Value: "["},
+				{Type: TokenNumber, Value: "1"},
+				{Type: TokenRBrack, Value: "]"},
+				{Type: TokenRBrack, Value: "]"},
+				{Type: TokenLBrack, Value: "["},
+				{Type: TokenNumber, Value: "0"},
+				{Type: TokenRBrack, Value: "]"},
+				{Type: TokenCloseDelim, Value: "]]"},
+				{Type: TokenEOF},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := NewWithDelimiters(tt.input, tt.left, tt.right).All()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tokens) != len(tt.want) {
+				t.Fatalf("expected %d tokens, got %d: %v", len(tt.want), len(tokens), tokens)
+			}
+			for i, want := range tt.want {
+				if tokens[i].Type != want.Type || tokens[i].Value != want.Value {
+					t.Errorf("token %d: expected %v %q, got %v %q", i, want.Type, want.Value, tokens[i].Type, tokens[i].Value)
+				}
+			}
+		})
+	}
+}
+
+func TestLexer_CustomDelimitersPosition(t *testing.T) {
+	tokens, err := NewWithDelimiters("ab\n[% .X %]", "[%", "%]").All()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// tokens: TEXT, OPEN, DOT, IDENT, CLOSE, EOF
+	if tokens[2].Line != 2 || tokens[2].Column != 4 {
+		t.Errorf("expected dot at 2:4, got %d:%d", tokens[2].Line, tokens[2].Column)
+	}
+}
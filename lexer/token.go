@@ -5,7 +5,8 @@ import "fmt"
 // TokenType represents the type of a lexical token.
 type TokenType int
 
-// Token types recognized by the lexer.
+// Token types recognized by the lexer. New token types are appended at the
+// end so that the values of existing ones stay stable.
 const (
 	TokenError TokenType = iota // Error token
 	TokenEOF                    // End of file
@@ -46,13 +47,29 @@ const (
 	TokenRBrack    // ]
 
 	// Keywords
-	TokenIf      // if
-	TokenElse    // else
-	TokenEnd     // end
-	TokenRange   // range
-	TokenInclude // include
-	TokenExtends // extends
-	TokenBlock   // block
+	TokenIf       // if
+	TokenElse     // else
+	TokenEnd      // end
+	TokenRange    // range
+	TokenInclude  // include
+	TokenExtends  // extends
+	TokenBlock    // block
+	TokenSet      // set
+	TokenWith     // with
+	TokenIn       // in
+	TokenNotIn    // not in
+	TokenBreak    // break
+	TokenContinue // continue
+
+	TokenVar      // Variable $name
+	TokenDeclare  // :=
+	TokenElif     // elif
+	TokenComment  // Comment {{/* ... */}}, only emitted when comments are kept
+	TokenQuestion // ?
+	TokenCoalesce // ??
+	TokenSafeDot  // ?.
+	TokenLBrace   // {
+	TokenRBrace   // }
 )
 
 // String returns the string representation of the token type.
@@ -61,9 +78,11 @@ func (t TokenType) String() string {
 		TokenError:      "ERROR",
 		TokenEOF:        "EOF",
 		TokenText:       "TEXT",
+		TokenComment:    "COMMENT",
 		TokenIdent:      "IDENT",
 		TokenString:     "STRING",
 		TokenNumber:     "NUMBER",
+		TokenVar:        "VAR",
 		TokenOpenDelim:  "{{",
 		TokenCloseDelim: "}}",
 		TokenDot:        ".",
@@ -83,19 +102,32 @@ func (t TokenType) String() string {
 		TokenDiv:        "/",
 		TokenMod:        "%",
 		TokenAssign:     "=",
+		TokenDeclare:    ":=",
 		TokenComma:      ",",
 		TokenColon:      ":",
 		TokenLParen:     "(",
 		TokenRParen:     ")",
 		TokenLBrack:     "[",
 		TokenRBrack:     "]",
+		TokenLBrace:     "{",
+		TokenRBrace:     "}",
+		TokenQuestion:   "?",
+		TokenCoalesce:   "??",
+		TokenSafeDot:    "?.",
 		TokenIf:         "IF",
 		TokenElse:       "ELSE",
+		TokenElif:       "ELIF",
 		TokenEnd:        "END",
 		TokenRange:      "RANGE",
 		TokenInclude:    "INCLUDE",
 		TokenExtends:    "EXTENDS",
 		TokenBlock:      "BLOCK",
+		TokenSet:        "SET",
+		TokenWith:       "WITH",
+		TokenIn:         "IN",
+		TokenNotIn:      "NOT IN",
+		TokenBreak:      "BREAK",
+		TokenContinue:   "CONTINUE",
 	}
 	if name, ok := names[t]; ok {
 		return name
@@ -121,13 +153,19 @@ func (t Token) String() string {
 
 // keywords maps keyword strings to their token types.
 var keywords = map[string]TokenType{
-	"if":      TokenIf,
-	"else":    TokenElse,
-	"end":     TokenEnd,
-	"range":   TokenRange,
-	"include": TokenInclude,
-	"extends": TokenExtends,
-	"block":   TokenBlock,
+	"if":       TokenIf,
+	"else":     TokenElse,
+	"elif":     TokenElif,
+	"end":      TokenEnd,
+	"range":    TokenRange,
+	"include":  TokenInclude,
+	"extends":  TokenExtends,
+	"block":    TokenBlock,
+	"set":      TokenSet,
+	"with":     TokenWith,
+	"in":       TokenIn,
+	"break":    TokenBreak,
+	"continue": TokenContinue,
 }
 
 // IsKeyword checks if a string is a keyword and returns its TokenType.
@@ -26,9 +26,14 @@ type Loader interface {
 
 // FileSystemLoader loads templates from a directory on the filesystem.
 type FileSystemLoader struct {
-	baseDir    string
-	extensions []string
-	cache      *TemplateCache
+	baseDir      string
+	extensions   []string
+	leftDelim    string
+	rightDelim   string
+	trimBlocks   bool
+	lstripBlocks bool
+	keepComments bool
+	cache        *TemplateCache
 	// mu sync.RWMutex // Reserved for future use with concurrent cache operations
 }
 
@@ -111,9 +116,39 @@ func (l *FileSystemLoader) resolvePath(slug string) (string, error) {
 	return "", fmt.Errorf("template %q not found in %q", slug, l.baseDir)
 }
 
+// SetDelimiters sets the expression delimiters used when parsing templates
+// (e.g., "[[" and "]]"). Empty values mean the default {{ and }}.
+// Previously cached templates are discarded.
+func (l *FileSystemLoader) SetDelimiters(left, right string) {
+	l.leftDelim = left
+	l.rightDelim = right
+	l.cache.Clear()
+}
+
+// SetBlockTrimming controls the whitespace around control tags when parsing
+// templates (see Lexer.SetTrimBlocks and Lexer.SetLStripBlocks).
+// Previously cached templates are discarded.
+func (l *FileSystemLoader) SetBlockTrimming(trimBlocks, lstripBlocks bool) {
+	l.trimBlocks = trimBlocks
+	l.lstripBlocks = lstripBlocks
+	l.cache.Clear()
+}
+
+// SetKeepComments controls whether {{/* ... */}} comments are kept in parsed
+// templates as parser.CommentNode, for tools such as formatters or doc
+// generators (see Lexer.SetKeepComments). Previously cached templates are
+// discarded.
+func (l *FileSystemLoader) SetKeepComments(keep bool) {
+	l.keepComments = keep
+	l.cache.Clear()
+}
+
 // parse parses template content into an AST.
 func (l *FileSystemLoader) parse(content, slug string) (*parser.Template, error) {
-	lex := lexer.New(content)
+	lex := lexer.NewWithDelimiters(content, l.leftDelim, l.rightDelim)
+	lex.SetTrimBlocks(l.trimBlocks)
+	lex.SetLStripBlocks(l.lstripBlocks)
+	lex.SetKeepComments(l.keepComments)
 	p := parser.New(lex)
 	tmpl, err := p.Parse()
 	if err != nil {
@@ -129,10 +164,15 @@ func (l *FileSystemLoader) ClearCache() {
 
 // EmbedLoader loads templates from an embedded filesystem (embed.FS).
 type EmbedLoader struct {
-	fs         fs.FS
-	baseDir    string
-	extensions []string
-	cache      *TemplateCache
+	fs           fs.FS
+	baseDir      string
+	extensions   []string
+	leftDelim    string
+	rightDelim   string
+	trimBlocks   bool
+	lstripBlocks bool
+	keepComments bool
+	cache        *TemplateCache
 }
 
 // NewEmbedLoader creates a new embed.FS-based template loader.
@@ -213,9 +253,39 @@ func (l *EmbedLoader) resolvePath(slug string) (string, error) {
 	return "", fmt.Errorf("template %q not found in embedded filesystem", slug)
 }
 
+// SetDelimiters sets the expression delimiters used when parsing templates
+// (e.g., "[[" and "]]"). Empty values mean the default {{ and }}.
+// Previously cached templates are discarded.
+func (l *EmbedLoader) SetDelimiters(left, right string) {
+	l.leftDelim = left
+	l.rightDelim = right
+	l.cache.Clear()
+}
+
+// SetBlockTrimming controls the whitespace around control tags when parsing
+// templates (see Lexer.SetTrimBlocks and Lexer.SetLStripBlocks).
+// Previously cached templates are discarded.
+func (l *EmbedLoader) SetBlockTrimming(trimBlocks, lstripBlocks bool) {
+	l.trimBlocks = trimBlocks
+	l.lstripBlocks = lstripBlocks
+	l.cache.Clear()
+}
+
+// SetKeepComments controls whether {{/* ... */}} comments are kept in parsed
+// templates as parser.CommentNode, for tools such as formatters or doc
+// generators (see Lexer.SetKeepComments). Previously cached templates are
+// discarded.
+func (l *EmbedLoader) SetKeepComments(keep bool) {
+	l.keepComments = keep
+	l.cache.Clear()
+}
+
 // parse parses template content into an AST.
 func (l *EmbedLoader) parse(content, slug string) (*parser.Template, error) {
-	lex := lexer.New(content)
+	lex := lexer.NewWithDelimiters(content, l.leftDelim, l.rightDelim)
+	lex.SetTrimBlocks(l.trimBlocks)
+	lex.SetLStripBlocks(l.lstripBlocks)
+	lex.SetKeepComments(l.keepComments)
 	p := parser.New(lex)
 	tmpl, err := p.Parse()
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"embed"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/toutaio/toutago-fith-renderer/parser"
@@ -240,3 +241,98 @@ func TestEmbedLoaderClearCache(t *testing.T) {
 	ldr := NewEmbedLoader(nil, "templates", []string{".html"})
 	ldr.ClearCache() // Should not panic
 }
+
+func TestFileSystemLoader_SetDelimiters(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	templatePath := filepath.Join(tmpDir, "vue.html")
+	err := os.WriteFile(templatePath, []byte("<p>{{ message }}</p>[[.Name]]"), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+
+	loader := NewFileSystemLoader(tmpDir, []string{".html"})
+	loader.SetDelimiters("[[", "]]")
+
+	tmpl, err := loader.Load("vue")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	if len(tmpl.Nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(tmpl.Nodes))
+	}
+
+	text, ok := tmpl.Nodes[0].(*parser.TextNode)
+	if !ok || text.Value != "<p>{{ message }}</p>" {
+		t.Errorf("Expected client-side braces to stay text, got %v", tmpl.Nodes[0])
+	}
+
+	if _, ok := tmpl.Nodes[1].(*parser.VariableNode); !ok {
+		t.Errorf("Expected variable node, got %T", tmpl.Nodes[1])
+	}
+}
+
+func TestFileSystemLoader_SetKeepComments(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	templatePath := filepath.Join(tmpDir, "doc.html")
+	err := os.WriteFile(templatePath, []byte("{{/* Renders the title */}}{{.Title}}"), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+
+	loader := NewFileSystemLoader(tmpDir, []string{".html"})
+	tmpl, err := loader.Load("doc")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+	if len(tmpl.Nodes) != 1 {
+		t.Fatalf("Expected the comment to be discarded, got %d nodes", len(tmpl.Nodes))
+	}
+
+	loader.SetKeepComments(true)
+	tmpl, err = loader.Load("doc")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+	if len(tmpl.Nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(tmpl.Nodes))
+	}
+	comment, ok := tmpl.Nodes[0].(*parser.CommentNode)
+	if !ok || strings.TrimSpace(comment.Text) != "Renders the title" {
+		t.Errorf("Expected comment node, got %v", tmpl.Nodes[0])
+	}
+}
+
+func TestFileSystemLoader_SetBlockTrimming(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	templatePath := filepath.Join(tmpDir, "list.txt")
+	err := os.WriteFile(templatePath, []byte("  {{if .A}}\nx\n  {{end}}\n"), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test template: %v", err)
+	}
+
+	loader := NewFileSystemLoader(tmpDir, []string{".txt"})
+	if _, err := loader.Load("list"); err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+	loader.SetBlockTrimming(true, true)
+
+	tmpl, err := loader.Load("list")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	if len(tmpl.Nodes) != 1 {
+		t.Fatalf("Expected only the if node, got %d nodes", len(tmpl.Nodes))
+	}
+	ifNode, ok := tmpl.Nodes[0].(*parser.IfNode)
+	if !ok {
+		t.Fatalf("Expected if node, got %T", tmpl.Nodes[0])
+	}
+	if text, ok := ifNode.Then[0].(*parser.TextNode); !ok || text.Value != "x\n" {
+		t.Errorf("Expected trimmed body %q, got %v", "x\n", ifNode.Then[0])
+	}
+}
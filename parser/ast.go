@@ -37,10 +37,24 @@ type TextNode struct {
 func (n *TextNode) Pos() Position  { return n.Position }
 func (n *TextNode) String() string { return "Text: " + n.Value }
 
+// CommentNode represents a {{/* ... */}} comment. Comments produce no
+// output and only appear in the AST when the lexer keeps them.
+type CommentNode struct {
+	Position Position
+	Text     string // Comment text between /* and */
+}
+
+func (n *CommentNode) Pos() Position  { return n.Position }
+func (n *CommentNode) String() string { return "Comment: " + n.Text }
+
 // VariableNode represents a variable expression like {{.Name}} or {{.User.Email}}.
 type VariableNode struct {
 	Position Position
 	Path     []string // Path components, e.g., [".", "User", "Email"]
+	// Optional marks the path components accessed with ?., as in
+	// .User?.Email. It is nil when the path has none, otherwise it has the
+	// same length as Path.
+	Optional []bool
 }
 
 func (n *VariableNode) Pos() Position  { return n.Position }
@@ -67,15 +81,45 @@ type UnaryOpNode struct {
 func (n *UnaryOpNode) Pos() Position  { return n.Position }
 func (n *UnaryOpNode) String() string { return "UnaryOp" }
 
+// TernaryNode represents a conditional expression like {{.Stock ? "in" : "out"}}.
+type TernaryNode struct {
+	Position  Position
+	Condition Node // The condition expression
+	Then      Node // Value if the condition is truthy
+	Else      Node // Value otherwise
+}
+
+func (n *TernaryNode) Pos() Position  { return n.Position }
+func (n *TernaryNode) String() string { return "Ternary" }
+
 // LiteralNode represents a literal value (string, number, boolean).
 type LiteralNode struct {
 	Position Position
-	Value    interface{} // The actual value (string, int, float64, bool)
+	Value    interface{} // The actual value (string, int, float64, bool or nil)
 }
 
 func (n *LiteralNode) Pos() Position  { return n.Position }
 func (n *LiteralNode) String() string { return "Literal" }
 
+// ListNode represents a list literal like {{["a", "b", .C]}}.
+type ListNode struct {
+	Position Position
+	Elements []Node // Element expressions, in order
+}
+
+func (n *ListNode) Pos() Position  { return n.Position }
+func (n *ListNode) String() string { return "List" }
+
+// MapNode represents a map literal like {{{"title": .T, "size": 3}}}.
+type MapNode struct {
+	Position Position
+	Keys     []string // Keys, in source order
+	Values   []Node   // Value expressions, parallel to Keys
+}
+
+func (n *MapNode) Pos() Position  { return n.Position }
+func (n *MapNode) String() string { return "Map" }
+
 // IndexNode represents array/map access like {{.Items[0]}} or {{.Data["key"]}}.
 type IndexNode struct {
 	Position Position
@@ -86,6 +130,28 @@ type IndexNode struct {
 func (n *IndexNode) Pos() Position  { return n.Position }
 func (n *IndexNode) String() string { return "Index" }
 
+// SliceNode represents slicing like {{.Items[1:3]}}, {{.Items[:5]}} or {{.Name[2:]}}.
+type SliceNode struct {
+	Position Position
+	Object   Node // The object being sliced
+	Low      Node // The start index, or nil for the beginning
+	High     Node // The end index, or nil for the end
+}
+
+func (n *SliceNode) Pos() Position  { return n.Position }
+func (n *SliceNode) String() string { return "Slice" }
+
+// FieldNode represents field access on an indexed value, like {{.Users[0].Name}}.
+type FieldNode struct {
+	Position Position
+	Object   Node     // The object whose fields are accessed
+	Path     []string // Field names, in order
+	Optional []bool   // Whether each field is accessed with ?., or nil if none is
+}
+
+func (n *FieldNode) Pos() Position  { return n.Position }
+func (n *FieldNode) String() string { return "Field" }
+
 // CallNode represents a function call like {{upper .Name}}.
 type CallNode struct {
 	Position Position
@@ -96,11 +162,24 @@ type CallNode struct {
 func (n *CallNode) Pos() Position  { return n.Position }
 func (n *CallNode) String() string { return "Call: " + n.Function }
 
-// PipeNode represents a filter pipeline like {{.Name | upper | trim}}.
+// MethodNode represents a method call with arguments like {{.User.HasRole "admin"}}.
+// The last component of Method's path names the method; the rest is the receiver.
+type MethodNode struct {
+	Position Position
+	Method   *VariableNode // Path to the method, e.g. .User.HasRole
+	Args     []Node        // Arguments
+}
+
+func (n *MethodNode) Pos() Position  { return n.Position }
+func (n *MethodNode) String() string { return "Method" }
+
+// PipeNode represents a filter pipeline like {{.Body | truncate 100 | upper}}.
+// Each filter is a call whose arguments follow its name; the piped value is
+// added to them at run time.
 type PipeNode struct {
 	Position Position
-	Value    Node     // Initial value
-	Filters  []string // List of filter function names
+	Value    Node        // Initial value
+	Filters  []*CallNode // Filter calls, applied in order
 }
 
 func (n *PipeNode) Pos() Position  { return n.Position }
@@ -109,26 +188,75 @@ func (n *PipeNode) String() string { return "Pipe" }
 // IfNode represents an if/else statement.
 type IfNode struct {
 	Position  Position
-	Condition Node   // The condition expression
-	Then      []Node // Nodes to execute if condition is true
-	Else      []Node // Nodes to execute if condition is false (may be nil)
+	Condition Node            // The condition expression
+	Then      []Node          // Nodes to execute if condition is true
+	ElseIfs   []*ElseIfClause // else if/elif branches, tried in order (may be nil)
+	Else      []Node          // Nodes to execute if no condition is true (may be nil)
 }
 
 func (n *IfNode) Pos() Position  { return n.Position }
 func (n *IfNode) String() string { return "If" }
 
+// ElseIfClause is an {{else if cond}} or {{elif cond}} branch of an IfNode.
+type ElseIfClause struct {
+	Position  Position
+	Condition Node   // The condition expression
+	Body      []Node // Nodes to execute if condition is true
+}
+
 // RangeNode represents a range loop.
 type RangeNode struct {
 	Position   Position
-	Variable   string // Loop variable name (e.g., "item")
-	KeyVar     string // Key variable for map iteration (optional)
+	Variable   string // Loop variable name without $ (e.g., "item"), optional
+	KeyVar     string // Index or key variable name without $, optional
 	Collection Node   // The collection to iterate over
 	Body       []Node // Nodes to execute for each iteration
+	Else       []Node // Nodes to execute if the collection is empty or nil (may be nil)
 }
 
 func (n *RangeNode) Pos() Position  { return n.Position }
 func (n *RangeNode) String() string { return "Range" }
 
+// BreakNode represents {{break}}, which ends the innermost range loop.
+type BreakNode struct {
+	Position Position
+}
+
+func (n *BreakNode) Pos() Position  { return n.Position }
+func (n *BreakNode) String() string { return "Break" }
+
+// ContinueNode represents {{continue}}, which skips to the next iteration
+// of the innermost range loop.
+type ContinueNode struct {
+	Position Position
+}
+
+func (n *ContinueNode) Pos() Position  { return n.Position }
+func (n *ContinueNode) String() string { return "Continue" }
+
+// SetNode represents a variable assignment like {{set $total = .Price * .Qty}}.
+// The variable is bound in the current scope.
+type SetNode struct {
+	Position Position
+	Variable string // Variable name without $ (e.g., "total")
+	Value    Node   // The value expression
+}
+
+func (n *SetNode) Pos() Position  { return n.Position }
+func (n *SetNode) String() string { return "Set: $" + n.Variable }
+
+// WithNode represents a with statement, which rebinds . to a value in a new
+// scope when the value is truthy.
+type WithNode struct {
+	Position Position
+	Value    Node   // The value bound to .
+	Body     []Node // Nodes to execute if the value is truthy
+	Else     []Node // Nodes to execute otherwise (may be nil)
+}
+
+func (n *WithNode) Pos() Position  { return n.Position }
+func (n *WithNode) String() string { return "With" }
+
 // IncludeNode represents an include directive.
 type IncludeNode struct {
 	Position Position
@@ -40,7 +40,7 @@ func TestASTNodeMethods(t *testing.T) {
 			"PipeNode",
 			&PipeNode{
 				Value:    &VariableNode{Path: []string{"test"}},
-				Filters:  []string{"upper"},
+				Filters:  []*CallNode{{Function: "upper"}},
 				Position: Position{Line: 1, Column: 1},
 			},
 		},
@@ -3,15 +3,18 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/toutaio/toutago-fith-renderer/lexer"
 )
 
 // Parser builds an Abstract Syntax Tree from tokens.
 type Parser struct {
-	lexer   *lexer.Lexer
-	current lexer.Token
-	peek    lexer.Token
+	lexer     *lexer.Lexer
+	current   lexer.Token
+	peek      lexer.Token
+	inLiteral bool // Parsing an element of a list or map literal, where , ends call arguments
+	loops     int  // Number of range bodies being parsed, where break and continue are allowed
 }
 
 // New creates a new Parser for the given lexer.
@@ -47,6 +50,13 @@ func (p *Parser) parseNode() (Node, error) {
 	switch p.current.Type {
 	case lexer.TokenText:
 		return p.parseText()
+	case lexer.TokenComment:
+		node := &CommentNode{
+			Position: Position{Line: p.current.Line, Column: p.current.Column},
+			Text:     p.current.Value,
+		}
+		p.nextToken()
+		return node, nil
 	case lexer.TokenOpenDelim:
 		return p.parseExpression()
 	default:
@@ -77,6 +87,12 @@ func (p *Parser) parseExpression() (Node, error) {
 		return p.parseIf()
 	case lexer.TokenRange:
 		return p.parseRange()
+	case lexer.TokenWith:
+		return p.parseWith()
+	case lexer.TokenSet:
+		return p.parseSet()
+	case lexer.TokenBreak, lexer.TokenContinue:
+		return p.parseLoopControl()
 	case lexer.TokenInclude:
 		return p.parseInclude()
 	case lexer.TokenExtends:
@@ -86,9 +102,9 @@ func (p *Parser) parseExpression() (Node, error) {
 	case lexer.TokenEnd:
 		// End token without matching start - this is an error
 		return nil, p.error("unexpected 'end' token")
-	case lexer.TokenElse:
+	case lexer.TokenElse, lexer.TokenElif:
 		// Else token outside if - this is an error
-		return nil, p.error("unexpected 'else' token")
+		return nil, p.error(fmt.Sprintf("unexpected '%s' token", p.current.Value))
 	}
 
 	// Otherwise, parse as a value expression
@@ -106,10 +122,11 @@ func (p *Parser) parseExpression() (Node, error) {
 	return node, nil
 }
 
-// parseValue parses a value expression (variable, literal, function call, etc.).
+// parseValue parses a value expression (variable, literal, function call,
+// operation, etc.), optionally followed by a pipeline. A pipe binds loosest,
+// so in {{.A + .B | printf}} the sum is piped.
 func (p *Parser) parseValue() (Node, error) {
-	// Start with primary expression
-	node, err := p.parsePrimary()
+	node, err := p.parseTernary()
 	if err != nil {
 		return nil, err
 	}
@@ -119,14 +136,133 @@ func (p *Parser) parseValue() (Node, error) {
 		return p.parsePipe(node)
 	}
 
-	// Check for binary operators
-	if p.isBinaryOp(p.current.Type) {
-		return p.parseBinaryOp(node)
+	return node, nil
+}
+
+// parseTernary parses a conditional expression like .A ? .B : .C, which
+// binds looser than any binary operator and nests to the right, so
+// a ? b : c ? d : e is a ? b : (c ? d : e).
+func (p *Parser) parseTernary() (Node, error) {
+	condition, err := p.parseBinary(precedenceLowest)
+	if err != nil {
+		return nil, err
+	}
+	if p.current.Type != lexer.TokenQuestion {
+		return condition, nil
+	}
+
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	p.nextToken() // consume ?
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current.Type != lexer.TokenColon {
+		return nil, p.error("expected : in conditional expression")
+	}
+	p.nextToken() // consume :
+
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TernaryNode{Position: pos, Condition: condition, Then: then, Else: otherwise}, nil
+}
+
+// Binding power of binary operators, from loosest to tightest.
+const (
+	precedenceLowest = iota + 1
+	precedenceCoalesce
+	precedenceOr
+	precedenceAnd
+	precedenceEquality
+	precedenceComparison
+	precedenceAdditive
+	precedenceMultiplicative
+)
+
+// precedence returns the binding power of a binary operator, or 0 if t is
+// not a binary operator.
+func precedence(t lexer.TokenType) int {
+	switch t {
+	case lexer.TokenCoalesce:
+		return precedenceCoalesce
+	case lexer.TokenOr:
+		return precedenceOr
+	case lexer.TokenAnd:
+		return precedenceAnd
+	case lexer.TokenEqual, lexer.TokenNotEqual:
+		return precedenceEquality
+	case lexer.TokenLess, lexer.TokenGreater, lexer.TokenLessEq, lexer.TokenGreaterEq,
+		lexer.TokenIn, lexer.TokenNotIn:
+		return precedenceComparison
+	case lexer.TokenPlus, lexer.TokenMinus:
+		return precedenceAdditive
+	case lexer.TokenMult, lexer.TokenDiv, lexer.TokenMod:
+		return precedenceMultiplicative
+	default:
+		return 0
+	}
+}
+
+// parseBinary parses a chain of binary operations by precedence climbing.
+// Only operators binding at least as tightly as minPrecedence are consumed;
+// operators of equal precedence associate to the left.
+func (p *Parser) parseBinary(minPrecedence int) (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		prec := precedence(p.current.Type)
+		if prec == 0 || prec < minPrecedence {
+			return left, nil
+		}
+
+		pos := Position{Line: p.current.Line, Column: p.current.Column}
+		op := p.current.Type
+		p.nextToken()
+
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryOpNode{Position: pos, Operator: op, Left: left, Right: right}
+	}
+}
+
+// parseUnary parses an operand, with any prefix ! or - operators. A field
+// path followed by arguments is a method call, like .User.HasRole "admin".
+func (p *Parser) parseUnary() (Node, error) {
+	if p.current.Type == lexer.TokenNot || p.current.Type == lexer.TokenMinus {
+		return p.parseUnaryOp()
 	}
 
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := node.(*VariableNode); ok && len(v.Path) > 1 && !p.atArgsEnd() {
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		return &MethodNode{Position: v.Position, Method: v, Args: args}, nil
+	}
 	return node, nil
 }
 
+// literals maps the names of literal constants to their values.
+var literals = map[string]interface{}{
+	"true":  true,
+	"false": false,
+	"nil":   nil,
+}
+
 // parsePrimary parses a primary expression (variable, literal, function call, etc.).
 func (p *Parser) parsePrimary() (Node, error) {
 	pos := Position{Line: p.current.Line, Column: p.current.Column}
@@ -134,7 +270,17 @@ func (p *Parser) parsePrimary() (Node, error) {
 	switch p.current.Type {
 	case lexer.TokenDot:
 		return p.parseVariable()
+	case lexer.TokenVar:
+		return p.parseVarRef()
 	case lexer.TokenIdent:
+		if value, ok := literals[p.current.Value]; ok {
+			p.nextToken()
+			return &LiteralNode{Position: pos, Value: value}, nil
+		}
+		// Loop metadata with fields or indexes, like @parent.index
+		if p.current.Value[0] == '@' && p.accessFollows() {
+			return p.parseVarRef()
+		}
 		// Could be a function call
 		return p.parseFunctionCall()
 	case lexer.TokenString:
@@ -145,60 +291,183 @@ func (p *Parser) parsePrimary() (Node, error) {
 		return p.parseNumber(pos)
 	case lexer.TokenNot:
 		return p.parseUnaryOp()
+	case lexer.TokenLBrack:
+		return p.parseList()
+	case lexer.TokenLBrace:
+		return p.parseMap()
 	case lexer.TokenLParen:
-		return p.parseGrouped()
+		node, err := p.parseGrouped()
+		if err != nil {
+			return nil, err
+		}
+		// Index the result of a group, like (.Items | last)[0]
+		if p.current.Type == lexer.TokenLBrack {
+			return p.parseIndex(node)
+		}
+		return node, nil
 	default:
 		return nil, p.error(fmt.Sprintf("unexpected token in expression: %v", p.current.Type))
 	}
 }
 
-// parseVariable parses a variable expression like .Name or .User.Email.
+// parseVariable parses a variable expression like .Name, .User.Email or .User?.Email.
 func (p *Parser) parseVariable() (Node, error) {
 	pos := Position{Line: p.current.Line, Column: p.current.Column}
-	path := []string{"."}
+	node := &VariableNode{Position: pos, Path: []string{"."}}
 
 	p.nextToken() // consume initial dot
 
-	// Parse field access chain
+	return p.parseFieldChain(node, false)
+}
+
+// parseVarRef parses a named variable like $item, $item.Name or $item[0].
+// Field access must follow the variable directly, so {{f $a .B}} passes two arguments.
+func (p *Parser) parseVarRef() (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	name := p.current
+	node := &VariableNode{Position: pos, Path: []string{name.Value}}
+
+	p.nextToken() // consume variable
+
+	switch {
+	case p.current.Type == lexer.TokenLBrack && p.follows(name):
+		return p.parseIndex(node)
+	case (p.current.Type == lexer.TokenDot || p.current.Type == lexer.TokenSafeDot) && p.follows(name):
+		optional := p.current.Type == lexer.TokenSafeDot
+		p.nextToken() // consume dot
+		return p.parseFieldChain(node, optional)
+	}
+
+	return node, nil
+}
+
+// parseFieldChain parses the field access chain after a variable, like Name.Email
+// or Profile?.Avatar, adding the fields to node. optional reports whether
+// the first field is accessed with ?.. Each dot must follow the previous
+// field directly, so {{f .A .B}} passes two arguments.
+func (p *Parser) parseFieldChain(node *VariableNode, optional bool) (Node, error) {
 	for p.current.Type == lexer.TokenIdent {
-		path = append(path, p.current.Value)
+		field := p.current
+		appendField(node, field.Value, optional)
 		p.nextToken()
 
-		// Check for array/map access
-		if p.current.Type == lexer.TokenLBrack {
-			// Convert to IndexNode
-			varNode := &VariableNode{Position: pos, Path: path}
-			return p.parseIndex(varNode)
+		// Check for array/map access, which must also follow directly so
+		// {{f .A [1, 2]}} passes a list
+		if p.current.Type == lexer.TokenLBrack && p.follows(field) {
+			return p.parseIndex(node)
 		}
 
 		// Check for continued dot notation
+		if !p.follows(field) {
+			break
+		}
 		if p.current.Type == lexer.TokenDot {
-			p.nextToken()
+			optional = false
+		} else if p.current.Type == lexer.TokenSafeDot {
+			optional = true
 		} else {
 			break
 		}
+		p.nextToken()
 	}
 
-	return &VariableNode{Position: pos, Path: path}, nil
+	return node, nil
+}
+
+// appendField adds a path component to node, recording whether it is
+// accessed with ?.. Optional is only allocated once a component needs it.
+func appendField(node *VariableNode, field string, optional bool) {
+	if optional && node.Optional == nil {
+		node.Optional = make([]bool, len(node.Path))
+	}
+	node.Path = append(node.Path, field)
+	if node.Optional != nil {
+		node.Optional = append(node.Optional, optional)
+	}
 }
 
-// parseIndex parses array/map access like [0] or ["key"].
+// parseIndex parses array/map access like [0] or ["key"], or slicing like
+// [1:3], [:5] or [2:], followed by any further indexes or fields that
+// directly follow the ], like [0][1] or [0].Name.
 func (p *Parser) parseIndex(object Node) (Node, error) {
 	pos := Position{Line: p.current.Line, Column: p.current.Column}
 
 	p.nextToken() // consume [
 
-	index, err := p.parseValue()
-	if err != nil {
-		return nil, err
+	var index, high Node
+	var err error
+	if p.current.Type != lexer.TokenColon {
+		if index, err = p.parseValue(); err != nil {
+			return nil, err
+		}
+	}
+
+	slice := p.current.Type == lexer.TokenColon
+	if slice {
+		p.nextToken() // consume :
+		if p.current.Type != lexer.TokenRBrack {
+			if high, err = p.parseValue(); err != nil {
+				return nil, err
+			}
+		}
+	} else if index == nil {
+		return nil, p.error("expected index")
 	}
 
 	if p.current.Type != lexer.TokenRBrack {
 		return nil, p.error("expected ]")
 	}
+	end := p.current
 	p.nextToken() // consume ]
 
-	return &IndexNode{Position: pos, Object: object, Index: index}, nil
+	var node Node = &IndexNode{Position: pos, Object: object, Index: index}
+	if slice {
+		node = &SliceNode{Position: pos, Object: object, Low: index, High: high}
+	}
+
+	if !p.follows(end) {
+		return node, nil
+	}
+	switch p.current.Type {
+	case lexer.TokenLBrack:
+		return p.parseIndex(node)
+	case lexer.TokenDot, lexer.TokenSafeDot:
+		return p.parseFieldAccess(node)
+	}
+	return node, nil
+}
+
+// parseFieldAccess parses fields accessed on an indexed value, like the
+// .Name of .Users[0].Name, and any index that directly follows them.
+func (p *Parser) parseFieldAccess(object Node) (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	node := &FieldNode{Position: pos, Object: object}
+
+	for p.current.Type == lexer.TokenDot || p.current.Type == lexer.TokenSafeDot {
+		optional := p.current.Type == lexer.TokenSafeDot
+		p.nextToken() // consume dot
+		if p.current.Type != lexer.TokenIdent {
+			return nil, p.error("expected field name")
+		}
+		field := p.current
+		if optional && node.Optional == nil {
+			node.Optional = make([]bool, len(node.Path))
+		}
+		node.Path = append(node.Path, field.Value)
+		if node.Optional != nil {
+			node.Optional = append(node.Optional, optional)
+		}
+		p.nextToken()
+
+		if !p.follows(field) {
+			break
+		}
+		if p.current.Type == lexer.TokenLBrack {
+			return p.parseIndex(node)
+		}
+	}
+
+	return node, nil
 }
 
 // parseFunctionCall parses a function call like upper .Name or truncate .Text 100.
@@ -207,29 +476,41 @@ func (p *Parser) parseFunctionCall() (Node, error) {
 	funcName := p.current.Value
 	p.nextToken()
 
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CallNode{Position: pos, Function: funcName, Args: args}, nil
+}
+
+// parseArgs parses the arguments of a function or method call.
+func (p *Parser) parseArgs() ([]Node, error) {
 	args := []Node{}
 
-	// Parse arguments until we hit }} or |
-	for p.current.Type != lexer.TokenCloseDelim && p.current.Type != lexer.TokenPipe {
+	// Parse arguments until we hit }}, |, an operator or the end of an enclosing group
+	for !p.atArgsEnd() {
 		arg, err := p.parsePrimary()
 		if err != nil {
 			return nil, err
 		}
 		args = append(args, arg)
 
-		// Skip optional commas between arguments
-		if p.current.Type == lexer.TokenComma {
+		// Skip optional commas between arguments, except in a list or map
+		// literal where they separate elements
+		if p.current.Type == lexer.TokenComma && !p.inLiteral {
 			p.nextToken()
 		}
 	}
 
-	return &CallNode{Position: pos, Function: funcName, Args: args}, nil
+	return args, nil
 }
 
-// parsePipe parses a pipe expression like .Name | upper | trim.
+// parsePipe parses a pipe expression like .Name | upper | truncate 100.
+// Each filter is parsed as a function call with its own arguments.
 func (p *Parser) parsePipe(value Node) (Node, error) {
 	pos := Position{Line: p.current.Line, Column: p.current.Column}
-	filters := []string{}
+	filters := []*CallNode{}
 
 	for p.current.Type == lexer.TokenPipe {
 		p.nextToken() // consume |
@@ -238,34 +519,23 @@ func (p *Parser) parsePipe(value Node) (Node, error) {
 			return nil, p.error("expected filter name after |")
 		}
 
-		filters = append(filters, p.current.Value)
-		p.nextToken()
+		filter, err := p.parseFunctionCall()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter.(*CallNode))
 	}
 
 	return &PipeNode{Position: pos, Value: value, Filters: filters}, nil
 }
 
-// parseBinaryOp parses a binary operation.
-func (p *Parser) parseBinaryOp(left Node) (Node, error) {
-	pos := Position{Line: p.current.Line, Column: p.current.Column}
-	op := p.current.Type
-	p.nextToken()
-
-	right, err := p.parsePrimary()
-	if err != nil {
-		return nil, err
-	}
-
-	return &BinaryOpNode{Position: pos, Operator: op, Left: left, Right: right}, nil
-}
-
-// parseUnaryOp parses a unary operation like !.Active.
+// parseUnaryOp parses a unary operation like !.Active or -.Total.
 func (p *Parser) parseUnaryOp() (Node, error) {
 	pos := Position{Line: p.current.Line, Column: p.current.Column}
 	op := p.current.Type
 	p.nextToken()
 
-	operand, err := p.parsePrimary()
+	operand, err := p.parseUnary()
 	if err != nil {
 		return nil, err
 	}
@@ -277,6 +547,10 @@ func (p *Parser) parseUnaryOp() (Node, error) {
 func (p *Parser) parseGrouped() (Node, error) {
 	p.nextToken() // consume (
 
+	inLiteral := p.inLiteral
+	p.inLiteral = false
+	defer func() { p.inLiteral = inLiteral }()
+
 	node, err := p.parseValue()
 	if err != nil {
 		return nil, err
@@ -290,6 +564,88 @@ func (p *Parser) parseGrouped() (Node, error) {
 	return node, nil
 }
 
+// parseList parses a list literal like ["a", "b", .C]. A trailing comma is allowed.
+func (p *Parser) parseList() (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	p.nextToken() // consume [
+
+	elements := []Node{}
+	for p.current.Type != lexer.TokenRBrack {
+		element, err := p.parseElement()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+
+		if p.current.Type != lexer.TokenComma {
+			break
+		}
+		p.nextToken() // consume ,
+	}
+
+	if p.current.Type != lexer.TokenRBrack {
+		return nil, p.error("expected , or ] in list")
+	}
+	p.nextToken() // consume ]
+
+	return &ListNode{Position: pos, Elements: elements}, nil
+}
+
+// parseMap parses a map literal like {"title": .T, "size": 3}. Keys are
+// string literals; a trailing comma is allowed.
+func (p *Parser) parseMap() (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	p.nextToken() // consume {
+
+	node := &MapNode{Position: pos, Keys: []string{}, Values: []Node{}}
+	seen := make(map[string]bool)
+	for p.current.Type != lexer.TokenRBrace {
+		if p.current.Type != lexer.TokenString {
+			return nil, p.error("expected string key in map")
+		}
+		key := p.current.Value
+		if seen[key] {
+			return nil, p.error(fmt.Sprintf("duplicate key %q in map", key))
+		}
+		seen[key] = true
+		p.nextToken()
+
+		if p.current.Type != lexer.TokenColon {
+			return nil, p.error("expected : after map key")
+		}
+		p.nextToken() // consume :
+
+		value, err := p.parseElement()
+		if err != nil {
+			return nil, err
+		}
+		node.Keys = append(node.Keys, key)
+		node.Values = append(node.Values, value)
+
+		if p.current.Type != lexer.TokenComma {
+			break
+		}
+		p.nextToken() // consume ,
+	}
+
+	if p.current.Type != lexer.TokenRBrace {
+		return nil, p.error("expected , or } in map")
+	}
+	p.nextToken() // consume }
+
+	return node, nil
+}
+
+// parseElement parses an element of a list or map literal. A comma ends
+// the arguments of a call inside it, so [upper .A, .B] has two elements.
+func (p *Parser) parseElement() (Node, error) {
+	inLiteral := p.inLiteral
+	p.inLiteral = true
+	defer func() { p.inLiteral = inLiteral }()
+
+	return p.parseValue()
+}
+
 // parseNumber parses a number literal.
 func (p *Parser) parseNumber(pos Position) (Node, error) {
 	value := p.current.Value
@@ -327,50 +683,101 @@ func (p *Parser) parseIf() (Node, error) {
 	p.nextToken() // consume }}
 
 	// Parse then body
-	thenBody, err := p.parseUntil(lexer.TokenElse, lexer.TokenEnd)
+	thenBody, err := p.parseUntil(lexer.TokenElse, lexer.TokenElif, lexer.TokenEnd)
 	if err != nil {
 		return nil, err
 	}
 
-	var elseBody []Node
+	node := &IfNode{Position: pos, Condition: condition, Then: thenBody}
 
-	// Check for else clause
-	if p.current.Type == lexer.TokenOpenDelim && p.peek.Type == lexer.TokenElse {
+	// Parse else if/elif branches and the final else
+	for p.current.Type == lexer.TokenOpenDelim &&
+		(p.peek.Type == lexer.TokenElse || p.peek.Type == lexer.TokenElif) {
 		p.nextToken() // consume {{
-		p.nextToken() // consume 'else'
+		clausePos := Position{Line: p.current.Line, Column: p.current.Column}
+		isElseIf := p.current.Type == lexer.TokenElif
+		p.nextToken() // consume 'else' or 'elif'
 
-		if p.current.Type != lexer.TokenCloseDelim {
-			return nil, p.error("expected }} after else")
+		if !isElseIf && p.current.Type == lexer.TokenIf {
+			isElseIf = true
+			p.nextToken() // consume 'if'
 		}
-		p.nextToken() // consume }}
 
-		// Parse else body
-		elseBody, err = p.parseUntil(lexer.TokenEnd)
+		if !isElseIf {
+			if p.current.Type != lexer.TokenCloseDelim {
+				return nil, p.error("expected }} after else")
+			}
+			p.nextToken() // consume }}
+
+			// Parse else body
+			node.Else, err = p.parseUntil(lexer.TokenEnd)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		clause, err := p.parseElseIf(clausePos)
 		if err != nil {
 			return nil, err
 		}
+		node.ElseIfs = append(node.ElseIfs, clause)
+	}
+
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// parseElseIf parses the condition and body of an else if/elif branch.
+func (p *Parser) parseElseIf(pos Position) (*ElseIfClause, error) {
+	condition, err := p.parseValue()
+	if err != nil {
+		return nil, err
 	}
 
-	// Expect {{end}}
+	if p.current.Type != lexer.TokenCloseDelim {
+		return nil, p.error("expected }} after else if condition")
+	}
+	p.nextToken() // consume }}
+
+	body, err := p.parseUntil(lexer.TokenElse, lexer.TokenElif, lexer.TokenEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ElseIfClause{Position: pos, Condition: condition, Body: body}, nil
+}
+
+// parseEnd parses the {{end}} closing a control structure.
+func (p *Parser) parseEnd() error {
 	if p.current.Type != lexer.TokenOpenDelim || p.peek.Type != lexer.TokenEnd {
-		return nil, p.error("expected {{end}}")
+		return p.error("expected {{end}}")
 	}
 	p.nextToken() // consume {{
 	p.nextToken() // consume 'end'
 
 	if p.current.Type != lexer.TokenCloseDelim {
-		return nil, p.error("expected }} after end")
+		return p.error("expected }} after end")
 	}
 	p.nextToken() // consume }}
 
-	return &IfNode{Position: pos, Condition: condition, Then: thenBody, Else: elseBody}, nil
+	return nil
 }
 
-// parseRange parses a range loop (simplified for now).
+// parseRange parses a range loop with optional loop variables and else branch.
 func (p *Parser) parseRange() (Node, error) {
 	pos := Position{Line: p.current.Line, Column: p.current.Column}
 	p.nextToken() // consume 'range'
 
+	// Parse optional loop variables: $v := or $k, $v :=
+	keyVar, variable, err := p.parseRangeVars()
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse collection
 	collection, err := p.parseValue()
 	if err != nil {
@@ -384,24 +791,161 @@ func (p *Parser) parseRange() (Node, error) {
 	p.nextToken() // consume }}
 
 	// Parse body
-	body, err := p.parseUntil(lexer.TokenEnd)
+	p.loops++
+	body, err := p.parseUntil(lexer.TokenElse, lexer.TokenEnd)
+	p.loops--
 	if err != nil {
 		return nil, err
 	}
 
-	// Expect {{end}}
-	if p.current.Type != lexer.TokenOpenDelim || p.peek.Type != lexer.TokenEnd {
-		return nil, p.error("expected {{end}}")
+	// Parse optional else body, rendered for empty collections
+	elseBody, err := p.parseElse("range")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+
+	return &RangeNode{
+		Position:   pos,
+		Variable:   variable,
+		KeyVar:     keyVar,
+		Collection: collection,
+		Body:       body,
+		Else:       elseBody,
+	}, nil
+}
+
+// parseElse parses the optional {{else}} branch of a range or with statement,
+// returning nil if there is none.
+func (p *Parser) parseElse(statement string) ([]Node, error) {
+	if p.current.Type != lexer.TokenOpenDelim || p.peek.Type != lexer.TokenElse {
+		return nil, nil
 	}
 	p.nextToken() // consume {{
-	p.nextToken() // consume 'end'
+	p.nextToken() // consume 'else'
 
 	if p.current.Type != lexer.TokenCloseDelim {
-		return nil, p.error("expected }} after end")
+		return nil, p.error(fmt.Sprintf("expected }} after else in %s", statement))
 	}
 	p.nextToken() // consume }}
 
-	return &RangeNode{Position: pos, Collection: collection, Body: body}, nil
+	return p.parseUntil(lexer.TokenEnd)
+}
+
+// parseRangeVars parses the optional variable declaration of a range loop.
+// With one variable it holds the element; with two, the first holds the
+// index or key and the second the element. Names are returned without $.
+func (p *Parser) parseRangeVars() (keyVar, variable string, err error) {
+	if p.current.Type != lexer.TokenVar {
+		return "", "", nil
+	}
+	if p.peek.Type != lexer.TokenDeclare && p.peek.Type != lexer.TokenComma {
+		return "", "", nil // A variable used as the collection
+	}
+
+	variable = p.current.Value[1:]
+	p.nextToken() // consume variable
+
+	if p.current.Type == lexer.TokenComma {
+		p.nextToken() // consume ,
+		if p.current.Type != lexer.TokenVar {
+			return "", "", p.error("expected variable after , in range")
+		}
+		keyVar, variable = variable, p.current.Value[1:]
+		p.nextToken() // consume variable
+	}
+
+	if p.current.Type != lexer.TokenDeclare {
+		return "", "", p.error("expected := after range variables")
+	}
+	p.nextToken() // consume :=
+
+	return keyVar, variable, nil
+}
+
+// parseWith parses a with statement like {{with .User}}...{{else}}...{{end}}.
+func (p *Parser) parseWith() (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	p.nextToken() // consume 'with'
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current.Type != lexer.TokenCloseDelim {
+		return nil, p.error("expected }} after with")
+	}
+	p.nextToken() // consume }}
+
+	body, err := p.parseUntil(lexer.TokenElse, lexer.TokenEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	elseBody, err := p.parseElse("with")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+
+	return &WithNode{Position: pos, Value: value, Body: body, Else: elseBody}, nil
+}
+
+// parseSet parses a variable assignment like {{set $total = .Price * .Qty}}.
+func (p *Parser) parseSet() (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	p.nextToken() // consume 'set'
+
+	if p.current.Type != lexer.TokenVar {
+		return nil, p.error("expected variable after set")
+	}
+	variable := p.current.Value[1:]
+	p.nextToken() // consume variable
+
+	if p.current.Type != lexer.TokenAssign {
+		return nil, p.error("expected = after set variable")
+	}
+	p.nextToken() // consume =
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current.Type != lexer.TokenCloseDelim {
+		return nil, p.error("expected }} after set")
+	}
+	p.nextToken() // consume }}
+
+	return &SetNode{Position: pos, Variable: variable, Value: value}, nil
+}
+
+// parseLoopControl parses {{break}} or {{continue}}, which must be inside
+// the body of a range loop.
+func (p *Parser) parseLoopControl() (Node, error) {
+	pos := Position{Line: p.current.Line, Column: p.current.Column}
+	keyword := p.current
+	if p.loops == 0 {
+		return nil, p.error(fmt.Sprintf("%s outside range", keyword.Value))
+	}
+	p.nextToken() // consume keyword
+
+	if p.current.Type != lexer.TokenCloseDelim {
+		return nil, p.error(fmt.Sprintf("expected }} after %s", keyword.Value))
+	}
+	p.nextToken() // consume }}
+
+	if keyword.Type == lexer.TokenBreak {
+		return &BreakNode{Position: pos}, nil
+	}
+	return &ContinueNode{Position: pos}, nil
 }
 
 // parseInclude parses an include directive with optional parameters.
@@ -433,8 +977,8 @@ func (p *Parser) parseInclude() (Node, error) {
 			p.nextToken() // consume param name
 			p.nextToken() // consume '='
 
-			// Parse the value expression (use parsePrimary for simple values)
-			valueExpr, err := p.parsePrimary()
+			// Parse the value expression, which may be a call or pipeline
+			valueExpr, err := p.parseValue()
 			if err != nil {
 				return nil, err
 			}
@@ -509,17 +1053,9 @@ func (p *Parser) parseBlock() (Node, error) {
 		return nil, err
 	}
 
-	// Expect {{end}}
-	if p.current.Type != lexer.TokenOpenDelim || p.peek.Type != lexer.TokenEnd {
-		return nil, p.error("expected {{end}}")
-	}
-	p.nextToken() // consume {{
-	p.nextToken() // consume 'end'
-
-	if p.current.Type != lexer.TokenCloseDelim {
-		return nil, p.error("expected }} after end")
+	if err := p.parseEnd(); err != nil {
+		return nil, err
 	}
-	p.nextToken() // consume }}
 
 	return &BlockNode{Position: pos, Name: blockName, Body: body}, nil
 }
@@ -563,13 +1099,49 @@ func (p *Parser) nextToken() {
 	}
 }
 
+// follows reports whether the current token directly follows tok, without whitespace.
+func (p *Parser) follows(tok lexer.Token) bool {
+	return adjacent(tok, p.current)
+}
+
+// adjacent reports whether next starts right where tok ends. Columns count
+// runes, so the length of tok is too.
+func adjacent(tok, next lexer.Token) bool {
+	return next.Line == tok.Line && next.Column == tok.Column+utf8.RuneCountInString(tok.Value)
+}
+
+// accessFollows reports whether the next token is a ., ?. or [ directly
+// after the current one, accessing a field or index of it.
+func (p *Parser) accessFollows() bool {
+	switch p.peek.Type {
+	case lexer.TokenDot, lexer.TokenSafeDot, lexer.TokenLBrack:
+		return adjacent(p.current, p.peek)
+	}
+	return false
+}
+
+// atArgsEnd reports whether the current token ends the argument list of a
+// function call: }}, |, an operator, a closing ), ] or }, a comma in a
+// list or map literal, or the next key=value parameter of an include. Use
+// parentheses to pass an operation as an argument, like
+// {{upper (.First + .Last)}}.
+func (p *Parser) atArgsEnd() bool {
+	switch t := p.current.Type; {
+	case t == lexer.TokenCloseDelim, t == lexer.TokenPipe, t == lexer.TokenEOF,
+		t == lexer.TokenRParen, t == lexer.TokenRBrack, t == lexer.TokenRBrace,
+		t == lexer.TokenQuestion, t == lexer.TokenColon:
+		return true
+	case t == lexer.TokenComma:
+		return p.inLiteral
+	case p.isBinaryOp(t):
+		return true
+	default:
+		return t == lexer.TokenIdent && p.peek.Type == lexer.TokenAssign
+	}
+}
+
 func (p *Parser) isBinaryOp(t lexer.TokenType) bool {
-	return t == lexer.TokenPlus || t == lexer.TokenMinus ||
-		t == lexer.TokenMult || t == lexer.TokenDiv || t == lexer.TokenMod ||
-		t == lexer.TokenEqual || t == lexer.TokenNotEqual ||
-		t == lexer.TokenLess || t == lexer.TokenGreater ||
-		t == lexer.TokenLessEq || t == lexer.TokenGreaterEq ||
-		t == lexer.TokenAnd || t == lexer.TokenOr
+	return precedence(t) > 0
 }
 
 func (p *Parser) error(msg string) error {
@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/toutaio/toutago-fith-renderer/lexer"
@@ -81,6 +84,33 @@ func TestParser_NestedField(t *testing.T) {
 	}
 }
 
+func TestParser_NonASCIIFields(t *testing.T) {
+	ast, err := New(lexer.New("{{.Städte.Berlin}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	varNode, ok := ast.Nodes[0].(*VariableNode)
+	if !ok || strings.Join(varNode.Path, " ") != ". Städte Berlin" {
+		t.Errorf("expected path . Städte Berlin, got %#v", ast.Nodes[0])
+	}
+
+	ast, err = New(lexer.New("{{.Ä[0]}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := formatExpr(ast.Nodes[0]); got != ".Ä[0]" {
+		t.Errorf("expected .Ä[0], got %s", got)
+	}
+
+	ast, err = New(lexer.New("{{$größe.Wert}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := formatExpr(ast.Nodes[0]); got != "$größeWert" {
+		t.Errorf("expected $größe.Wert as one path, got %s", got)
+	}
+}
+
 func TestParser_MixedTextAndVariable(t *testing.T) {
 	input := "Hello {{.Name}}!"
 	l := lexer.New(input)
@@ -246,12 +276,134 @@ func TestParser_Pipe(t *testing.T) {
 		t.Fatalf("expected 2 filters, got %d", len(pipeNode.Filters))
 	}
 
-	if pipeNode.Filters[0] != "upper" {
-		t.Errorf("expected filter 'upper', got %q", pipeNode.Filters[0])
+	if pipeNode.Filters[0].Function != "upper" {
+		t.Errorf("expected filter 'upper', got %q", pipeNode.Filters[0].Function)
+	}
+
+	if pipeNode.Filters[1].Function != "trim" {
+		t.Errorf("expected filter 'trim', got %q", pipeNode.Filters[1].Function)
+	}
+}
+
+func TestParser_PipeWithArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantArgs []int // Number of arguments of each filter
+		wantErr  bool
+	}{
+		{name: "one argument", input: "{{.Body | truncate 100}}", wantArgs: []int{1}},
+		{name: "two arguments", input: `{{.Name | replace "a" "b" | upper}}`, wantArgs: []int{2, 0}},
+		{name: "variable arguments", input: "{{.Name | replace .Old $new}}", wantArgs: []int{2}},
+		{name: "call then pipe", input: `{{replace .Name "a" "b" | truncate 3}}`, wantArgs: []int{1}},
+		{name: "missing filter", input: "{{.Name | 100}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			pipeNode, ok := ast.Nodes[0].(*PipeNode)
+			if !ok {
+				t.Fatalf("expected PipeNode, got %T", ast.Nodes[0])
+			}
+			if len(pipeNode.Filters) != len(tt.wantArgs) {
+				t.Fatalf("expected %d filters, got %d", len(tt.wantArgs), len(pipeNode.Filters))
+			}
+			for i, want := range tt.wantArgs {
+				if got := len(pipeNode.Filters[i].Args); got != want {
+					t.Errorf("filter %d: expected %d arguments, got %d", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParser_PipelinePositions(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		find  func(Node) Node // Returns the node expected to be a pipeline or call
+		want  string          // Expected type of the found node
+	}{
+		{
+			name:  "if condition",
+			input: "{{if .Tags | len}}x{{end}}",
+			find:  func(n Node) Node { return n.(*IfNode).Condition },
+			want:  "*parser.PipeNode",
+		},
+		{
+			name:  "range collection",
+			input: "{{range .Items | first}}x{{end}}",
+			find:  func(n Node) Node { return n.(*RangeNode).Collection },
+			want:  "*parser.PipeNode",
+		},
+		{
+			name:  "include param",
+			input: `{{include "card" title=.Title | upper size=3}}`,
+			find:  func(n Node) Node { return n.(*IncludeNode).Params["title"] },
+			want:  "*parser.PipeNode",
+		},
+		{
+			name:  "include param call",
+			input: `{{include "card" title=upper .Title size=3}}`,
+			find:  func(n Node) Node { return n.(*IncludeNode).Params["title"] },
+			want:  "*parser.CallNode",
+		},
+		{
+			name:  "nested call argument",
+			input: "{{upper (trim .X)}}",
+			find:  func(n Node) Node { return n.(*CallNode).Args[0] },
+			want:  "*parser.CallNode",
+		},
+		{
+			name:  "pipeline argument",
+			input: "{{join (.Tags | first) \",\"}}",
+			find:  func(n Node) Node { return n.(*CallNode).Args[0] },
+			want:  "*parser.PipeNode",
+		},
+		{
+			name:  "index expression",
+			input: "{{.Items[.Keys | first]}}",
+			find:  func(n Node) Node { return n.(*IndexNode).Index },
+			want:  "*parser.PipeNode",
+		},
+		{
+			name:  "indexed group",
+			input: "{{(.Rows | last)[0]}}",
+			find:  func(n Node) Node { return n.(*IndexNode).Object },
+			want:  "*parser.PipeNode",
+		},
+		{
+			name:  "call as operand",
+			input: "{{if len .Items > 2}}x{{end}}",
+			find:  func(n Node) Node { return n.(*IfNode).Condition.(*BinaryOpNode).Left },
+			want:  "*parser.CallNode",
+		},
+		{
+			name:  "operation piped",
+			input: "{{.A + .B | printf}}",
+			find:  func(n Node) Node { return n.(*PipeNode).Value },
+			want:  "*parser.BinaryOpNode",
+		},
 	}
 
-	if pipeNode.Filters[1] != "trim" {
-		t.Errorf("expected filter 'trim', got %q", pipeNode.Filters[1])
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", tt.find(ast.Nodes[0])); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
 	}
 }
 
@@ -293,6 +445,274 @@ func TestParser_BinaryOp(t *testing.T) {
 	}
 }
 
+// formatExpr renders an expression tree with explicit parentheses.
+func formatExpr(node Node) string {
+	switch n := node.(type) {
+	case *BinaryOpNode:
+		return "(" + formatExpr(n.Left) + " " + n.Operator.String() + " " + formatExpr(n.Right) + ")"
+	case *UnaryOpNode:
+		return "(" + n.Operator.String() + formatExpr(n.Operand) + ")"
+	case *TernaryNode:
+		return "(" + formatExpr(n.Condition) + " ? " + formatExpr(n.Then) + " : " + formatExpr(n.Else) + ")"
+	case *ListNode:
+		elements := []string{}
+		for _, element := range n.Elements {
+			elements = append(elements, formatExpr(element))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *MapNode:
+		entries := []string{}
+		for i, key := range n.Keys {
+			entries = append(entries, key+": "+formatExpr(n.Values[i]))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case *MethodNode:
+		args := []string{formatExpr(n.Method)}
+		for _, arg := range n.Args {
+			args = append(args, formatExpr(arg))
+		}
+		return "(" + strings.Join(args, " ") + ")"
+	case *IndexNode:
+		return formatExpr(n.Object) + "[" + formatExpr(n.Index) + "]"
+	case *SliceNode:
+		low, high := "", ""
+		if n.Low != nil {
+			low = formatExpr(n.Low)
+		}
+		if n.High != nil {
+			high = formatExpr(n.High)
+		}
+		return formatExpr(n.Object) + "[" + low + ":" + high + "]"
+	case *FieldNode:
+		fields := ""
+		for i, field := range n.Path {
+			if i < len(n.Optional) && n.Optional[i] {
+				fields += "?"
+			}
+			fields += "." + field
+		}
+		return formatExpr(n.Object) + fields
+	case *PipeNode:
+		stages := []string{formatExpr(n.Value)}
+		for _, filter := range n.Filters {
+			stages = append(stages, formatExpr(filter))
+		}
+		return "(" + strings.Join(stages, " | ") + ")"
+	case *VariableNode:
+		return strings.Join(n.Path, "")
+	case *LiteralNode:
+		return fmt.Sprint(n.Value)
+	case *CallNode:
+		args := []string{n.Function}
+		for _, arg := range n.Args {
+			args = append(args, formatExpr(arg))
+		}
+		return "(" + strings.Join(args, " ") + ")"
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func TestParser_Precedence(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"{{.A + .B * 2}}", "(.A + (.B * 2))"},
+		{"{{.A * .B + 2}}", "((.A * .B) + 2)"},
+		{"{{(.A + .B) * 2}}", "((.A + .B) * 2)"},
+		{"{{.A - .B - .C}}", "((.A - .B) - .C)"},
+		{"{{.A / .B % .C}}", "((.A / .B) % .C)"},
+		{"{{.A + 1 > .B * 2}}", "((.A + 1) > (.B * 2))"},
+		{"{{.A < .B == .C >= .D}}", "((.A < .B) == (.C >= .D))"},
+		{"{{.A || .B && .C}}", "(.A || (.B && .C))"},
+		{"{{.A && .B || .C && .D}}", "((.A && .B) || (.C && .D))"},
+		{"{{.A == 1 && .B != 2}}", "((.A == 1) && (.B != 2))"},
+		{"{{!.A && .B}}", "((!.A) && .B)"},
+		{"{{-.A * 2}}", "((-.A) * 2)"},
+		{"{{!!.A}}", "(!(!.A))"},
+		{"{{len .Items > 2 && .Ok}}", "(((len .Items) > 2) && .Ok)"},
+		{"{{.A ?? .B || .C}}", "(.A ?? (.B || .C))"},
+		{"{{.A ?? .B ?? \"x\"}}", "((.A ?? .B) ?? x)"},
+		{"{{.A > 1 ? .B : .C}}", "((.A > 1) ? .B : .C)"},
+		{"{{.A ? .B : .C ? .D : .E}}", "(.A ? .B : (.C ? .D : .E))"},
+		{"{{.A ? .B ? 1 : 2 : 3}}", "(.A ? (.B ? 1 : 2) : 3)"},
+		{"{{.A ?? .B ? \"y\" : \"n\"}}", "((.A ?? .B) ? y : n)"},
+		{"{{.A ? true : nil}}", "(.A ? true : <nil>)"},
+		{`{{"a" in .B && .C}}`, "((a IN .B) && .C)"},
+		{`{{.A + "x" not in .B == false}}`, "(((.A + x) NOT IN .B) == false)"},
+		{`{{!.A in .B}}`, "((!.A) IN .B)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := formatExpr(ast.Nodes[0]); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	for _, input := range []string{"{{.A +}}", "{{.A * * .B}}", "{{&& .A}}", "{{.A ? .B}}", "{{.A ?? }}"} {
+		if _, err := New(lexer.New(input)).Parse(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParser_CollectionLiterals(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{{["a", "b", .C]}}`, "[a, b, .C]"},
+		{`{{[]}}`, "[]"},
+		{`{{[1, 2,]}}`, "[1, 2]"},
+		{`{{[[1], [.A, 2 + 3]]}}`, "[[1], [.A, (2 + 3)]]"},
+		{`{{[upper .A, .B]}}`, "[(upper .A), .B]"},
+		{`{{[(f .A, .B)]}}`, "[(f .A .B)]"},
+		{`{{{"title": .T, "size": 3}}}`, "{title: .T, size: 3}"},
+		{`{{{}}}`, "{}"},
+		{`{{{"a": {"b": [1]},}}}`, "{a: {b: [1]}}"},
+		{`{{{"a": .X ? 1 : 2}}}`, "{a: (.X ? 1 : 2)}"},
+		{`{{{"a": .X | upper, "b": 1}}}`, "{a: (.X | (upper)), b: 1}"},
+		{`{{join ["a", "b"] ","}}`, "(join [a, b] ,)"},
+		{`{{len .Items [1]}}`, "(len .Items [1])"},
+		{`{{len .Items[1]}}`, "(len .Items[1])"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := formatExpr(ast.Nodes[0]); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	errors := map[string]string{
+		`{{[1, 2}}`:            "expected , or ] in list",
+		`{{[1 2]}}`:            "expected , or ] in list",
+		`{{{"a": 1 "b": 2}}}`:  "expected , or } in map",
+		`{{{a: 1}}}`:           "expected string key in map",
+		`{{{"a" 1}}}`:          "expected : after map key",
+		`{{{"a": 1, "a": 2}}}`: `duplicate key "a" in map`,
+	}
+	for input, want := range errors {
+		_, err := New(lexer.New(input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", input, want, err)
+		}
+	}
+}
+
+func TestParser_IndexAndSlice(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{{.Items[1:3]}}`, ".Items[1:3]"},
+		{`{{.Items[:5]}}`, ".Items[:5]"},
+		{`{{.Name[2:]}}`, ".Name[2:]"},
+		{`{{.Items[:]}}`, ".Items[:]"},
+		{`{{.Items[-1]}}`, ".Items[(-1)]"},
+		{`{{.Items[-2:]}}`, ".Items[(-2):]"},
+		{`{{.Items[.A ? 1 : 2:len .Items]}}`, ".Items[(.A ? 1 : 2):(len .Items)]"},
+		{`{{.Users[1].Name}}`, ".Users[1].Name"},
+		{`{{.Users[1]?.Profile.Name}}`, ".Users[1]?.Profile.Name"},
+		{`{{.Grid[0][1]}}`, ".Grid[0][1]"},
+		{`{{.Users[0].Tags[:2]}}`, ".Users[0].Tags[:2]"},
+		{`{{$u[0].Name}}`, "$u[0].Name"},
+		{`{{(.Items | last)[0].Name}}`, "(.Items | (last))[0].Name"},
+		{`{{len .Users[0] .Name}}`, "(len .Users[0] .Name)"},
+		{`{{.Users[0].Name + "!"}}`, "(.Users[0].Name + !)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := formatExpr(ast.Nodes[0]); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	for _, input := range []string{`{{.Items[]}}`, `{{.Items[1:2:3]}}`, `{{.Users[0].}}`} {
+		if _, err := New(lexer.New(input)).Parse(); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestParser_MethodCall(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{{.User.HasRole "admin"}}`, "(.UserHasRole admin)"},
+		{`{{$u.Greet "hi" 2}}`, "($uGreet hi 2)"},
+		{`{{.User.HasRole "a" && .Ok}}`, "((.UserHasRole a) && .Ok)"},
+		{`{{!.User.HasRole "a"}}`, "(!(.UserHasRole a))"},
+		{`{{.User.Greet .Name | upper}}`, "((.UserGreet .Name) | (upper))"},
+		{`{{f .User.Name "x"}}`, "(f .UserName x)"},
+		{`{{[.User.HasRole "a", .B]}}`, "[(.UserHasRole a), .B]"},
+		{`{{.User.Name}}`, ".UserName"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := formatExpr(ast.Nodes[0]); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParser_SafeNavigation(t *testing.T) {
+	tests := []struct {
+		input    string
+		path     []string
+		optional []bool
+	}{
+		{"{{.User.Name}}", []string{".", "User", "Name"}, nil},
+		{"{{.User?.Profile?.Avatar}}", []string{".", "User", "Profile", "Avatar"}, []bool{false, false, true, true}},
+		{"{{.User.Profile?.Avatar}}", []string{".", "User", "Profile", "Avatar"}, []bool{false, false, false, true}},
+		{"{{$user?.Name}}", []string{"$user", "Name"}, []bool{false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			v, ok := ast.Nodes[0].(*VariableNode)
+			if !ok {
+				t.Fatalf("expected VariableNode, got %T", ast.Nodes[0])
+			}
+			if !reflect.DeepEqual(v.Path, tt.path) {
+				t.Errorf("expected path %v, got %v", tt.path, v.Path)
+			}
+			if !reflect.DeepEqual(v.Optional, tt.optional) {
+				t.Errorf("expected optional %v, got %v", tt.optional, v.Optional)
+			}
+		})
+	}
+}
+
 func TestParser_ComparisonOp(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -425,6 +845,96 @@ func TestParser_IfElse(t *testing.T) {
 	}
 }
 
+func TestParser_ElseIf(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantElseIfs int
+		wantElse    bool
+		wantErr     bool
+	}{
+		{name: "else if", input: "{{if .A}}a{{else if .B}}b{{end}}", wantElseIfs: 1},
+		{name: "elif", input: "{{if .A}}a{{elif .B}}b{{elif .C}}c{{else}}d{{end}}", wantElseIfs: 2, wantElse: true},
+		{name: "mixed", input: "{{if .A}}a{{else if .B}}b{{elif .C}}c{{end}}", wantElseIfs: 2},
+		{name: "nested if in branch", input: "{{if .A}}{{else if .B}}{{if .C}}c{{else}}d{{end}}{{end}}", wantElseIfs: 1},
+		{name: "else if after else", input: "{{if .A}}a{{else}}b{{else if .C}}c{{end}}", wantErr: true},
+		{name: "missing condition close", input: "{{if .A}}a{{elif .B c{{end}}", wantErr: true},
+		{name: "elif outside if", input: "{{elif .A}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			ifNode, ok := ast.Nodes[0].(*IfNode)
+			if !ok {
+				t.Fatalf("expected IfNode, got %T", ast.Nodes[0])
+			}
+			if len(ifNode.ElseIfs) != tt.wantElseIfs {
+				t.Errorf("expected %d else if branches, got %d", tt.wantElseIfs, len(ifNode.ElseIfs))
+			}
+			if (ifNode.Else != nil) != tt.wantElse {
+				t.Errorf("expected else branch = %v, got %v", tt.wantElse, ifNode.Else != nil)
+			}
+		})
+	}
+}
+
+func TestParser_RangeElse(t *testing.T) {
+	ast, err := New(lexer.New("{{range .Items}}{{.}}{{else}}none{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rangeNode, ok := ast.Nodes[0].(*RangeNode)
+	if !ok {
+		t.Fatalf("expected RangeNode, got %T", ast.Nodes[0])
+	}
+	if len(rangeNode.Body) != 1 || len(rangeNode.Else) != 1 {
+		t.Errorf("expected 1 body and 1 else node, got %d and %d", len(rangeNode.Body), len(rangeNode.Else))
+	}
+
+	if _, err := New(lexer.New("{{range .Items}}a{{else if .B}}b{{end}}")).Parse(); err == nil {
+		t.Error("expected error for else if in range")
+	}
+}
+
+func TestParser_Comments(t *testing.T) {
+	input := "{{/* header */}}{{if .A}}{{/* inside */}}a{{end}}"
+
+	ast, err := New(lexer.New(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ast.Nodes) != 1 {
+		t.Fatalf("expected comments to be discarded, got %d nodes", len(ast.Nodes))
+	}
+
+	l := lexer.New(input)
+	l.SetKeepComments(true)
+	ast, err = New(l).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ast.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(ast.Nodes))
+	}
+	comment, ok := ast.Nodes[0].(*CommentNode)
+	if !ok || comment.Text != " header " {
+		t.Errorf("expected CommentNode %q, got %#v", " header ", ast.Nodes[0])
+	}
+	ifNode := ast.Nodes[1].(*IfNode)
+	if _, ok := ifNode.Then[0].(*CommentNode); !ok {
+		t.Errorf("expected CommentNode in if body, got %T", ifNode.Then[0])
+	}
+}
+
 func TestParser_Range(t *testing.T) {
 	input := "{{range .Items}}{{.}}{{end}}"
 	l := lexer.New(input)
@@ -452,6 +962,197 @@ func TestParser_Range(t *testing.T) {
 	}
 }
 
+func TestParser_RangeVariables(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantVariable string
+		wantKeyVar   string
+		wantErr      bool
+	}{
+		{name: "no variables", input: "{{range .Items}}{{end}}"},
+		{name: "element", input: "{{range $item := .Items}}{{end}}", wantVariable: "item"},
+		{name: "key and value", input: "{{range $k, $v := .Map}}{{end}}", wantVariable: "v", wantKeyVar: "k"},
+		{name: "variable as collection", input: "{{range $items}}{{end}}"},
+		{name: "missing declare", input: "{{range $k, $v .Map}}{{end}}", wantErr: true},
+		{name: "missing second variable", input: "{{range $k, := .Map}}{{end}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			rangeNode, ok := ast.Nodes[0].(*RangeNode)
+			if !ok {
+				t.Fatalf("expected RangeNode, got %T", ast.Nodes[0])
+			}
+			if rangeNode.Variable != tt.wantVariable || rangeNode.KeyVar != tt.wantKeyVar {
+				t.Errorf("got Variable=%q KeyVar=%q, want Variable=%q KeyVar=%q",
+					rangeNode.Variable, rangeNode.KeyVar, tt.wantVariable, tt.wantKeyVar)
+			}
+		})
+	}
+}
+
+func TestParser_LoopControl(t *testing.T) {
+	ast, err := New(lexer.New("{{range .Items}}{{if .Skip}}{{continue}}{{end}}{{break}}{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rangeNode := ast.Nodes[0].(*RangeNode)
+	ifNode, ok := rangeNode.Body[0].(*IfNode)
+	if !ok {
+		t.Fatalf("expected IfNode, got %T", rangeNode.Body[0])
+	}
+	if _, ok := ifNode.Then[0].(*ContinueNode); !ok {
+		t.Errorf("expected ContinueNode, got %T", ifNode.Then[0])
+	}
+	if _, ok := rangeNode.Body[1].(*BreakNode); !ok {
+		t.Errorf("expected BreakNode, got %T", rangeNode.Body[1])
+	}
+
+	for _, input := range []string{
+		"{{break}}",
+		"{{if .A}}{{continue}}{{end}}",
+		"{{range .Items}}a{{else}}{{break}}{{end}}",
+		"{{range .Items}}{{end}}{{continue}}",
+		"{{range .Items}}{{break .A}}{{end}}",
+	} {
+		if _, err := New(lexer.New(input)).Parse(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParser_LoopMetadata(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"{{@parent.index}}", []string{"@parent", "index"}},
+		{"{{@parent?.parent.last}}", []string{"@parent", "parent", "last"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			varNode, ok := ast.Nodes[0].(*VariableNode)
+			if !ok {
+				t.Fatalf("expected VariableNode, got %T", ast.Nodes[0])
+			}
+			if strings.Join(varNode.Path, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got path %v, want %v", varNode.Path, tt.want)
+			}
+		})
+	}
+
+	// Plain loop variables and ones followed by an argument stay calls.
+	for _, input := range []string{"{{@index}}", "{{@index .A}}"} {
+		ast, _ := New(lexer.New(input)).Parse()
+		if call, ok := ast.Nodes[0].(*CallNode); !ok || call.Function != "@index" {
+			t.Errorf("%s: expected call to @index, got %#v", input, ast.Nodes[0])
+		}
+	}
+}
+
+func TestParser_With(t *testing.T) {
+	ast, err := New(lexer.New("{{with .User}}{{.Name}}{{else}}guest{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	withNode, ok := ast.Nodes[0].(*WithNode)
+	if !ok {
+		t.Fatalf("expected WithNode, got %T", ast.Nodes[0])
+	}
+	if _, ok := withNode.Value.(*VariableNode); !ok {
+		t.Errorf("expected VariableNode value, got %T", withNode.Value)
+	}
+	if len(withNode.Body) != 1 || len(withNode.Else) != 1 {
+		t.Errorf("expected 1 body and 1 else node, got %d and %d", len(withNode.Body), len(withNode.Else))
+	}
+
+	for _, input := range []string{"{{with .User}}a", "{{with}}a{{end}}", "{{with .User}}a{{else if .B}}b{{end}}"} {
+		if _, err := New(lexer.New(input)).Parse(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParser_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantVar string
+		wantErr bool
+	}{
+		{name: "expression", input: "{{set $total = .Price * .Qty}}", wantVar: "total"},
+		{name: "function call", input: "{{set $name = upper .Name}}", wantVar: "name"},
+		{name: "missing variable", input: "{{set total = 1}}", wantErr: true},
+		{name: "missing assign", input: "{{set $total .Price}}", wantErr: true},
+		{name: "missing value", input: "{{set $total =}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := New(lexer.New(tt.input)).Parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			setNode, ok := ast.Nodes[0].(*SetNode)
+			if !ok {
+				t.Fatalf("expected SetNode, got %T", ast.Nodes[0])
+			}
+			if setNode.Variable != tt.wantVar {
+				t.Errorf("expected variable %q, got %q", tt.wantVar, setNode.Variable)
+			}
+			if setNode.Value == nil {
+				t.Error("expected value expression")
+			}
+		})
+	}
+}
+
+func TestParser_VariableReference(t *testing.T) {
+	ast, err := New(lexer.New("{{f $a.Name $b .C}}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	call, ok := ast.Nodes[0].(*CallNode)
+	if !ok {
+		t.Fatalf("expected CallNode, got %T", ast.Nodes[0])
+	}
+	if len(call.Args) != 3 {
+		t.Fatalf("expected 3 arguments, got %d", len(call.Args))
+	}
+
+	want := [][]string{{"$a", "Name"}, {"$b"}, {".", "C"}}
+	for i, arg := range call.Args {
+		v, ok := arg.(*VariableNode)
+		if !ok {
+			t.Fatalf("argument %d: expected VariableNode, got %T", i, arg)
+		}
+		if strings.Join(v.Path, ",") != strings.Join(want[i], ",") {
+			t.Errorf("argument %d: expected path %v, got %v", i, want[i], v.Path)
+		}
+	}
+}
+
 func TestParser_Include(t *testing.T) {
 	input := `{{include "header"}}`
 	l := lexer.New(input)
@@ -0,0 +1,119 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+func TestRuntime_CancelRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     interface{}
+	}{
+		{"slice", "items:\n{{range .}}{{.}}{{stop}}{{end}}", []int{1, 2, 3}},
+		{"map", "items:\n{{range .}}{{.}}{{stop}}{{end}}", map[string]int{"a": 1, "b": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parser.New(lexer.New(tt.template)).Parse()
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			rt := NewRuntime(NewContext(tt.data))
+			rt.SetTemplateName("report")
+			rt.SetCancelContext(ctx)
+			rt.RegisterFunction("stop", func(args ...interface{}) (interface{}, error) {
+				cancel()
+				return "", nil
+			})
+
+			// The first iteration cancels; the loop must stop before the second.
+			err = rt.ExecuteTemplate(tmpl)
+
+			var cancelErr *CancelledError
+			if !errors.As(err, &cancelErr) {
+				t.Fatalf("expected *CancelledError, got %v", err)
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected error to wrap context.Canceled, got %v", err)
+			}
+			if cancelErr.Template != "report" || cancelErr.Line != 2 {
+				t.Errorf("expected location report:2, got %s:%d", cancelErr.Template, cancelErr.Line)
+			}
+			if rt.Output() != "items:\n1" {
+				t.Errorf("expected output to stop after one iteration, got %q", rt.Output())
+			}
+		})
+	}
+}
+
+func TestRuntime_CancelDeadline(t *testing.T) {
+	tmpl, err := parser.New(lexer.New("{{range .}}{{.}}{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	rt := NewRuntime(NewContext([]int{1, 2, 3}))
+	rt.SetTemplateName("report")
+	rt.SetCancelContext(ctx)
+
+	err = rt.ExecuteTemplate(tmpl)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `template "report"`) {
+		t.Errorf("expected error to name the template, got %q", err.Error())
+	}
+}
+
+func TestRuntime_ContextFunction(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "alice")
+
+	rt := NewRuntime(NewContext(nil))
+	rt.SetCancelContext(ctx)
+	rt.RegisterContextFunction("user", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		return ctx.Value(key{}), nil
+	})
+
+	tmpl, err := parser.New(lexer.New(`{{user}} {{"x" | user}}`)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := rt.ExecuteTemplate(tmpl); err != nil {
+		t.Fatalf("ExecuteTemplate failed: %v", err)
+	}
+	if rt.Output() != "alice alice" {
+		t.Errorf("expected %q, got %q", "alice alice", rt.Output())
+	}
+}
+
+func TestFunctionRegistry_RegisterContext(t *testing.T) {
+	reg := NewFunctionRegistry()
+	reg.RegisterContext("upper", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		return "ctx", nil
+	})
+
+	got, err := reg.Call("upper", "a")
+	if err != nil || got != "ctx" {
+		t.Errorf("Call() = %v, %v; want context function to replace built-in", got, err)
+	}
+
+	reg.Register("upper", fnUpper)
+	got, err = reg.Call("upper", "a")
+	if err != nil || got != "A" {
+		t.Errorf("Call() = %v, %v; want plain function to replace context function", got, err)
+	}
+}
@@ -0,0 +1,285 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// timeType is the reflect.Type of time.Time, which is ordered by Compare.
+var timeType = reflect.TypeOf(time.Time{})
+
+// Equal reports whether a and b are equal under the template's == operator.
+//
+// Numbers of any int, uint or float kind, json.Number and the math/big
+// types compare by value, so 1 == 1.0.
+// Strings equal strings, bools equal bools and time.Time values are equal
+// when they denote the same instant. Slices, arrays and maps are equal when
+// their elements are, by the same rules. nil, including nil pointers, maps
+// and slices, only equals nil. Pointers are compared by the values they
+// point to. Values of other types must have the same type and be deeply equal.
+//
+// Values of types that cannot be compared, like a string and a number,
+// are never equal.
+func Equal(a, b interface{}) bool {
+	equal, _ := equalValues(reflect.ValueOf(a), reflect.ValueOf(b))
+	return equal
+}
+
+// Compare orders a and b, returning -1, 0 or +1. The second result is false
+// if they are not ordered: only numbers, strings and time.Time values
+// (each among themselves) are. time.Duration orders as a number.
+func Compare(a, b interface{}) (int, bool) {
+	if order, ok, numeric := compareExactNumbers(a, b); numeric {
+		return order, ok
+	}
+
+	av, bv := indirect(reflect.ValueOf(a)), indirect(reflect.ValueOf(b))
+	if !av.IsValid() || !bv.IsValid() {
+		return 0, false
+	}
+
+	switch {
+	case isNumericKind(av.Kind()) && isNumericKind(bv.Kind()):
+		return compareNumbers(av, bv)
+	case av.Kind() == reflect.String && bv.Kind() == reflect.String:
+		return compareOrdered(av.String(), bv.String()), true
+	case av.Type() == timeType && bv.Type() == timeType:
+		return av.Interface().(time.Time).Compare(bv.Interface().(time.Time)), true
+	}
+	return 0, false
+}
+
+// membership implements the in operator: whether needle is a substring of a
+// string, an element of a slice or array, or a key of a map, with elements
+// and keys matched by Equal. A nil collection contains nothing.
+func membership(collection, needle interface{}) (bool, error) {
+	v := indirect(reflect.ValueOf(collection))
+	if isNilValue(v) {
+		return false, nil
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		s, ok := stringOperand(needle)
+		if !ok {
+			return false, fmt.Errorf("cannot look for %T in a string", needle)
+		}
+		return strings.Contains(v.String(), s), nil
+	case isList(v):
+		n := reflect.ValueOf(needle)
+		for i := 0; i < v.Len(); i++ {
+			if equal, _ := equalValues(v.Index(i), n); equal {
+				return true, nil
+			}
+		}
+		return false, nil
+	case v.Kind() == reflect.Map:
+		return hasKey(v, reflect.ValueOf(needle)), nil
+	}
+	return false, fmt.Errorf("cannot look for a value in %T", collection)
+}
+
+// hasKey reports whether map m has a key equal to key. A key of the map's
+// own key type is looked up directly; others are compared with each key.
+func hasKey(m, key reflect.Value) bool {
+	keyType := m.Type().Key()
+	if key.IsValid() && key.Type() == keyType && keyType.Kind() != reflect.Interface {
+		return m.MapIndex(key).IsValid()
+	}
+	iter := m.MapRange()
+	for iter.Next() {
+		if equal, _ := equalValues(iter.Key(), key); equal {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues implements Equal. The second result reports whether a and b
+// are comparable.
+func equalValues(a, b reflect.Value) (equal, ok bool) {
+	if a.IsValid() && b.IsValid() && a.CanInterface() && b.CanInterface() {
+		if order, ok, numeric := compareExactNumbers(a.Interface(), b.Interface()); numeric {
+			return ok && order == 0, true
+		}
+	}
+
+	a, b = indirect(a), indirect(b)
+	aNil, bNil := isNilValue(a), isNilValue(b)
+	if aNil || bNil {
+		return aNil && bNil, true
+	}
+
+	switch {
+	case isNumericKind(a.Kind()) && isNumericKind(b.Kind()):
+		order, ok := compareNumbers(a, b)
+		return ok && order == 0, true
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		return a.String() == b.String(), true
+	case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+		return a.Bool() == b.Bool(), true
+	case a.Type() == timeType && b.Type() == timeType:
+		return a.Interface().(time.Time).Equal(b.Interface().(time.Time)), true
+	case isList(a) && isList(b):
+		return equalLists(a, b), true
+	case a.Kind() == reflect.Map && b.Kind() == reflect.Map:
+		return equalMaps(a, b), true
+	case a.Type() == b.Type():
+		if a.Comparable() {
+			return a.Interface() == b.Interface(), true
+		}
+		return reflect.DeepEqual(a.Interface(), b.Interface()), true
+	}
+	return false, false
+}
+
+// compareExactNumbers orders a and b when either is a json.Number or a
+// math/big value and both are numbers. The last result reports whether
+// they were; a json.Number and a string are compared as strings.
+func compareExactNumbers(a, b interface{}) (order int, ok, numeric bool) {
+	if !isExactNumber(a) && !isExactNumber(b) {
+		return 0, false, false
+	}
+	x, xOk := toNumber(a)
+	y, yOk := toNumber(b)
+	if !xOk || !yOk {
+		return 0, false, false
+	}
+	order, ok = compareNumber(x, y)
+	return order, ok, true
+}
+
+// equalLists reports whether two slices or arrays have equal elements.
+func equalLists(a, b reflect.Value) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for i := 0; i < a.Len(); i++ {
+		if equal, _ := equalValues(a.Index(i), b.Index(i)); !equal {
+			return false
+		}
+	}
+	return true
+}
+
+// equalMaps reports whether two maps have equal keys and values.
+func equalMaps(a, b reflect.Value) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	keyType := b.Type().Key()
+	iter := a.MapRange()
+	for iter.Next() {
+		key := iter.Key()
+		if !key.Type().AssignableTo(keyType) {
+			sameKind := key.Kind() == reflect.String && keyType.Kind() == reflect.String
+			if !sameKind && (!isNumericKind(key.Kind()) || !isNumericKind(keyType.Kind())) {
+				return false
+			}
+			key = key.Convert(keyType)
+		}
+		other := b.MapIndex(key)
+		if !other.IsValid() {
+			return false
+		}
+		if equal, _ := equalValues(iter.Value(), other); !equal {
+			return false
+		}
+	}
+	return true
+}
+
+// compareNumbers orders two numeric values of any int, uint or float kind
+// without losing precision between integers. NaN is not ordered.
+func compareNumbers(a, b reflect.Value) (int, bool) {
+	switch {
+	case isFloatKind(a.Kind()) || isFloatKind(b.Kind()):
+		x, y := numberAsFloat(a), numberAsFloat(b)
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	case isUintKind(a.Kind()) && isUintKind(b.Kind()):
+		return compareOrdered(a.Uint(), b.Uint()), true
+	case isUintKind(a.Kind()):
+		if b.Int() < 0 {
+			return 1, true
+		}
+		return compareOrdered(a.Uint(), uint64(b.Int())), true
+	case isUintKind(b.Kind()):
+		if a.Int() < 0 {
+			return -1, true
+		}
+		return compareOrdered(uint64(a.Int()), b.Uint()), true
+	default:
+		return compareOrdered(a.Int(), b.Int()), true
+	}
+}
+
+// compareOrdered returns -1, 0 or +1 as x is less than, equal to or greater than y.
+func compareOrdered[T int64 | uint64 | float64 | string](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// numberAsFloat converts a numeric value to float64.
+func numberAsFloat(v reflect.Value) float64 {
+	switch {
+	case isFloatKind(v.Kind()):
+		return v.Float()
+	case isUintKind(v.Kind()):
+		return float64(v.Uint())
+	default:
+		return float64(v.Int())
+	}
+}
+
+// isFloatKind reports whether k is a floating-point kind.
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// isUintKind reports whether k is an unsigned integer kind.
+func isUintKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isList reports whether v is a slice or array.
+func isList(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
+
+// indirect follows pointers and interfaces to the value they hold.
+// Nil pointers and interfaces are returned as is.
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// isNilValue reports whether v is invalid or a nil pointer, map, slice,
+// interface, channel or function.
+func isNilValue(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package runtime
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+type point struct{ X, Y int }
+
+func TestEqual(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := 5
+	var nilSlice []string
+	var nilPtr *point
+
+	tests := []struct {
+		name string
+		a, b interface{}
+		want bool
+	}{
+		{"int and float", 1, 1.0, true},
+		{"int8 and uint", int8(3), uint(3), true},
+		{"negative and uint", -1, uint64(math.MaxUint64), false},
+		{"large uint64", uint64(math.MaxUint64), uint64(math.MaxUint64), true},
+		{"string and number", "1", 1, false},
+		{"strings", "a", "a", true},
+		{"string kinds", SafeHTML("a"), "a", true},
+		{"bools", true, true, true},
+		{"bool and number", true, 1, false},
+		{"same instant", now, now.In(time.FixedZone("X", 3600)), true},
+		{"durations", time.Second, time.Duration(1e9), true},
+		{"slices", []int{1, 2}, []interface{}{1, 2.0}, true},
+		{"slices of different length", []int{1, 2}, []int{1}, false},
+		{"maps", map[string]int{"a": 1}, map[string]interface{}{"a": 1.0}, true},
+		{"maps with different values", map[string]int{"a": 1}, map[string]int{"a": 2}, false},
+		{"nested", []interface{}{map[string]interface{}{"a": []int{1}}}, []interface{}{map[string][]int{"a": {1}}}, true},
+		{"structs", point{1, 2}, point{1, 2}, true},
+		{"different structs", point{1, 2}, point{2, 1}, false},
+		{"struct printing the same", point{1, 2}, "{1 2}", false},
+		{"pointer and value", &n, 5, true},
+		{"nil and nil", nil, nil, true},
+		{"nil slice and nil", nilSlice, nil, true},
+		{"nil pointer and nil", nilPtr, nil, true},
+		{"zero and nil", 0, nil, false},
+		{"empty string and nil", "", nil, false},
+		{"NaN", math.NaN(), math.NaN(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Equal(tt.a, tt.b); got != tt.want {
+				t.Errorf("Equal(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := Equal(tt.b, tt.a); got != tt.want {
+				t.Errorf("Equal(%v, %v) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	early := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		a, b interface{}
+		want int
+		ok   bool
+	}{
+		{"ints", 1, 2, -1, true},
+		{"int and float", 2, 1.5, 1, true},
+		{"uint and negative int", uint(0), -1, 1, true},
+		{"int64 precision", int64(1<<62 + 1), int64(1 << 62), 1, true},
+		{"strings", "apple", "banana", -1, true},
+		{"times", late, early, 1, true},
+		{"equal times", early, early, 0, true},
+		{"durations", time.Minute, time.Second, 1, true},
+		{"string and number", "10", 9, 0, false},
+		{"time and number", early, 1, 0, false},
+		{"nil", nil, 1, 0, false},
+		{"bools", false, true, 0, false},
+		{"NaN", math.NaN(), 1.0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Compare(tt.a, tt.b)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Compare(%v, %v) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRuntime_StrictComparisons(t *testing.T) {
+	data := map[string]interface{}{"Count": 3, "Label": "3", "When": time.Now()}
+
+	execute := func(input string, strict bool) (string, error) {
+		ast, err := parser.New(lexer.New(input)).Parse()
+		if err != nil {
+			return "", err
+		}
+		rt := NewRuntime(NewContext(data))
+		rt.SetStrictComparisons(strict)
+		if err := rt.ExecuteTemplate(ast); err != nil {
+			return "", err
+		}
+		return rt.Output(), nil
+	}
+
+	tests := []struct {
+		template string
+		lenient  string
+		message  string
+	}{
+		{"{{.Count == .Label}}", "false", "cannot compare int and string"},
+		{"{{.Count != .Label}}", "true", "cannot compare int and string"},
+		{"{{.Count < .Label}}", "false", "cannot order int and string"},
+		{"{{.Count >= .Label}}", "false", "cannot order int and string"},
+		{"{{.When > 0}}", "false", "cannot order time.Time and int"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := execute(tt.template, false)
+			if err != nil || output != tt.lenient {
+				t.Errorf("lenient: got %q, %v, want %q", output, err, tt.lenient)
+			}
+			_, err = execute(tt.template, true)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("strict: expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+
+	// nil and comparable operands are fine in strict mode.
+	for _, input := range []string{"{{.Missing == nil}}", "{{.Count == 3.0}}", `{{.Label == "3"}}`} {
+		if output, err := execute(input, true); err != nil || output != "true" {
+			t.Errorf("%s: got %q, %v, want true", input, output, err)
+		}
+	}
+}
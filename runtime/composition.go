@@ -13,43 +13,74 @@ type Loader interface {
 	Exists(slug string) bool
 }
 
+// AutoEscapeLoader is implemented by loaders that decide per template whether
+// its output is auto-escaped, e.g. by file extension. Included templates and
+// layouts are then escaped according to their own setting instead of the
+// caller's.
+type AutoEscapeLoader interface {
+	AutoEscape(slug string) bool
+}
+
 // CompositionRuntime extends Runtime with template composition capabilities.
 type CompositionRuntime struct {
 	*Runtime
 	loader          Loader
-	blocks          map[string][]parser.Node
+	blocks          map[string]blockDefinition
 	includeStack    []string
 	maxIncludeDepth int
 }
 
+// blockDefinition is a block override and the template that defines it.
+type blockDefinition struct {
+	body     []parser.Node
+	template string
+	escape   bool // Defining template is auto-escaped
+}
+
 // NewCompositionRuntime creates a runtime with composition support.
 func NewCompositionRuntime(ctx *Context, loader Loader) *CompositionRuntime {
-	return &CompositionRuntime{
+	r := &CompositionRuntime{
 		Runtime:         NewRuntime(ctx),
 		loader:          loader,
-		blocks:          make(map[string][]parser.Node),
+		blocks:          make(map[string]blockDefinition),
 		includeStack:    make([]string, 0),
 		maxIncludeDepth: 100, // Prevent deep recursion
 	}
+	// Route nested nodes (if/range bodies) through the composition-aware executor
+	r.Runtime.dispatch = r.executeNode
+	return r
 }
 
-// ExecuteWithLoader executes a template with loader support for composition.
-func ExecuteWithLoader(template *parser.Template, ctx *Context, loader Loader) (string, error) {
-	rt := NewCompositionRuntime(ctx, loader)
+// SetMaxIncludeDepth limits how deeply includes and layouts may be nested
+// when Limits.MaxIncludeDepth is not set. Values below 1 are ignored.
+func (r *CompositionRuntime) SetMaxIncludeDepth(depth int) {
+	if depth > 0 {
+		r.maxIncludeDepth = depth
+	}
+}
+
+// ExecuteTemplate executes a template with support for extends, include and block.
+func (r *CompositionRuntime) ExecuteTemplate(template *parser.Template) error {
+	if err := r.checkCancelled(template.Pos()); err != nil {
+		return err
+	}
 
 	// Check if template has extends directive
-	extendsNode := rt.findExtendsNode(template)
-	if extendsNode != nil {
-		return rt.executeWithExtends(template, extendsNode)
+	if extendsNode := r.findExtendsNode(template); extendsNode != nil {
+		return r.executeWithExtends(template, extendsNode, nil)
 	}
 
 	// Execute normally with composition support
-	for _, node := range template.Nodes {
-		if err := rt.executeNode(node); err != nil {
-			return "", err
-		}
+	return r.executeTemplate(template)
+}
+
+// ExecuteWithLoader executes a template with loader support for composition.
+func ExecuteWithLoader(template *parser.Template, ctx *Context, loader Loader) (string, error) {
+	rt := NewCompositionRuntime(ctx, loader)
+	if err := rt.ExecuteTemplate(template); err != nil {
+		return "", err
 	}
-	return rt.output.String(), nil
+	return rt.Output(), nil
 }
 
 // findExtendsNode finds the extends directive in a template (must be first non-text node).
@@ -75,46 +106,112 @@ func (r *CompositionRuntime) findExtendsNode(tmpl *parser.Template) *parser.Exte
 }
 
 // executeWithExtends handles template inheritance.
+// chain holds the parent templates already visited, to detect cycles.
 func (r *CompositionRuntime) executeWithExtends(
 	child *parser.Template,
 	extendsNode *parser.ExtendsNode,
-) (string, error) {
+	chain []string,
+) error {
+	// Check for circular inheritance
+	for _, slug := range chain {
+		if slug == extendsNode.Template {
+			return fmt.Errorf("circular extends detected: %q", extendsNode.Template)
+		}
+	}
+
+	if err := r.checkCancelled(extendsNode.Position); err != nil {
+		return err
+	}
+
+	// Check depth limit
+	if err := r.checkDepth(len(chain), extendsNode.Position); err != nil {
+		return err
+	}
+
 	// Collect blocks from child template
-	r.collectBlocks(child)
+	r.collectBlocks(child, r.templateName)
 
 	// Load parent template
 	parent, err := r.loader.Load(extendsNode.Template)
 	if err != nil {
-		return "", fmt.Errorf("failed to load parent template %q: %w", extendsNode.Template, err)
+		return fmt.Errorf("failed to load parent template %q: %w", extendsNode.Template, err)
 	}
+	r.templateName = extendsNode.Template
+	r.switchAutoEscape(r.autoEscapeFor(extendsNode.Template))
 
 	// Check if parent also extends
 	parentExtendsNode := r.findExtendsNode(parent)
 	if parentExtendsNode != nil {
 		// Recursive extends
-		return r.executeWithExtends(parent, parentExtendsNode)
+		return r.executeWithExtends(parent, parentExtendsNode, append(chain, extendsNode.Template))
 	}
 
 	// Execute parent template with child blocks
-	for _, node := range parent.Nodes {
-		if err := r.executeNode(node); err != nil {
-			return "", err
-		}
+	return r.executeTemplate(parent)
+}
+
+// checkDepth enforces the include depth for a nesting depth reached at pos:
+// Limits.MaxIncludeDepth when set, otherwise the SetMaxIncludeDepth value.
+func (r *CompositionRuntime) checkDepth(depth int, pos parser.Position) error {
+	max := r.maxIncludeDepth
+	if r.limits.MaxIncludeDepth > 0 {
+		max = r.limits.MaxIncludeDepth
+	}
+	if depth >= max {
+		r.pos = pos
+		return r.limitError(LimitIncludeDepth, int64(max))
+	}
+	return nil
+}
+
+// autoEscapeFor reports whether the template identified by slug is
+// auto-escaped. Without an AutoEscapeLoader the current setting is kept.
+func (r *CompositionRuntime) autoEscapeFor(slug string) bool {
+	if l, ok := r.loader.(AutoEscapeLoader); ok {
+		return l.AutoEscape(slug)
+	}
+	return r.escaper != nil
+}
+
+// switchAutoEscape turns auto-escaping on or off and returns a function that
+// restores the previous setting. An escaper already in use is kept, so an
+// escaped template continues in the HTML context of its caller.
+func (r *CompositionRuntime) switchAutoEscape(enabled bool) func() {
+	saved := r.escaper
+	if !enabled {
+		r.escaper = nil
+	} else if saved == nil {
+		r.escaper = newEscaper()
 	}
-	return r.output.String(), nil
+	return func() { r.escaper = saved }
 }
 
 // collectBlocks collects all block definitions from a template.
-func (r *CompositionRuntime) collectBlocks(tmpl *parser.Template) {
+// Blocks collected earlier (from more derived templates) take precedence.
+func (r *CompositionRuntime) collectBlocks(tmpl *parser.Template, slug string) {
 	for _, node := range tmpl.Nodes {
 		if blockNode, ok := node.(*parser.BlockNode); ok {
-			r.blocks[blockNode.Name] = blockNode.Body
+			if _, exists := r.blocks[blockNode.Name]; !exists {
+				r.blocks[blockNode.Name] = blockDefinition{
+					body:     blockNode.Body,
+					template: slug,
+					escape:   r.escaper != nil,
+				}
+			}
 		}
 	}
 }
 
 // executeNode overrides the base executeNode to handle composition nodes.
 func (r *CompositionRuntime) executeNode(node parser.Node) error {
+	switch node.(type) {
+	case *parser.IncludeNode, *parser.BlockNode, *parser.ExtendsNode:
+		// The base runtime counts the nodes it executes; count these here
+		if err := r.countNode(node); err != nil {
+			return err
+		}
+	}
+
 	switch n := node.(type) {
 	case *parser.IncludeNode:
 		return r.executeInclude(n)
@@ -131,6 +228,10 @@ func (r *CompositionRuntime) executeNode(node parser.Node) error {
 
 // executeInclude handles template inclusion.
 func (r *CompositionRuntime) executeInclude(node *parser.IncludeNode) error {
+	if err := r.checkCancelled(node.Position); err != nil {
+		return err
+	}
+
 	// Check for circular includes
 	for _, slug := range r.includeStack {
 		if slug == node.Template {
@@ -139,8 +240,8 @@ func (r *CompositionRuntime) executeInclude(node *parser.IncludeNode) error {
 	}
 
 	// Check depth limit
-	if len(r.includeStack) >= r.maxIncludeDepth {
-		return fmt.Errorf("maximum include depth exceeded (%d)", r.maxIncludeDepth)
+	if err := r.checkDepth(len(r.includeStack), node.Position); err != nil {
+		return err
 	}
 
 	// Load the included template
@@ -149,15 +250,24 @@ func (r *CompositionRuntime) executeInclude(node *parser.IncludeNode) error {
 		return fmt.Errorf("failed to load include %q: %w", node.Template, err)
 	}
 
+	// Create new context for include, evaluated in the including template
+	includeCtx, err := r.createIncludeContext(node)
+	if err != nil {
+		return err
+	}
+
 	// Push to include stack
 	r.includeStack = append(r.includeStack, node.Template)
+	savedName := r.templateName
+	r.templateName = node.Template
 	defer func() {
 		// Pop from include stack
 		r.includeStack = r.includeStack[:len(r.includeStack)-1]
+		r.templateName = savedName
 	}()
 
-	// Create new context for include
-	includeCtx := r.createIncludeContext(node)
+	// Escape the include according to its own setting
+	defer r.switchAutoEscape(r.autoEscapeFor(node.Template))()
 
 	// Save and restore context
 	savedCtx := r.context
@@ -176,15 +286,16 @@ func (r *CompositionRuntime) executeInclude(node *parser.IncludeNode) error {
 }
 
 // createIncludeContext creates a context for an included template.
-func (r *CompositionRuntime) createIncludeContext(node *parser.IncludeNode) *Context {
+// Errors from evaluating the context or a parameter are returned, so
+// strict mode reports undefined values passed to an include.
+func (r *CompositionRuntime) createIncludeContext(node *parser.IncludeNode) (*Context, error) {
 	// If context is explicitly provided, use it
 	if node.Context != nil {
 		val, err := r.Runtime.evaluateExpression(node.Context)
 		if err != nil {
-			// Fall back to current context on error
-			return r.context
+			return nil, err
 		}
-		return NewContext(val)
+		return NewContext(val), nil
 	}
 
 	// If parameters are provided, create new context with params
@@ -193,24 +304,28 @@ func (r *CompositionRuntime) createIncludeContext(node *parser.IncludeNode) *Con
 		for key, valueNode := range node.Params {
 			val, err := r.Runtime.evaluateExpression(valueNode)
 			if err != nil {
-				// Skip invalid params
-				continue
+				return nil, err
 			}
 			data[key] = val
 		}
-		return NewContext(data)
+		return NewContext(data), nil
 	}
 
 	// Otherwise inherit current context
-	return r.context
+	return r.context, nil
 }
 
 // executeBlock handles block directives.
 func (r *CompositionRuntime) executeBlock(node *parser.BlockNode) error {
 	// Check if block has been overridden
-	if overrideBody, exists := r.blocks[node.Name]; exists {
-		// Execute override content
-		for _, n := range overrideBody {
+	if override, exists := r.blocks[node.Name]; exists {
+		// Execute override content in the context of its defining template
+		savedName := r.templateName
+		r.templateName = override.template
+		defer func() { r.templateName = savedName }()
+		defer r.switchAutoEscape(override.escape)()
+
+		for _, n := range override.body {
 			if err := r.executeNode(n); err != nil {
 				return err
 			}
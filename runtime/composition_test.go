@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -98,6 +99,85 @@ func TestCompositionRuntime_IncludeWithParams(t *testing.T) {
 	}
 }
 
+func TestCompositionRuntime_IncludeWithPipedParams(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("card", "{{.title}}|{{.count}}|{{.first}}")
+	loader.Add("main", `{{include "card" title=.Title | upper count=len .Items first=(.Items | first)}}`)
+
+	ctx := NewContext(map[string]interface{}{"Title": "hello", "Items": []string{"a", "b"}})
+
+	tmpl, err := loader.Load("main")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	output, err := ExecuteWithLoader(tmpl, ctx, loader)
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+
+	if output != "HELLO|2|a" {
+		t.Errorf("Expected %q, got %q", "HELLO|2|a", output)
+	}
+}
+
+func TestCompositionRuntime_IncludeWithLiteralContext(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("card", "{{.title}}:{{range .tags}}[{{.}}]{{end}}")
+	loader.Add("main", `{{include "card" {"title": .Title, "tags": ["new", .Tag]}}}`)
+
+	ctx := NewContext(map[string]interface{}{"Title": "hello", "Tag": "sale"})
+
+	tmpl, err := loader.Load("main")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	output, err := ExecuteWithLoader(tmpl, ctx, loader)
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+
+	if output != "hello:[new][sale]" {
+		t.Errorf("Expected %q, got %q", "hello:[new][sale]", output)
+	}
+}
+
+func TestCompositionRuntime_IncludeUndefinedParams(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("card", "[{{.title}}]")
+	loader.Add("params", `{{include "card" title=.Missing}}`)
+	loader.Add("context", `{{include "card" .Missing}}`)
+
+	for _, slug := range []string{"params", "context"} {
+		tmpl, err := loader.Load(slug)
+		if err != nil {
+			t.Fatalf("Failed to load template: %v", err)
+		}
+
+		// Lenient mode renders the undefined value as empty
+		output, err := ExecuteWithLoader(tmpl, NewContext(map[string]interface{}{}), loader)
+		if err != nil {
+			t.Fatalf("%s: execution failed: %v", slug, err)
+		}
+		if output != "[]" {
+			t.Errorf("%s: expected %q, got %q", slug, "[]", output)
+		}
+
+		rt := NewCompositionRuntime(NewContext(map[string]interface{}{}), loader)
+		rt.SetStrictMode(true)
+		rt.SetTemplateName(slug)
+		err = rt.ExecuteTemplate(tmpl)
+		var undefErr *UndefinedError
+		if !errors.As(err, &undefErr) {
+			t.Fatalf("%s: expected *UndefinedError, got %v", slug, err)
+		}
+		if undefErr.Path != ".Missing" || undefErr.Template != slug {
+			t.Errorf("%s: got %s in %q, want .Missing in %q", slug, undefErr.Path, undefErr.Template, slug)
+		}
+	}
+}
+
 func TestCompositionRuntime_CircularInclude(t *testing.T) {
 	loader := newMockLoader()
 	loader.Add("a", `{{include "b"}}`)
@@ -278,8 +358,9 @@ func TestCompositionRuntime_MaxIncludeDepth(t *testing.T) {
 		t.Fatal("Expected error for excessive include depth")
 	}
 
-	if !strings.Contains(err.Error(), "depth") {
-		t.Errorf("Expected depth error, got: %v", err)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitIncludeDepth || limitErr.Max != 100 {
+		t.Errorf("Expected include depth limit of 100, got: %v", err)
 	}
 }
 
@@ -359,3 +440,100 @@ func TestCompositionRuntime_FileSystemIntegration(t *testing.T) {
 		t.Errorf("Expected content to be rendered, got: %q", output)
 	}
 }
+
+func TestCompositionRuntime_IncludeInsideRange(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("item", "[{{.}}]")
+	loader.Add("main", `{{range .Items}}{{include "item"}}{{end}}`)
+
+	ctx := NewContext(map[string]interface{}{
+		"Items": []string{"a", "b"},
+	})
+
+	tmpl, err := loader.Load("main")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	output, err := ExecuteWithLoader(tmpl, ctx, loader)
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+
+	expected := "[a][b]"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestCompositionRuntime_MultiLevelExtends(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("base", `<{{block "title"}}Base{{end}}>`)
+	loader.Add("section", `{{extends "base"}}{{block "title"}}Section{{end}}`)
+	loader.Add("page", `{{extends "section"}}{{block "title"}}Page{{end}}`)
+
+	ctx := NewContext(map[string]interface{}{})
+
+	tmpl, err := loader.Load("page")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	output, err := ExecuteWithLoader(tmpl, ctx, loader)
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+
+	expected := "<Page>"
+	if output != expected {
+		t.Errorf("Expected most derived block to win, got %q", output)
+	}
+}
+
+func TestCompositionRuntime_CircularExtends(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("a", `{{extends "b"}}`)
+	loader.Add("b", `{{extends "a"}}`)
+
+	tmpl, err := loader.Load("a")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	_, err = ExecuteWithLoader(tmpl, NewContext(nil), loader)
+	if err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Errorf("Expected circular extends error, got: %v", err)
+	}
+}
+
+func TestCompositionRuntime_SetMaxIncludeDepth(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("a", `{{include "b"}}`)
+	loader.Add("b", `{{include "c"}}`)
+	loader.Add("c", "C")
+
+	tmpl, err := loader.Load("a")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	rt := NewCompositionRuntime(NewContext(nil), loader)
+	rt.SetMaxIncludeDepth(1)
+
+	err = rt.ExecuteTemplate(tmpl)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitIncludeDepth || limitErr.Max != 1 {
+		t.Fatalf("Expected include depth limit of 1, got: %v", err)
+	}
+
+	// Limits.MaxIncludeDepth replaces the SetMaxIncludeDepth value
+	rt = NewCompositionRuntime(NewContext(nil), loader)
+	rt.SetMaxIncludeDepth(1)
+	rt.SetLimits(Limits{MaxIncludeDepth: 5})
+	if err := rt.ExecuteTemplate(tmpl); err != nil {
+		t.Fatalf("Expected Limits.MaxIncludeDepth to replace the default, got: %v", err)
+	}
+	if got := rt.Output(); got != "C" {
+		t.Errorf("Output = %q, want %q", got, "C")
+	}
+}
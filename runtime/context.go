@@ -3,7 +3,6 @@ package runtime
 import (
 	"fmt"
 	"reflect"
-	"strings"
 )
 
 // Context holds the data context for template execution.
@@ -23,14 +22,38 @@ func NewContext(data interface{}) *Context {
 
 // Get retrieves a value from the context using dot notation.
 // Examples: ".", ".Name", ".User.Email", ".Items[0]"
+//
+// A component may also name an exported method without arguments, such
+// as .User.FullName, which is called; a failing call yields a *MethodError.
+// A variable, field or key that does not exist yields an *UndefinedError,
+// as does accessing a field on a nil value. Existing nil values are
+// returned without error.
 func (c *Context) Get(path []string) (interface{}, error) {
+	return c.GetOptional(path, nil)
+}
+
+// GetOptional is like Get, but components of path marked in optional
+// (accessed with ?. in the template) yield nil instead of an
+// *UndefinedError when the value before them is nil or undefined.
+// optional may be nil; otherwise it has the same length as path.
+func (c *Context) GetOptional(path []string, optional []bool) (interface{}, error) {
+	return c.resolve(path, optional, resolveOptions{methods: true})
+}
+
+// resolveOptions controls how path components are resolved.
+type resolveOptions struct {
+	methods bool         // Components may name methods
+	fields  FieldOptions // How components name struct fields
+}
+
+// resolve implements GetOptional.
+func (c *Context) resolve(path []string, optional []bool, opts resolveOptions) (interface{}, error) {
 	if len(path) == 0 {
 		return nil, fmt.Errorf("empty path")
 	}
 
 	// Start with root data if path begins with "."
 	var current interface{}
-	pathIndex := 0
 
 	if path[0] == "." {
 		// Check if "." has been redefined in a scope (e.g., in a range loop)
@@ -45,7 +68,6 @@ func (c *Context) Get(path []string) (interface{}, error) {
 		if !found {
 			current = c.data
 		}
-		pathIndex = 1
 	} else {
 		// Try to find in scopes first
 		varName := path[0]
@@ -58,31 +80,68 @@ func (c *Context) Get(path []string) (interface{}, error) {
 			}
 		}
 		if !found {
-			return nil, fmt.Errorf("variable not found: %s", varName)
+			if isOptional(optional, 1) {
+				return nil, nil
+			}
+			return nil, &UndefinedError{
+				Path:       formatPath(path),
+				Name:       varName,
+				Suggestion: closestName(varName, c.variableNames()),
+			}
 		}
-		pathIndex = 1
 	}
 
-	// If just ".", return root data
-	if pathIndex >= len(path) {
-		return current, nil
-	}
+	return c.walk(current, path, 1, optional, opts)
+}
+
+// walk resolves the components of path from start on, beginning at
+// current, the value of the components before start.
+func (c *Context) walk(current interface{}, path []string, start int, optional []bool, opts resolveOptions) (interface{}, error) {
+	for i := start; i < len(path); i++ {
+		if isNil(current) {
+			if isOptional(optional, i) {
+				return nil, nil
+			}
+			return nil, &UndefinedError{
+				Path:   formatPath(path),
+				Name:   path[i],
+				NilRef: formatPath(path[:i]),
+			}
+		}
 
-	// Traverse the path
-	for i := pathIndex; i < len(path); i++ {
-		current = c.getField(current, path[i])
-		if current == nil {
-			return nil, fmt.Errorf("nil value at path: %s", strings.Join(path[:i+1], "."))
+		val, ok := c.getField(current, path[i], opts.fields)
+		if !ok && opts.methods {
+			var err error
+			if val, ok, err = callMethod(current, path[i], nil); err != nil {
+				return nil, err
+			}
 		}
+		if !ok {
+			if isOptional(optional, i+1) {
+				return nil, nil
+			}
+			return nil, &UndefinedError{
+				Path:       formatPath(path),
+				Name:       path[i],
+				Suggestion: closestName(path[i], fieldNames(current, opts.fields)),
+			}
+		}
+		current = val
 	}
 
 	return current, nil
 }
 
-// getField retrieves a field from a struct, map, or slice using reflection.
-func (c *Context) getField(obj interface{}, field string) interface{} {
+// isOptional reports whether path component i is accessed with ?..
+func isOptional(optional []bool, i int) bool {
+	return i < len(optional) && optional[i]
+}
+
+// getField retrieves a field from a struct or a key from a map using reflection.
+// The second return value reports whether the field or key exists.
+func (c *Context) getField(obj interface{}, field string, opts FieldOptions) (interface{}, bool) {
 	if obj == nil {
-		return nil
+		return nil, false
 	}
 
 	val := reflect.ValueOf(obj)
@@ -90,32 +149,50 @@ func (c *Context) getField(obj interface{}, field string) interface{} {
 	// Dereference pointers
 	for val.Kind() == reflect.Ptr {
 		if val.IsNil() {
-			return nil
+			return nil, false
 		}
 		val = val.Elem()
 	}
 
 	switch val.Kind() {
 	case reflect.Struct:
-		// Access struct field by name
-		fieldVal := val.FieldByName(field)
-		if !fieldVal.IsValid() {
-			return nil
+		// Access exported struct field by name
+		index, ok := lookupField(val.Type(), field, opts)
+		if !ok {
+			return nil, false
+		}
+		fieldVal, err := val.FieldByIndexErr(index)
+		if err != nil {
+			return nil, false // Nil embedded pointer
 		}
-		return fieldVal.Interface()
+		return fieldVal.Interface(), true
 
 	case reflect.Map:
 		// Access map by key
-		mapKey := reflect.ValueOf(field)
+		if val.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		mapKey := reflect.ValueOf(field).Convert(val.Type().Key())
 		fieldVal := val.MapIndex(mapKey)
 		if !fieldVal.IsValid() {
-			return nil
+			return nil, false
 		}
-		return fieldVal.Interface()
+		return fieldVal.Interface(), true
 
 	default:
-		return nil
+		return nil, false
+	}
+}
+
+// variableNames returns the names of all variables in scope.
+func (c *Context) variableNames() []string {
+	var names []string
+	for _, scope := range c.scopes {
+		for name := range scope {
+			names = append(names, name)
+		}
 	}
+	return names
 }
 
 // Set sets a value in the current scope.
@@ -126,6 +203,19 @@ func (c *Context) Set(name string, value interface{}) {
 	c.scopes[len(c.scopes)-1][name] = value
 }
 
+// assign updates the innermost scope that already binds name, so a loop body
+// can change a variable set before the loop; an unbound name is set in the
+// current scope.
+func (c *Context) assign(name string, value interface{}) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if _, ok := c.scopes[i][name]; ok {
+			c.scopes[i][name] = value
+			return
+		}
+	}
+	c.Set(name, value)
+}
+
 // PushScope creates a new variable scope.
 func (c *Context) PushScope() {
 	c.scopes = append(c.scopes, make(map[string]interface{}))
@@ -138,10 +228,14 @@ func (c *Context) PopScope() {
 	}
 }
 
-// GetIndex retrieves a value from a slice or map by index/key.
+// GetIndex retrieves a value from a slice, array or string by position,
+// or from a map by key. Negative positions count from the end, so -1 is
+// the last element, and a string is indexed by rune. A map key is
+// converted to the map's key type, so the number 1 finds the int64 key 1.
+// A missing key yields nil; indexing a nil value yields an *UndefinedError.
 func (c *Context) GetIndex(obj, index interface{}) (interface{}, error) {
 	if obj == nil {
-		return nil, fmt.Errorf("cannot index nil value")
+		return nil, nilAccess(fmt.Sprintf("[%v]", index))
 	}
 
 	val := reflect.ValueOf(obj)
@@ -149,26 +243,34 @@ func (c *Context) GetIndex(obj, index interface{}) (interface{}, error) {
 	// Dereference pointers
 	for val.Kind() == reflect.Ptr {
 		if val.IsNil() {
-			return nil, fmt.Errorf("cannot index nil pointer")
+			return nil, nilAccess(fmt.Sprintf("[%v]", index))
 		}
 		val = val.Elem()
 	}
 
 	switch val.Kind() {
-	case reflect.Slice, reflect.Array:
-		// Index must be an integer
-		idx, ok := index.(int)
+	case reflect.Slice, reflect.Array, reflect.String:
+		idx, ok := toIndex(index)
 		if !ok {
 			return nil, fmt.Errorf("array/slice index must be integer, got %T", index)
 		}
-		if idx < 0 || idx >= val.Len() {
-			return nil, fmt.Errorf("index out of bounds: %d (len=%d)", idx, val.Len())
+		if val.Kind() == reflect.String {
+			runes := []rune(val.String())
+			if i := position(idx, len(runes)); i >= 0 && i < len(runes) {
+				return string(runes[i]), nil
+			}
+			return nil, fmt.Errorf("index out of bounds: %d (len=%d)", idx, len(runes))
+		}
+		if i := position(idx, val.Len()); i >= 0 && i < val.Len() {
+			return val.Index(i).Interface(), nil
 		}
-		return val.Index(idx).Interface(), nil
+		return nil, fmt.Errorf("index out of bounds: %d (len=%d)", idx, val.Len())
 
 	case reflect.Map:
-		// Use the index as map key
-		mapKey := reflect.ValueOf(index)
+		mapKey, ok := convertKey(index, val.Type().Key())
+		if !ok {
+			return nil, nil // No key of this value
+		}
 		mapVal := val.MapIndex(mapKey)
 		if !mapVal.IsValid() {
 			return nil, nil // Key not found, return nil
@@ -180,11 +282,130 @@ func (c *Context) GetIndex(obj, index interface{}) (interface{}, error) {
 	}
 }
 
+// nilAccess is the *UndefinedError for indexing or slicing a nil value,
+// where name is the index or slice, like [0] or [1:3].
+func nilAccess(name string) error {
+	return &UndefinedError{Path: name, Name: name, NilRef: "nil"}
+}
+
+// sliceName formats the bounds of a slice expression, like [1:3] or [:5].
+func sliceName(low, high interface{}) string {
+	var l, h string
+	if low != nil {
+		l = fmt.Sprint(low)
+	}
+	if high != nil {
+		h = fmt.Sprint(high)
+	}
+	return "[" + l + ":" + h + "]"
+}
+
+// GetSlice slices a slice, array or string from low up to but excluding
+// high, like .Items[1:3]. A nil bound means the start or end. Negative
+// bounds count from the end and bounds beyond the length are clamped, so
+// .Items[:5] takes at most five elements. Strings are sliced by rune.
+func (c *Context) GetSlice(obj, low, high interface{}) (interface{}, error) {
+	if obj == nil {
+		return nil, nilAccess(sliceName(low, high))
+	}
+
+	val := reflect.ValueOf(obj)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil, nilAccess(sliceName(low, high))
+		}
+		val = val.Elem()
+	}
+
+	var length int
+	var runes []rune
+	switch val.Kind() {
+	case reflect.String:
+		runes = []rune(val.String())
+		length = len(runes)
+	case reflect.Slice, reflect.Array:
+		length = val.Len()
+	default:
+		return nil, fmt.Errorf("cannot slice type %s", val.Kind())
+	}
+
+	lo, hi := 0, length
+	for _, bound := range []struct {
+		value interface{}
+		dst   *int
+	}{{low, &lo}, {high, &hi}} {
+		if bound.value == nil {
+			continue
+		}
+		idx, ok := toIndex(bound.value)
+		if !ok {
+			return nil, fmt.Errorf("slice index must be integer, got %T", bound.value)
+		}
+		*bound.dst = min(max(position(idx, length), 0), length)
+	}
+	hi = max(lo, hi)
+
+	switch val.Kind() {
+	case reflect.String:
+		return string(runes[lo:hi]), nil
+	case reflect.Array:
+		// Arrays held in interfaces are not addressable, so slice a copy
+		array := reflect.New(val.Type()).Elem()
+		array.Set(val)
+		val = array
+	}
+	return val.Slice(lo, hi).Interface(), nil
+}
+
+// toIndex converts a number with an integer value to an int.
+func toIndex(index interface{}) (int, bool) {
+	n, ok := toNumber(index)
+	if !ok {
+		return 0, false
+	}
+	idx, ok := n.convert(reflect.TypeOf(0))
+	if !ok {
+		return 0, false
+	}
+	return int(idx.Int()), true
+}
+
+// position resolves a possibly negative index against length.
+func position(idx, length int) int {
+	if idx < 0 {
+		return idx + length
+	}
+	return idx
+}
+
+// convertKey converts index to a map key of type keyType: numbers to any
+// numeric key type that holds their value exactly, and strings to named
+// string types.
+func convertKey(index interface{}, keyType reflect.Type) (reflect.Value, bool) {
+	key := reflect.ValueOf(index)
+	switch {
+	case !key.IsValid():
+		return reflect.Value{}, false
+	case key.Type().AssignableTo(keyType):
+		return key, true
+	case key.Kind() == reflect.String && keyType.Kind() == reflect.String:
+		return key.Convert(keyType), true
+	case isNumericKind(keyType.Kind()):
+		if n, ok := toNumber(index); ok {
+			return n.convert(keyType)
+		}
+	}
+	return reflect.Value{}, false
+}
+
 // IsTruthy evaluates whether a value is considered true in template context.
 func IsTruthy(val interface{}) bool {
 	if val == nil {
 		return false
 	}
+	if n, ok := toNumber(val); ok && isExactNumber(val) {
+		return n.sign() != 0
+	}
 
 	v := reflect.ValueOf(val)
 
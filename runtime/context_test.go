@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -161,6 +162,60 @@ func TestContext_GetIndex(t *testing.T) {
 			index:   "not an int",
 			wantErr: true,
 		},
+		{
+			name:  "negative index",
+			obj:   []string{"a", "b", "c"},
+			index: -1,
+			want:  "c",
+		},
+		{
+			name:    "negative index out of bounds",
+			obj:     []string{"a", "b", "c"},
+			index:   -4,
+			wantErr: true,
+		},
+		{
+			name:  "array with int64 index",
+			obj:   [2]string{"a", "b"},
+			index: int64(1),
+			want:  "b",
+		},
+		{
+			name:    "fractional index",
+			obj:     []int{1, 2, 3},
+			index:   1.5,
+			wantErr: true,
+		},
+		{
+			name:  "string by rune",
+			obj:   "héllo",
+			index: 1,
+			want:  "é",
+		},
+		{
+			name:  "map with int64 key from int",
+			obj:   map[int64]string{7: "seven"},
+			index: 7,
+			want:  "seven",
+		},
+		{
+			name:  "map with uint8 key from integral float",
+			obj:   map[uint8]string{7: "seven"},
+			index: 7.0,
+			want:  "seven",
+		},
+		{
+			name:  "map with named string key",
+			obj:   map[SafeHTML]int{"x": 1},
+			index: "x",
+			want:  1,
+		},
+		{
+			name:  "map key that does not fit",
+			obj:   map[uint8]string{7: "seven"},
+			index: -1,
+			want:  nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -177,6 +232,51 @@ func TestContext_GetIndex(t *testing.T) {
 	}
 }
 
+func TestContext_GetSlice(t *testing.T) {
+	ctx := NewContext(nil)
+	items := []int{0, 1, 2, 3, 4}
+
+	tests := []struct {
+		name      string
+		obj       interface{}
+		low, high interface{}
+		want      interface{}
+	}{
+		{"range", items, 1, 3, []int{1, 2}},
+		{"from start", items, nil, 2, []int{0, 1}},
+		{"to end", items, 3, nil, []int{3, 4}},
+		{"whole", items, nil, nil, items},
+		{"clamped", items, nil, 10, items},
+		{"negative", items, -2, nil, []int{3, 4}},
+		{"negative beyond start", items, -10, 1, []int{0}},
+		{"inverted", items, 3, 1, []int{}},
+		{"array", [3]string{"a", "b", "c"}, 1, nil, []string{"b", "c"}},
+		{"string by rune", "héllo", 0, 2, "hé"},
+		{"string from end", "héllo", -3, nil, "llo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ctx.GetSlice(tt.obj, tt.low, tt.high)
+			if err != nil {
+				t.Fatalf("GetSlice() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetSlice() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	for _, obj := range []interface{}{nil, 5, map[string]int{}} {
+		if _, err := ctx.GetSlice(obj, 0, 1); err == nil {
+			t.Errorf("GetSlice(%v): expected error", obj)
+		}
+	}
+	if _, err := ctx.GetSlice(items, "a", nil); err == nil {
+		t.Error("expected error for non-integer bound")
+	}
+}
+
 func TestToSlice(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -273,28 +373,28 @@ func TestContext_GetField_Struct(t *testing.T) {
 	ctx := NewContext(nil)
 	person := Person{Name: "Alice", Age: 30}
 
-	name := ctx.getField(person, "Name")
+	name, _ := ctx.getField(person, "Name", FieldOptions{})
 	if name != "Alice" {
 		t.Errorf("expected 'Alice', got %v", name)
 	}
 
-	age := ctx.getField(person, "Age")
+	age, _ := ctx.getField(person, "Age", FieldOptions{})
 	if age != 30 {
 		t.Errorf("expected 30, got %v", age)
 	}
 
 	// Non-existent field
-	invalid := ctx.getField(person, "NonExistent")
-	if invalid != nil {
-		t.Errorf("expected nil for non-existent field, got %v", invalid)
+	invalid, ok := ctx.getField(person, "NonExistent", FieldOptions{})
+	if invalid != nil || ok {
+		t.Errorf("expected nil and not found for non-existent field, got %v, %v", invalid, ok)
 	}
 }
 
 func TestContext_GetField_Nil(t *testing.T) {
 	ctx := NewContext(nil)
-	result := ctx.getField(nil, "field")
-	if result != nil {
-		t.Errorf("expected nil, got %v", result)
+	result, ok := ctx.getField(nil, "field", FieldOptions{})
+	if result != nil || ok {
+		t.Errorf("expected nil and not found, got %v, %v", result, ok)
 	}
 }
 
@@ -0,0 +1,269 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+// UndefinedError is returned when a template references a variable, field or
+// map key that does not exist, or accesses a field on a nil value.
+// In lenient mode the runtime renders such values as empty instead.
+type UndefinedError struct {
+	Path       string // Full path as written, e.g. ".User.Emial"
+	Name       string // The segment that could not be resolved
+	NilRef     string // Path of the nil value being accessed, if any
+	Suggestion string // Closest existing name, if any
+	Template   string // Template slug (may be empty)
+	Line       int    // Line of the expression (1-indexed)
+	Column     int    // Column of the expression (1-indexed)
+}
+
+// Error implements the error interface.
+func (e *UndefinedError) Error() string {
+	var b strings.Builder
+	if e.NilRef != "" {
+		fmt.Fprintf(&b, "cannot access %q on nil value %s", e.Name, e.NilRef)
+	} else {
+		fmt.Fprintf(&b, "undefined variable %s", e.Path)
+	}
+	if e.Template != "" {
+		fmt.Fprintf(&b, " in template %q", e.Template)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " at %d:%d", e.Line, e.Column)
+	}
+	if e.Suggestion != "" {
+		fmt.Fprintf(&b, " (did you mean %q?)", e.Suggestion)
+	}
+	return b.String()
+}
+
+// Location returns the template slug, line and column of the error.
+func (e *UndefinedError) Location() (slug string, line, column int) {
+	return e.Template, e.Line, e.Column
+}
+
+// CancelledError is returned when the render's context.Context is cancelled
+// or its deadline passes. It records where execution stopped and wraps
+// context.Canceled or context.DeadlineExceeded.
+type CancelledError struct {
+	Template string // Template slug (may be empty)
+	Line     int    // Line of the loop or include being executed
+	Column   int    // Column of the loop or include being executed
+	Err      error  // The context error
+}
+
+// Error implements the error interface.
+func (e *CancelledError) Error() string {
+	var b strings.Builder
+	b.WriteString("render stopped")
+	if e.Template != "" {
+		fmt.Fprintf(&b, " in template %q", e.Template)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " at %d:%d", e.Line, e.Column)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+// Unwrap returns the underlying context error.
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// Location returns the template slug, line and column where execution stopped.
+func (e *CancelledError) Location() (slug string, line, column int) {
+	return e.Template, e.Line, e.Column
+}
+
+// LimitError is returned when a render exceeds one of its Limits.
+type LimitError struct {
+	Limit    string // Name of the limit that tripped, e.g. LimitLoopIterations
+	Max      int64  // Configured value of the limit
+	Template string // Template slug (may be empty)
+	Line     int    // Line of the node being executed
+	Column   int    // Column of the node being executed
+}
+
+// Error implements the error interface.
+func (e *LimitError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "limit %s (%d) exceeded", e.Limit, e.Max)
+	if e.Template != "" {
+		fmt.Fprintf(&b, " in template %q", e.Template)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " at %d:%d", e.Line, e.Column)
+	}
+	return b.String()
+}
+
+// Location returns the template slug, line and column where the limit tripped.
+func (e *LimitError) Location() (slug string, line, column int) {
+	return e.Template, e.Line, e.Column
+}
+
+// MethodError is returned when a method called from a template returns an
+// error or cannot be called with the given arguments.
+type MethodError struct {
+	Method   string // Method name, e.g. "HasRole"
+	Type     string // Receiver type, e.g. "*app.User"
+	Err      error  // The error returned by the method or the call
+	Template string // Template slug (may be empty)
+	Line     int    // Line of the expression (1-indexed)
+	Column   int    // Column of the expression (1-indexed)
+}
+
+// Error implements the error interface.
+func (e *MethodError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "error calling %s on %s", e.Method, e.Type)
+	if e.Template != "" {
+		fmt.Fprintf(&b, " in template %q", e.Template)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " at %d:%d", e.Line, e.Column)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *MethodError) Unwrap() error {
+	return e.Err
+}
+
+// Location returns the template slug, line and column of the call.
+func (e *MethodError) Location() (slug string, line, column int) {
+	return e.Template, e.Line, e.Column
+}
+
+// formatPath renders a variable path the way it is written in templates.
+func formatPath(path []string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	if path[0] == "." {
+		return "." + strings.Join(path[1:], ".")
+	}
+	return strings.Join(path, ".")
+}
+
+// describeNode formats an expression whose fields are accessed, like
+// .Users[0], for error messages.
+func describeNode(node parser.Node) string {
+	switch n := node.(type) {
+	case *parser.VariableNode:
+		return formatPath(n.Path)
+	case *parser.LiteralNode:
+		if s, ok := n.Value.(string); ok {
+			return strconv.Quote(s)
+		}
+		return fmt.Sprint(n.Value)
+	case *parser.UnaryOpNode:
+		if n.Operator == lexer.TokenMinus {
+			return "-" + describeNode(n.Operand)
+		}
+		return "(...)"
+	case *parser.IndexNode:
+		return describeNode(n.Object) + "[" + describeNode(n.Index) + "]"
+	case *parser.SliceNode:
+		var low, high string
+		if n.Low != nil {
+			low = describeNode(n.Low)
+		}
+		if n.High != nil {
+			high = describeNode(n.High)
+		}
+		return describeNode(n.Object) + "[" + low + ":" + high + "]"
+	case *parser.FieldNode:
+		return formatPath(append([]string{describeNode(n.Object)}, n.Path...))
+	default:
+		return "(...)"
+	}
+}
+
+// isNil reports whether val is nil or a nil pointer, map, slice or interface.
+func isNil(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// fieldNames lists the exported field names of a struct or the string keys of a map.
+func fieldNames(obj interface{}, opts FieldOptions) []string {
+	val := reflect.ValueOf(obj)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	var names []string
+	switch val.Kind() {
+	case reflect.Struct:
+		return cachedFields(val.Type(), opts.Tags).names
+	case reflect.Map:
+		if val.Type().Key().Kind() == reflect.String {
+			for _, key := range val.MapKeys() {
+				names = append(names, key.String())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// closestName returns the candidate closest to name, or "" if none is close enough.
+// A case-insensitive match always wins; otherwise the edit distance must be
+// at most a third of the name's length (minimum 2).
+func closestName(name string, candidates []string) string {
+	best := ""
+	bestDist := max(2, len([]rune(name))/3) + 1
+
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, name) {
+			return candidate
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance computes the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
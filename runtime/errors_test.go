@@ -0,0 +1,116 @@
+package runtime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUndefinedError_StrictMode(t *testing.T) {
+	type User struct {
+		Name  string
+		Email string
+	}
+
+	data := map[string]interface{}{
+		"User": User{Name: "Alice", Email: "alice@example.com"},
+	}
+
+	_, err := executeStrict("Hello\n  {{.User.Emial}}", data)
+	if err == nil {
+		t.Fatal("expected error for missing field")
+	}
+
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) {
+		t.Fatalf("expected *UndefinedError, got %T: %v", err, err)
+	}
+
+	if undefined.Path != ".User.Emial" {
+		t.Errorf("expected path .User.Emial, got %q", undefined.Path)
+	}
+	if undefined.Template != "test" {
+		t.Errorf("expected template 'test', got %q", undefined.Template)
+	}
+	if undefined.Line != 2 || undefined.Column != 5 {
+		t.Errorf("expected location 2:5, got %d:%d", undefined.Line, undefined.Column)
+	}
+	if undefined.Suggestion != "Email" {
+		t.Errorf("expected suggestion 'Email', got %q", undefined.Suggestion)
+	}
+
+	msg := undefined.Error()
+	for _, want := range []string{".User.Emial", `"test"`, "2:5", `did you mean "Email"`} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected error message to contain %q, got %q", want, msg)
+		}
+	}
+}
+
+func TestUndefinedError_InConditionsAndLoops(t *testing.T) {
+	data := map[string]interface{}{"Items": []int{1}}
+
+	tests := []string{
+		"{{if .Missing}}x{{end}}",
+		"{{range .Missing}}x{{end}}",
+		"{{upper .Missing}}",
+		"{{.Missing | upper}}",
+	}
+
+	for _, tmpl := range tests {
+		t.Run(tmpl, func(t *testing.T) {
+			_, err := executeStrict(tmpl, data)
+			var undefined *UndefinedError
+			if !errors.As(err, &undefined) {
+				t.Errorf("expected *UndefinedError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUndefinedError_DefaultRescues(t *testing.T) {
+	data := map[string]interface{}{"Name": ""}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"missing field", `{{default .Missing "guest"}}`, "guest"},
+		{"empty field", `{{default .Name "guest"}}`, "guest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, execute := range []func(string, interface{}) (string, error){executeTemplate, executeStrict} {
+				output, err := execute(tt.template, data)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if output != tt.expected {
+					t.Errorf("expected %q, got %q", tt.expected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestClosestName(t *testing.T) {
+	candidates := []string{"Email", "Name", "Address"}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Emial", "Email"},
+		{"email", "Email"},
+		{"Adress", "Address"},
+		{"Zzzzzz", ""},
+	}
+
+	for _, tt := range tests {
+		if got := closestName(tt.name, candidates); got != tt.expected {
+			t.Errorf("closestName(%q) = %q, want %q", tt.name, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,623 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// unsafeValue replaces values that cannot be made safe in their context,
+// such as javascript: URLs or CSS expressions.
+const unsafeValue = "ZfithZ"
+
+// escapeState is the HTML parsing state the escaper is in.
+type escapeState int
+
+const (
+	stateText        escapeState = iota // HTML text content
+	stateTagName                        // Reading a tag name after <
+	stateTag                            // Inside a tag, between attributes
+	stateAttrName                       // Reading an attribute name
+	stateAfterName                      // After an attribute name, before = or the next attribute
+	stateBeforeValue                    // After =, before the attribute value
+	stateAttrValue                      // Inside an attribute value
+	stateComment                        // Inside <!-- ... -->
+	stateScript                         // Inside a <script> element
+	stateStyle                          // Inside a <style> element
+)
+
+// jsState is the lexical state of the JS or CSS source the escaper is in,
+// outside of string literals.
+type jsState int
+
+const (
+	jsCode         jsState = iota // Code, outside comments and literals
+	jsSlash                       // After a / whose meaning depends on the next character
+	jsLineComment                 // Inside a // comment
+	jsBlockComment                // Inside a /* */ comment
+	jsRegexp                      // Inside a regular expression literal
+)
+
+// regexpKeywords are the keywords after which a / starts a regular
+// expression rather than a division.
+var regexpKeywords = map[string]bool{
+	"case": true, "delete": true, "do": true, "else": true, "in": true,
+	"instanceof": true, "new": true, "of": true, "return": true, "throw": true,
+	"typeof": true, "void": true, "yield": true, "await": true,
+}
+
+// attrKind classifies attribute values by the language they contain.
+type attrKind int
+
+const (
+	attrNormal attrKind = iota // Plain attribute text
+	attrURL                    // href, src and other URL-valued attributes
+	attrJS                     // Event handlers (onclick, ...)
+	attrCSS                    // style attribute
+)
+
+// urlAttributes lists attributes whose values are URLs.
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"codebase":   true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"src":        true,
+	"usemap":     true,
+	"xmlns":      true,
+}
+
+// safeURLSchemes lists URL schemes that may appear at the start of a URL value.
+var safeURLSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tel":    true,
+	"ftp":    true,
+}
+
+// escaper tracks the HTML context of the output written so far so that
+// values can be escaped for the exact position they are written to.
+type escaper struct {
+	state    escapeState
+	tagName  string
+	endTag   bool
+	attrName string
+	attr     attrKind
+	delim    byte // Attribute quote character, or 0 for unquoted values
+	urlStart bool // No URL text has been written in the current attribute yet
+	urlQuery bool // Current URL attribute has passed a ? or #
+	quote    byte // Open string quote inside JS or CSS, or 0
+	escaped  bool // Previous JS/CSS string or regexp character was a backslash
+	js       jsState
+	regexpOK bool   // A / in JS code here starts a regular expression
+	word     string // Identifier being read in JS code, to spot keywords
+	star     bool   // Previous character in a block comment was *
+	class    bool   // Inside a [...] class of a regular expression
+}
+
+// newEscaper creates an escaper starting in HTML text context.
+func newEscaper() *escaper {
+	return &escaper{state: stateText}
+}
+
+// feed advances the context by the given template text.
+func (e *escaper) feed(s string) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch e.state {
+		case stateText:
+			if c != '<' {
+				continue
+			}
+			if strings.HasPrefix(s[i:], "<!--") {
+				e.state = stateComment
+				i += 3
+			} else if i+1 < len(s) && (isASCIILetter(s[i+1]) || s[i+1] == '/') {
+				e.state = stateTagName
+				e.tagName = ""
+				e.endTag = false
+			}
+
+		case stateTagName:
+			if c == '/' && e.tagName == "" {
+				e.endTag = true
+			} else if isASCIILetter(c) || isASCIIDigit(c) || c == '-' {
+				e.tagName += string(toLowerASCII(c))
+			} else {
+				e.state = stateTag
+				i-- // Reprocess as part of the tag
+			}
+
+		case stateTag:
+			switch {
+			case c == '>':
+				e.closeTag()
+			case isHTMLSpace(c) || c == '/':
+			default:
+				e.state = stateAttrName
+				e.attrName = string(toLowerASCII(c))
+			}
+
+		case stateAttrName:
+			switch {
+			case c == '=':
+				e.state = stateBeforeValue
+			case c == '>':
+				e.closeTag()
+			case c == '/':
+				e.state = stateTag
+			case isHTMLSpace(c):
+				e.state = stateAfterName
+			default:
+				e.attrName += string(toLowerASCII(c))
+			}
+
+		case stateAfterName:
+			switch {
+			case c == '=':
+				e.state = stateBeforeValue
+			case c == '>':
+				e.closeTag()
+			case isHTMLSpace(c):
+			default:
+				e.state = stateAttrName
+				e.attrName = string(toLowerASCII(c))
+			}
+
+		case stateBeforeValue:
+			switch {
+			case isHTMLSpace(c):
+			case c == '>':
+				e.closeTag()
+			case c == '"' || c == '\'':
+				e.beginValue(c)
+			default:
+				e.beginValue(0)
+				e.valueChar(c)
+			}
+
+		case stateAttrValue:
+			switch {
+			case e.delim != 0 && c == e.delim:
+				e.state = stateTag
+			case e.delim == 0 && isHTMLSpace(c):
+				e.state = stateTag
+			case e.delim == 0 && c == '>':
+				e.closeTag()
+			default:
+				e.valueChar(c)
+			}
+
+		case stateComment:
+			if strings.HasPrefix(s[i:], "-->") {
+				e.state = stateText
+				i += 2
+			}
+
+		case stateScript, stateStyle:
+			if c == '<' && hasPrefixFold(s[i:], "</"+e.tagName) {
+				e.state = stateText
+				i-- // Let text handling open the end tag
+				continue
+			}
+			e.trackQuote(c, e.state == stateScript)
+		}
+	}
+}
+
+// closeTag handles the > that ends a tag.
+func (e *escaper) closeTag() {
+	e.state = stateText
+	if e.endTag {
+		return
+	}
+	switch e.tagName {
+	case "script":
+		e.state = stateScript
+	case "style":
+		e.state = stateStyle
+	}
+	e.resetSource()
+}
+
+// beginValue starts an attribute value delimited by delim (0 for unquoted).
+func (e *escaper) beginValue(delim byte) {
+	e.state = stateAttrValue
+	e.delim = delim
+	e.attr = classifyAttr(e.attrName)
+	e.urlStart = true
+	e.urlQuery = false
+	e.resetSource()
+}
+
+// resetSource starts tracking new JS or CSS source, such as the content
+// of a <script> element or an event handler attribute.
+func (e *escaper) resetSource() {
+	e.quote = 0
+	e.escaped = false
+	e.js = jsCode
+	e.regexpOK = true
+	e.word = ""
+	e.class = false
+}
+
+// valueChar records a literal character inside an attribute value.
+func (e *escaper) valueChar(c byte) {
+	switch e.attr {
+	case attrURL:
+		e.urlStart = false
+		if c == '?' || c == '#' {
+			e.urlQuery = true
+		}
+	case attrJS:
+		e.trackQuote(c, true)
+	case attrCSS:
+		e.trackQuote(c, false)
+	}
+}
+
+// trackQuote follows string literals, comments and, in JS, regular
+// expression literals in JS or CSS source, so that a quote inside a
+// comment or regexp is not taken to start a string.
+func (e *escaper) trackQuote(c byte, js bool) {
+	if e.quote != 0 {
+		switch {
+		case e.escaped:
+			e.escaped = false
+		case c == '\\':
+			e.escaped = true
+		case c == e.quote:
+			e.quote = 0
+			e.regexpOK = false
+		}
+		return
+	}
+
+	switch e.js {
+	case jsLineComment:
+		if c == '\n' || c == '\r' {
+			e.js = jsCode
+		}
+		return
+	case jsBlockComment:
+		if e.star && c == '/' {
+			e.js = jsCode
+		}
+		e.star = c == '*'
+		return
+	case jsRegexp:
+		switch {
+		case e.escaped:
+			e.escaped = false
+		case c == '\\':
+			e.escaped = true
+		case c == '[':
+			e.class = true
+		case c == ']':
+			e.class = false
+		case c == '/' && !e.class:
+			e.js = jsCode
+			e.regexpOK = false
+		}
+		return
+	case jsSlash:
+		e.js = jsCode
+		switch {
+		case c == '/' && js:
+			e.js = jsLineComment
+			return
+		case c == '*':
+			e.js = jsBlockComment
+			e.star = false
+			return
+		case js && e.regexpOK:
+			e.js = jsRegexp
+			e.trackQuote(c, js)
+			return
+		}
+		e.regexpOK = true // The / was a division
+	}
+
+	switch {
+	case c == '/':
+		e.js = jsSlash
+		e.word = ""
+	case c == '"' || c == '\'' || (js && c == '`'):
+		e.quote = c
+		e.word = ""
+	case isASCIILetter(c) || isASCIIDigit(c) || c == '_' || c == '$' || c >= utf8.RuneSelf:
+		e.word += string(c)
+		e.regexpOK = regexpKeywords[e.word]
+	case isHTMLSpace(c):
+		e.word = ""
+	default:
+		// After a closing bracket a / divides; after an operator or other
+		// punctuation it starts a regexp.
+		e.word = ""
+		e.regexpOK = c != ')' && c != ']'
+	}
+}
+
+// sourceContext resolves a pending / before a value is written: the value
+// then starts a regexp or follows a division.
+func (e *escaper) sourceContext(js bool) {
+	if e.js != jsSlash {
+		return
+	}
+	if js && e.regexpOK {
+		e.js = jsRegexp
+	} else {
+		e.js = jsCode
+		e.regexpOK = true
+	}
+}
+
+// escape formats val and escapes it for the current context.
+func (e *escaper) escape(val interface{}) string {
+	if s, ok := e.trusted(val); ok {
+		if e.state == stateBeforeValue {
+			e.beginValue(0)
+		}
+		// Trusted output may open tags, attributes or strings itself
+		e.feed(s)
+		return s
+	}
+
+	switch e.state {
+	case stateBeforeValue:
+		// Value starts an unquoted attribute: {{"<a href="}}{{.URL}}
+		e.beginValue(0)
+		s := e.escapeAttrValue(val)
+		e.urlStart = false
+		return s
+	case stateAttrValue:
+		s := e.escapeAttrValue(val)
+		e.urlStart = false
+		return s
+	case stateTag, stateTagName, stateAttrName, stateAfterName:
+		return escapeUnquotedAttr(fmt.Sprint(val))
+	case stateScript:
+		return e.escapeJS(val)
+	case stateStyle:
+		return e.escapeCSS(val)
+	default:
+		return html.EscapeString(fmt.Sprint(val))
+	}
+}
+
+// escapeAttrValue escapes a value written inside an attribute value.
+func (e *escaper) escapeAttrValue(val interface{}) string {
+	var s string
+	switch e.attr {
+	case attrURL:
+		s = e.escapeURL(fmt.Sprint(val))
+	case attrJS:
+		s = e.escapeJS(val)
+	case attrCSS:
+		s = e.escapeCSS(val)
+	default:
+		s = fmt.Sprint(val)
+	}
+	if e.delim == 0 {
+		return escapeUnquotedAttr(s)
+	}
+	return html.EscapeString(s)
+}
+
+// escapeURL escapes a value inside a URL attribute.
+func (e *escaper) escapeURL(s string) string {
+	if e.urlQuery {
+		return url.QueryEscape(s)
+	}
+	if e.urlStart && !isSafeURL(s) {
+		return "#" + unsafeValue
+	}
+	return normalizeURL(s)
+}
+
+// classifyAttr determines what kind of content an attribute holds.
+func classifyAttr(name string) attrKind {
+	name = strings.TrimPrefix(name, "data-")
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[i+1:] // Namespaced attributes like xlink:href
+	}
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return attrJS
+	case name == "style":
+		return attrCSS
+	case urlAttributes[name], strings.Contains(name, "url"), strings.Contains(name, "uri"):
+		return attrURL
+	default:
+		return attrNormal
+	}
+}
+
+// isSafeURL reports whether a URL has no scheme or an allowed one.
+func isSafeURL(s string) bool {
+	i := strings.IndexAny(s, ":/?#")
+	if i < 0 || s[i] != ':' {
+		return true
+	}
+	return safeURLSchemes[strings.ToLower(strings.TrimSpace(s[:i]))]
+}
+
+// normalizeURL percent-encodes characters that may not appear in a URL,
+// leaving reserved characters and existing escapes intact.
+func normalizeURL(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isASCIILetter(c) || isASCIIDigit(c) || strings.IndexByte("-._~:/?#[]@!$&*+,;=%", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// escapeJS escapes a value for the current position in JavaScript source.
+// Inside a string literal or comment the value is escaped as string
+// content and inside a regexp literal as regexp content; in code it is
+// emitted as a JSON value.
+func (e *escaper) escapeJS(val interface{}) string {
+	e.sourceContext(true)
+	switch {
+	case e.quote != 0, e.js == jsLineComment, e.js == jsBlockComment:
+		return escapeJSString(fmt.Sprint(val))
+	case e.js == jsRegexp:
+		return escapeJSRegexp(fmt.Sprint(val))
+	}
+	e.regexpOK = false // A / after the value divides it
+	e.word = ""
+	return escapeJSValue(val)
+}
+
+// escapeJSValue formats val as a JSON value, quoting strings.
+func escapeJSValue(val interface{}) string {
+	if v := reflect.ValueOf(val); v.Kind() == reflect.String {
+		return `"` + escapeJSString(v.String()) + `"`
+	}
+	if stringer, ok := val.(fmt.Stringer); ok {
+		return `"` + escapeJSString(stringer.String()) + `"`
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return `"` + escapeJSString(fmt.Sprint(val)) + `"`
+	}
+	// json.Marshal already escapes <, >, & and the U+2028/U+2029 line breakers
+	return string(data)
+}
+
+// escapeJSString escapes string content for a JS string literal.
+func escapeJSString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'', '"', '`', '<', '>', '&', '=', '/', '$':
+			fmt.Fprintf(&b, `\u%04x`, r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\u2028', '\u2029':
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// escapeJSRegexp escapes content for a JS regular expression literal, so
+// that it matches the value literally.
+func escapeJSRegexp(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf && strings.ContainsRune(".*+?^|(){}[]-", r) {
+			b.WriteByte('\\')
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteString(escapeJSString(string(r)))
+	}
+	return b.String()
+}
+
+// escapeCSS escapes a value for the current position in CSS source.
+func (e *escaper) escapeCSS(val interface{}) string {
+	e.sourceContext(false)
+	if e.js == jsBlockComment {
+		// Escape like a string, so the value cannot end the comment
+		return escapeCSSString(fmt.Sprint(val), '"')
+	}
+	return escapeCSSString(fmt.Sprint(val), e.quote)
+}
+
+// escapeCSSString escapes a value for CSS. Outside a CSS string only simple
+// values (keywords, numbers, colors) are allowed through.
+func escapeCSSString(s string, quote byte) string {
+	if quote == 0 && !isSafeCSSValue(s) {
+		return unsafeValue
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf && strings.ContainsRune("\x00\t\n\f\r\"&'()+/:;<>\\{}", r) {
+			fmt.Fprintf(&b, `\%x `, r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isSafeCSSValue reports whether s is a plain CSS value without
+// functions, comments or statement separators.
+func isSafeCSSValue(s string) bool {
+	lower := strings.ToLower(s)
+	if strings.Contains(lower, "expression") || strings.Contains(lower, "javascript") ||
+		strings.Contains(lower, "/*") || strings.Contains(lower, "url") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isASCIILetter(c) && !isASCIIDigit(c) && strings.IndexByte(" #%+-.,!_", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeUnquotedAttr escapes a value written where no quotes protect it.
+func escapeUnquotedAttr(s string) string {
+	return strings.NewReplacer(
+		"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;",
+		" ", "&#32;", "\t", "&#9;", "\n", "&#10;", "\r", "&#13;", "\f", "&#12;",
+		"=", "&#61;", "`", "&#96;",
+	).Replace(s)
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring ASCII case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func toLowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,200 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+// executeEscaped parses and executes a template with auto-escaping enabled.
+func executeEscaped(input string, data interface{}) (string, error) {
+	p := parser.New(lexer.New(input))
+	ast, err := p.Parse()
+	if err != nil {
+		return "", err
+	}
+
+	rt := NewRuntime(NewContext(data))
+	rt.SetAutoEscape(true)
+	if err := rt.ExecuteTemplate(ast); err != nil {
+		return "", err
+	}
+	return rt.Output(), nil
+}
+
+func TestAutoEscape_Contexts(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     interface{}
+		expected string
+	}{
+		{
+			name:     "html text",
+			template: `<p>{{.}}</p>`,
+			data:     `<b>"Tom" & 'Jerry'</b>`,
+			expected: `<p>&lt;b&gt;&#34;Tom&#34; &amp; &#39;Jerry&#39;&lt;/b&gt;</p>`,
+		},
+		{
+			name:     "quoted attribute",
+			template: `<input value="{{.}}">`,
+			data:     `"><script>`,
+			expected: `<input value="&#34;&gt;&lt;script&gt;">`,
+		},
+		{
+			name:     "unquoted attribute",
+			template: `<input value={{.}}>`,
+			data:     `a b=c`,
+			expected: `<input value=a&#32;b&#61;c>`,
+		},
+		{
+			name:     "url attribute rejects javascript scheme",
+			template: `<a href="{{.}}">x</a>`,
+			data:     `javascript:alert(1)`,
+			expected: `<a href="#ZfithZ">x</a>`,
+		},
+		{
+			name:     "url attribute keeps safe url",
+			template: `<a href="{{.}}">x</a>`,
+			data:     `https://example.com/a b?x=1&y=2`,
+			expected: `<a href="https://example.com/a%20b?x=1&amp;y=2">x</a>`,
+		},
+		{
+			name:     "url query parameter",
+			template: `<a href="/search?q={{.}}">x</a>`,
+			data:     `a&b c`,
+			expected: `<a href="/search?q=a%26b+c">x</a>`,
+		},
+		{
+			name:     "script value",
+			template: `<script>var name = {{.}};</script>`,
+			data:     `</script><b>`,
+			expected: `<script>var name = "\u003c\u002fscript\u003e\u003cb\u003e";</script>`,
+		},
+		{
+			name:     "script string",
+			template: `<script>var name = '{{.}}';</script>`,
+			data:     `it's`,
+			expected: `<script>var name = 'it\u0027s';</script>`,
+		},
+		{
+			name:     "quote in script line comment",
+			template: "<script>\n// don't\nvar x = {{.}};\n</script>",
+			data:     `alert(1)`,
+			expected: "<script>\n// don't\nvar x = \"alert(1)\";\n</script>",
+		},
+		{
+			name:     "quote in script block comment",
+			template: `<script>/* it's */ var x = {{.}};</script>`,
+			data:     `alert(1)`,
+			expected: `<script>/* it's */ var x = "alert(1)";</script>`,
+		},
+		{
+			name:     "quote in regexp literal",
+			template: `<script>var re = /'/; var x = {{.}};</script>`,
+			data:     `alert(1)`,
+			expected: `<script>var re = /'/; var x = "alert(1)";</script>`,
+		},
+		{
+			name:     "regexp class and keyword",
+			template: `<script>if (a) return /[/']/.test(b) ? {{.}} : 0;</script>`,
+			data:     `alert(1)`,
+			expected: `<script>if (a) return /[/']/.test(b) ? "alert(1)" : 0;</script>`,
+		},
+		{
+			name:     "division is not a regexp",
+			template: `<script>var n = a / 2, s = '{{.}}';</script>`,
+			data:     `x'y`,
+			expected: `<script>var n = a / 2, s = 'x\u0027y';</script>`,
+		},
+		{
+			name:     "value in script comment",
+			template: `<script>/* {{.}} */</script>`,
+			data:     "*/alert(1)\n",
+			expected: `<script>/* *\u002falert(1)\n */</script>`,
+		},
+		{
+			name:     "value in regexp literal",
+			template: `<script>var re = /^{{.}}$/;</script>`,
+			data:     `a.b/c`,
+			expected: `<script>var re = /^a\.b\u002fc$/;</script>`,
+		},
+		{
+			name:     "template literal substitution",
+			template: "<script>var s = `{{.}}`;</script>",
+			data:     "${alert(1)}",
+			expected: "<script>var s = `\\u0024{alert(1)}`;</script>",
+		},
+		{
+			name:     "quote in event handler comment",
+			template: `<a onclick="/* don't */ go({{.}})">`,
+			data:     `alert(1)`,
+			expected: `<a onclick="/* don't */ go(&#34;alert(1)&#34;)">`,
+		},
+		{
+			name:     "quote in style comment",
+			template: `<style>/* it's */ p { color: {{.}}; }</style>`,
+			data:     `red; background: url(evil)`,
+			expected: `<style>/* it's */ p { color: ZfithZ; }</style>`,
+		},
+		{
+			name:     "script number",
+			template: `<script>var n = {{.}};</script>`,
+			data:     42,
+			expected: `<script>var n = 42;</script>`,
+		},
+		{
+			name:     "event handler attribute",
+			template: `<button onclick="go('{{.}}')">`,
+			data:     `x');evil('`,
+			expected: `<button onclick="go('x\u0027);evil(\u0027')">`,
+		},
+		{
+			name:     "style element",
+			template: `<style>p { color: {{.}}; }</style>`,
+			data:     `red; background: url(evil)`,
+			expected: `<style>p { color: ZfithZ; }</style>`,
+		},
+		{
+			name:     "style attribute",
+			template: `<p style="color: {{.}}">`,
+			data:     `#fff`,
+			expected: `<p style="color: #fff">`,
+		},
+		{
+			name:     "context resumes after script",
+			template: `<script>var a = 1;</script><p>{{.}}</p>`,
+			data:     `<i>`,
+			expected: `<script>var a = 1;</script><p>&lt;i&gt;</p>`,
+		},
+		{
+			name:     "context spans text nodes",
+			template: `<a title="{{.}}" href="{{.}}">`,
+			data:     `x"y`,
+			expected: `<a title="x&#34;y" href="x%22y">`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeEscaped(tt.template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestAutoEscape_Disabled(t *testing.T) {
+	output, err := executeTemplate(`<p>{{.}}</p>`, "<b>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "<p><b></p>" {
+		t.Errorf("expected raw output, got %q", output)
+	}
+}
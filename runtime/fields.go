@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FieldOptions controls how names in template paths resolve to struct
+// fields. Unexported fields are never accessible.
+type FieldOptions struct {
+	// Tags lists struct tag keys naming fields, checked in order, e.g.
+	// []string{"fith", "json"}. The first tag giving a name wins and the Go
+	// field name keeps working; a tag name of "-" hides the field.
+	Tags []string
+
+	// CaseInsensitive matches names ignoring case when there is no exact match.
+	CaseInsensitive bool
+}
+
+// structFields holds the names of the accessible fields of a struct type.
+type structFields struct {
+	names  []string         // Preferred name of each field, sorted, for suggestions
+	byName map[string][]int // Field index by exact name
+	byFold map[string][]int // Field index by lower-case name
+}
+
+// fieldCacheKey identifies the fields of a type as named by a set of tags.
+type fieldCacheKey struct {
+	typ  reflect.Type
+	tags string
+}
+
+// fieldCache maps a fieldCacheKey to its *structFields, so reflection over
+// a type's fields happens once rather than on every access.
+var fieldCache sync.Map
+
+// lookupField finds the field of struct type t named name.
+func lookupField(t reflect.Type, name string, opts FieldOptions) ([]int, bool) {
+	fields := cachedFields(t, opts.Tags)
+	if index, ok := fields.byName[name]; ok {
+		return index, true
+	}
+	if opts.CaseInsensitive {
+		index, ok := fields.byFold[strings.ToLower(name)]
+		return index, ok
+	}
+	return nil, false
+}
+
+// cachedFields returns the fields of struct type t, computing them on first use.
+func cachedFields(t reflect.Type, tags []string) *structFields {
+	key := fieldCacheKey{typ: t, tags: strings.Join(tags, ",")}
+	if fields, ok := fieldCache.Load(key); ok {
+		return fields.(*structFields)
+	}
+	fields, _ := fieldCache.LoadOrStore(key, newStructFields(t, tags))
+	return fields.(*structFields)
+}
+
+// newStructFields collects the exported fields of t, including promoted
+// ones. Tag names take precedence over Go names of other fields.
+func newStructFields(t reflect.Type, tags []string) *structFields {
+	fields := &structFields{
+		byName: make(map[string][]int),
+		byFold: make(map[string][]int),
+	}
+
+	var visible []reflect.StructField
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		name, hidden := tagName(field, tags)
+		if hidden {
+			continue
+		}
+		visible = append(visible, field)
+		if name != "" {
+			fields.add(name, field.Index)
+		}
+		if !field.Anonymous {
+			if name == "" {
+				name = field.Name
+			}
+			fields.names = append(fields.names, name)
+		}
+	}
+	for _, field := range visible {
+		fields.add(field.Name, field.Index)
+	}
+
+	sort.Strings(fields.names)
+	return fields
+}
+
+// add registers a name for the field at index, unless the name is taken.
+func (f *structFields) add(name string, index []int) {
+	if _, ok := f.byName[name]; !ok {
+		f.byName[name] = index
+	}
+	if _, ok := f.byFold[strings.ToLower(name)]; !ok {
+		f.byFold[strings.ToLower(name)] = index
+	}
+}
+
+// tagName returns the name given to field by the first of tags that names
+// it, and whether a "-" name hides the field.
+func tagName(field reflect.StructField, tags []string) (name string, hidden bool) {
+	for _, key := range tags {
+		value, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(value, ",")
+		if name == "-" {
+			return "", true
+		}
+		if name != "" {
+			return name, false
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+type fieldAudit struct {
+	CreatedBy string `json:"created_by"`
+}
+
+type taggedUser struct {
+	fieldAudit
+	FirstName string `json:"first_name"`
+	Nick      string `fith:"nickname" json:"nick"`
+	Password  string `json:"-"`
+	Email     string `json:",omitempty"`
+	secret    string `fith:"secret"`
+}
+
+func TestLookupField(t *testing.T) {
+	typ := reflect.TypeOf(taggedUser{})
+	tags := []string{"fith", "json"}
+
+	tests := []struct {
+		name  string
+		opts  FieldOptions
+		found bool
+	}{
+		{"FirstName", FieldOptions{}, true},
+		{"first_name", FieldOptions{}, false},
+		{"Password", FieldOptions{}, true},
+		{"CreatedBy", FieldOptions{}, true},
+		{"firstname", FieldOptions{}, false},
+		{"secret", FieldOptions{}, false},
+		{"first_name", FieldOptions{Tags: tags}, true},
+		{"FirstName", FieldOptions{Tags: tags}, true},
+		{"nickname", FieldOptions{Tags: tags}, true},
+		{"nick", FieldOptions{Tags: tags}, false},
+		{"nick", FieldOptions{Tags: []string{"json"}}, true},
+		{"Password", FieldOptions{Tags: tags}, false},
+		{"Email", FieldOptions{Tags: tags}, true},
+		{"created_by", FieldOptions{Tags: tags}, true},
+		{"secret", FieldOptions{Tags: tags}, false},
+		{"firstname", FieldOptions{CaseInsensitive: true}, true},
+		{"FIRST_NAME", FieldOptions{Tags: tags, CaseInsensitive: true}, true},
+		{"password", FieldOptions{Tags: tags, CaseInsensitive: true}, false},
+		{"SECRET", FieldOptions{Tags: tags, CaseInsensitive: true}, false},
+	}
+
+	for _, tt := range tests {
+		_, found := lookupField(typ, tt.name, tt.opts)
+		if found != tt.found {
+			t.Errorf("lookupField(%q, %+v) found = %v, want %v", tt.name, tt.opts, found, tt.found)
+		}
+	}
+
+	if cachedFields(typ, tags) != cachedFields(typ, tags) {
+		t.Error("expected fields to be cached per type and tags")
+	}
+	if cachedFields(typ, tags) == cachedFields(typ, nil) {
+		t.Error("expected separate cache entries for different tags")
+	}
+}
+
+func TestRuntime_FieldOptions(t *testing.T) {
+	data := map[string]interface{}{
+		"User": &taggedUser{
+			fieldAudit: fieldAudit{CreatedBy: "admin"},
+			FirstName:  "Ada",
+			Nick:       "ada99",
+			Password:   "hunter2",
+		},
+	}
+
+	execute := func(input string, opts FieldOptions) (string, error) {
+		ast, err := parser.New(lexer.New(input)).Parse()
+		if err != nil {
+			return "", err
+		}
+		rt := NewRuntime(NewContext(data))
+		rt.SetStrictMode(true)
+		rt.SetFieldOptions(opts)
+		if err := rt.ExecuteTemplate(ast); err != nil {
+			return "", err
+		}
+		return rt.Output(), nil
+	}
+
+	opts := FieldOptions{Tags: []string{"fith", "json"}, CaseInsensitive: true}
+	output, err := execute("{{.User.first_name}} {{.User.nickname}} {{.User.created_by}} {{.User.firstName}}", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "Ada ada99 admin Ada" {
+		t.Errorf("expected %q, got %q", "Ada ada99 admin Ada", output)
+	}
+
+	_, err = execute("{{.User.Password}}", opts)
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) {
+		t.Errorf("expected hidden field to be undefined, got %v", err)
+	}
+
+	_, err = execute("{{.User.first_nmae}}", FieldOptions{Tags: []string{"json"}})
+	if !errors.As(err, &undefined) || undefined.Suggestion != "first_name" {
+		t.Errorf("expected suggestion first_name, got %v", err)
+	}
+}
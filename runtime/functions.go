@@ -1,9 +1,11 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
 	"html"
 	"net/url"
+	"reflect"
 	"strings"
 	"time"
 
@@ -14,15 +16,36 @@ import (
 // Function represents a template function.
 type Function func(args ...interface{}) (interface{}, error)
 
+// ContextFunction is a template function that also receives the
+// context.Context of the render, e.g. to honour cancellation or read
+// request-scoped values.
+type ContextFunction func(ctx context.Context, args ...interface{}) (interface{}, error)
+
+// PipeArg selects where a pipeline passes the piped value to a filter.
+type PipeArg int
+
+const (
+	// PipeFirst passes the piped value as the first argument, so
+	// {{.Body | truncate 100}} calls truncate(.Body, 100). This is the default.
+	PipeFirst PipeArg = iota
+	// PipeLast passes the piped value as the last argument, so
+	// {{.Created | date "YYYY-MM-DD"}} calls date("YYYY-MM-DD", .Created).
+	PipeLast
+)
+
 // FunctionRegistry manages available template functions.
 type FunctionRegistry struct {
-	funcs map[string]Function
+	funcs    map[string]Function
+	ctxFuncs map[string]ContextFunction
+	pipeArgs map[string]PipeArg
 }
 
 // NewFunctionRegistry creates a new function registry with built-in functions.
 func NewFunctionRegistry() *FunctionRegistry {
 	registry := &FunctionRegistry{
-		funcs: make(map[string]Function),
+		funcs:    make(map[string]Function),
+		ctxFuncs: make(map[string]ContextFunction),
+		pipeArgs: make(map[string]PipeArg),
 	}
 	registry.registerBuiltins()
 	return registry
@@ -30,9 +53,35 @@ func NewFunctionRegistry() *FunctionRegistry {
 
 // Register adds or replaces a function in the registry.
 func (r *FunctionRegistry) Register(name string, fn Function) {
+	delete(r.ctxFuncs, name)
 	r.funcs[name] = fn
 }
 
+// RegisterContext adds or replaces a context-aware function in the registry.
+func (r *FunctionRegistry) RegisterContext(name string, fn ContextFunction) {
+	delete(r.funcs, name)
+	r.ctxFuncs[name] = fn
+}
+
+// SetPipeArg sets where pipelines pass the piped value to the named function.
+func (r *FunctionRegistry) SetPipeArg(name string, pos PipeArg) {
+	if pos == PipeFirst {
+		delete(r.pipeArgs, name)
+		return
+	}
+	r.pipeArgs[name] = pos
+}
+
+// PipeArg returns where pipelines pass the piped value to the named function.
+func (r *FunctionRegistry) PipeArg(name string) PipeArg {
+	return r.pipeArgs[name]
+}
+
+// AllPipeArgs returns the functions whose piped value is not passed first.
+func (r *FunctionRegistry) AllPipeArgs() map[string]PipeArg {
+	return r.pipeArgs
+}
+
 // Get retrieves a function by name.
 func (r *FunctionRegistry) Get(name string) (Function, bool) {
 	fn, ok := r.funcs[name]
@@ -40,7 +89,16 @@ func (r *FunctionRegistry) Get(name string) (Function, bool) {
 }
 
 // Call executes a function by name with the given arguments.
+// Context-aware functions receive context.Background().
 func (r *FunctionRegistry) Call(name string, args ...interface{}) (interface{}, error) {
+	return r.CallContext(context.Background(), name, args...)
+}
+
+// CallContext executes a function by name, passing ctx to context-aware functions.
+func (r *FunctionRegistry) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	if fn, ok := r.ctxFuncs[name]; ok {
+		return fn(ctx, args...)
+	}
 	fn, ok := r.Get(name)
 	if !ok {
 		return nil, fmt.Errorf("unknown function: %s", name)
@@ -49,10 +107,16 @@ func (r *FunctionRegistry) Call(name string, args ...interface{}) (interface{},
 }
 
 // AllFunctions returns all registered functions.
+// Context-aware functions are returned by AllContextFunctions.
 func (r *FunctionRegistry) AllFunctions() map[string]Function {
 	return r.funcs
 }
 
+// AllContextFunctions returns all registered context-aware functions.
+func (r *FunctionRegistry) AllContextFunctions() map[string]ContextFunction {
+	return r.ctxFuncs
+}
+
 // registerBuiltins adds all built-in functions to the registry.
 func (r *FunctionRegistry) registerBuiltins() {
 	// String functions
@@ -78,41 +142,82 @@ func (r *FunctionRegistry) registerBuiltins() {
 	r.Register("urlEncode", fnURLEncode)
 	r.Register("htmlEscape", fnHTMLEscape)
 
+	// Trusted value functions
+	r.Register("safe", fnSafeHTML)
+	r.Register("raw", fnSafeHTML)
+	r.Register("safeHTML", fnSafeHTML)
+	r.Register("safeURL", fnSafeURL)
+	r.Register("safeJS", fnSafeJS)
+	r.Register("safeCSS", fnSafeCSS)
+	r.Register("safeAttr", fnSafeAttr)
+
 	// Date functions
 	r.Register("date", fnDate)
+	r.SetPipeArg("date", PipeLast)
 }
 
 // ============================================================================
 // String Functions
 // ============================================================================
 
+// toString returns the text of a value of any string kind, such as a
+// string or a SafeHTML returned by htmlEscape.
+func toString(val interface{}) (string, bool) {
+	if s, ok := val.(string); ok {
+		return s, true
+	}
+	if v := reflect.ValueOf(val); v.Kind() == reflect.String {
+		return v.String(), true
+	}
+	return "", false
+}
+
+// sameTrust returns s with the trusted type of val, so that functions which
+// only change case or trim, such as upper and trim, keep a SafeHTML from
+// htmlEscape trusted instead of having it escaped again.
+func sameTrust(val interface{}, s string) interface{} {
+	switch val.(type) {
+	case SafeHTML:
+		return SafeHTML(s)
+	case SafeURL:
+		return SafeURL(s)
+	case SafeJS:
+		return SafeJS(s)
+	case SafeCSS:
+		return SafeCSS(s)
+	case SafeAttr:
+		return SafeAttr(s)
+	}
+	return s
+}
+
 func fnUpper(args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("upper: expected 1 argument, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("upper: argument must be a string")
 	}
-	return strings.ToUpper(s), nil
+	return sameTrust(args[0], strings.ToUpper(s)), nil
 }
 
 func fnLower(args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("lower: expected 1 argument, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("lower: argument must be a string")
 	}
-	return strings.ToLower(s), nil
+	return sameTrust(args[0], strings.ToLower(s)), nil
 }
 
 func fnTitle(args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("title: expected 1 argument, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("title: argument must be a string")
 	}
@@ -124,48 +229,48 @@ func fnTrim(args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("trim: expected 1 argument, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("trim: argument must be a string")
 	}
-	return strings.TrimSpace(s), nil
+	return sameTrust(args[0], strings.TrimSpace(s)), nil
 }
 
 func fnTrimPrefix(args ...interface{}) (interface{}, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("trimPrefix: expected 2 arguments, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("trimPrefix: first argument must be a string")
 	}
-	prefix, ok := args[1].(string)
+	prefix, ok := toString(args[1])
 	if !ok {
 		return nil, fmt.Errorf("trimPrefix: second argument must be a string")
 	}
-	return strings.TrimPrefix(s, prefix), nil
+	return sameTrust(args[0], strings.TrimPrefix(s, prefix)), nil
 }
 
 func fnTrimSuffix(args ...interface{}) (interface{}, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("trimSuffix: expected 2 arguments, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("trimSuffix: first argument must be a string")
 	}
-	suffix, ok := args[1].(string)
+	suffix, ok := toString(args[1])
 	if !ok {
 		return nil, fmt.Errorf("trimSuffix: second argument must be a string")
 	}
-	return strings.TrimSuffix(s, suffix), nil
+	return sameTrust(args[0], strings.TrimSuffix(s, suffix)), nil
 }
 
 func fnTruncate(args ...interface{}) (interface{}, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("truncate: expected 2 arguments, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("truncate: first argument must be a string")
 	}
@@ -197,15 +302,15 @@ func fnReplace(args ...interface{}) (interface{}, error) {
 	if len(args) != 3 {
 		return nil, fmt.Errorf("replace: expected 3 arguments, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("replace: first argument must be a string")
 	}
-	old, ok := args[1].(string)
+	old, ok := toString(args[1])
 	if !ok {
 		return nil, fmt.Errorf("replace: second argument must be a string")
 	}
-	replacement, ok := args[2].(string)
+	replacement, ok := toString(args[2])
 	if !ok {
 		return nil, fmt.Errorf("replace: third argument must be a string")
 	}
@@ -226,7 +331,7 @@ func fnJoin(args ...interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("join: first argument must be an array or slice")
 	}
 
-	sep, ok := args[1].(string)
+	sep, ok := toString(args[1])
 	if !ok {
 		return nil, fmt.Errorf("join: second argument must be a string")
 	}
@@ -248,7 +353,7 @@ func fnLen(args ...interface{}) (interface{}, error) {
 		return len(arr), nil
 	}
 
-	if s, ok := args[0].(string); ok {
+	if s, ok := toString(args[0]); ok {
 		return len([]rune(s)), nil
 	}
 
@@ -345,7 +450,7 @@ func fnURLEncode(args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("urlEncode: expected 1 argument, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("urlEncode: argument must be a string")
 	}
@@ -356,11 +461,50 @@ func fnHTMLEscape(args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("htmlEscape: expected 1 argument, got %d", len(args))
 	}
-	s, ok := args[0].(string)
+	s, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("htmlEscape: argument must be a string")
 	}
-	return html.EscapeString(s), nil
+	return SafeHTML(html.EscapeString(s)), nil
+}
+
+// ============================================================================
+// Trusted Value Functions
+// ============================================================================
+
+func fnSafeHTML(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("safe: expected 1 argument, got %d", len(args))
+	}
+	return SafeHTML(fmt.Sprint(args[0])), nil
+}
+
+func fnSafeURL(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("safeURL: expected 1 argument, got %d", len(args))
+	}
+	return SafeURL(fmt.Sprint(args[0])), nil
+}
+
+func fnSafeJS(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("safeJS: expected 1 argument, got %d", len(args))
+	}
+	return SafeJS(fmt.Sprint(args[0])), nil
+}
+
+func fnSafeCSS(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("safeCSS: expected 1 argument, got %d", len(args))
+	}
+	return SafeCSS(fmt.Sprint(args[0])), nil
+}
+
+func fnSafeAttr(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("safeAttr: expected 1 argument, got %d", len(args))
+	}
+	return SafeAttr(fmt.Sprint(args[0])), nil
 }
 
 // ============================================================================
@@ -372,7 +516,7 @@ func fnDate(args ...interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("date: expected 2 arguments, got %d", len(args))
 	}
 
-	format, ok := args[0].(string)
+	format, ok := toString(args[0])
 	if !ok {
 		return nil, fmt.Errorf("date: first argument must be a format string")
 	}
@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -328,6 +329,35 @@ func TestFunction_HTMLEscape(t *testing.T) {
 	}
 }
 
+func TestFunction_StringKinds(t *testing.T) {
+	data := map[string]interface{}{"S": "a<b"}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{htmlEscape .S | upper}}`, "A&LT;B"},
+		{`{{len (htmlEscape .S)}}`, "6"},
+		{`{{truncate (htmlEscape .S) 2}}`, "a&..."},
+		{`{{replace (htmlEscape .S) "a" "b"}}`, "b&lt;b"},
+		{`{{trimPrefix (htmlEscape .S) (safe "a")}}`, "&lt;b"},
+		{`{{join ["x", "y"] (htmlEscape "&")}}`, "x&amp;y"},
+		{`{{urlEncode (htmlEscape .S)}}`, "a%26lt%3Bb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeTemplate(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
 // ============================================================================
 // Date Function Tests
 // ============================================================================
@@ -405,6 +435,88 @@ func TestFunction_PipeChain(t *testing.T) {
 	}
 }
 
+func TestFunction_PipeArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     interface{}
+		expected string
+	}{
+		{
+			name:     "truncate",
+			template: `{{.Body | truncate 5}}`,
+			data:     map[string]interface{}{"Body": "Hello, World"},
+			expected: "Hello...",
+		},
+		{
+			name:     "replace then upper",
+			template: `{{.Name | replace "a" "b" | upper}}`,
+			data:     map[string]interface{}{"Name": "banana"},
+			expected: "BBNBNB",
+		},
+		{
+			name:     "variable arguments",
+			template: `{{.Name | replace .Old .New}}`,
+			data:     map[string]interface{}{"Name": "cat", "Old": "c", "New": "b"},
+			expected: "bat",
+		},
+		{
+			name:     "default",
+			template: `{{.Missing | default "n/a"}}`,
+			data:     map[string]interface{}{},
+			expected: "n/a",
+		},
+		{
+			name:     "piped last to date",
+			template: `{{.Created | date "YYYY-MM-DD"}}`,
+			data:     map[string]interface{}{"Created": time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC)},
+			expected: "2024-12-25",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeTemplate(tt.template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestFunction_PipeArgLast(t *testing.T) {
+	ast, err := parser.New(lexer.New(`{{.Name | wrap "[" "]"}}`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rt := NewRuntime(NewContext(map[string]interface{}{"Name": "x"}))
+	rt.RegisterFunction("wrap", func(args ...interface{}) (interface{}, error) {
+		return fmt.Sprint(args...), nil
+	})
+	rt.SetPipeArg("wrap", PipeLast)
+	if err := rt.ExecuteTemplate(ast); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rt.Output() != "[]x" {
+		t.Errorf("expected piped value last, got %q", rt.Output())
+	}
+}
+
+func TestFunction_PipeStageError(t *testing.T) {
+	_, err := executeTemplate(`{{.Name | upper | truncate "x"}}`, map[string]interface{}{"Name": "abc"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := "pipeline stage 2 (truncate) error at 1:19"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
+
 // ============================================================================
 // Complex Integration Tests
 // ============================================================================
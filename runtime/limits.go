@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"errors"
+
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+// Limits bounds the resources a single render may use, so templates written
+// by untrusted authors cannot exhaust memory or CPU. Zero fields mean no limit.
+type Limits struct {
+	// MaxOutputBytes limits the size of the rendered output.
+	MaxOutputBytes int64
+	// MaxLoopIterations limits the total number of range iterations across the render.
+	MaxLoopIterations int64
+	// MaxNodes limits the number of AST nodes (statements and expressions) evaluated.
+	MaxNodes int64
+	// MaxIncludeDepth limits how deeply includes and layouts may be nested,
+	// replacing the SetMaxIncludeDepth value.
+	MaxIncludeDepth int
+}
+
+// Names of the limits reported in LimitError.Limit.
+const (
+	LimitOutputBytes    = "MaxOutputBytes"
+	LimitLoopIterations = "MaxLoopIterations"
+	LimitNodes          = "MaxNodes"
+	LimitIncludeDepth   = "MaxIncludeDepth"
+)
+
+// errOutputLimit is returned by outputWriter once MaxOutputBytes is reached.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// SetLimits sets the resource limits enforced during execution.
+func (r *Runtime) SetLimits(limits Limits) {
+	r.limits = limits
+	r.output.limit = limits.MaxOutputBytes
+}
+
+// countNode records the evaluation of node and enforces MaxNodes.
+func (r *Runtime) countNode(node parser.Node) error {
+	r.pos = node.Pos()
+	r.nodes++
+	if r.limits.MaxNodes > 0 && r.nodes > r.limits.MaxNodes {
+		return r.limitError(LimitNodes, r.limits.MaxNodes)
+	}
+	return nil
+}
+
+// countIteration records a range iteration and enforces MaxLoopIterations.
+func (r *Runtime) countIteration(pos parser.Position) error {
+	r.pos = pos
+	r.iterations++
+	if r.limits.MaxLoopIterations > 0 && r.iterations > r.limits.MaxLoopIterations {
+		return r.limitError(LimitLoopIterations, r.limits.MaxLoopIterations)
+	}
+	return nil
+}
+
+// write writes s to the output, reporting a *LimitError when MaxOutputBytes is reached.
+func (r *Runtime) write(s string) error {
+	err := r.output.WriteString(s)
+	if errors.Is(err, errOutputLimit) {
+		return r.limitError(LimitOutputBytes, r.limits.MaxOutputBytes)
+	}
+	return err
+}
+
+// limitError creates a *LimitError located at the node being executed.
+func (r *Runtime) limitError(limit string, max int64) *LimitError {
+	return &LimitError{
+		Limit:    limit,
+		Max:      max,
+		Template: r.templateName,
+		Line:     r.pos.Line,
+		Column:   r.pos.Column,
+	}
+}
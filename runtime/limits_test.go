@@ -0,0 +1,171 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+func TestRuntime_Limits(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		data      interface{}
+		limits    Limits
+		wantLimit string
+		wantLine  int
+	}{
+		{
+			name:     "within limits",
+			template: "{{range .}}{{.}}{{end}}",
+			data:     []int{1, 2, 3},
+			limits:   Limits{MaxOutputBytes: 3, MaxLoopIterations: 3, MaxNodes: 10},
+		},
+		{
+			name:      "loop iterations",
+			template:  "a\n{{range .}}{{.}}{{end}}",
+			data:      []int{1, 2, 3},
+			limits:    Limits{MaxLoopIterations: 2},
+			wantLimit: LimitLoopIterations,
+			wantLine:  2,
+		},
+		{
+			name:      "loop iterations across loops",
+			template:  "{{range .}}{{.}}{{end}}\n{{range .}}{{.}}{{end}}",
+			data:      map[string]int{"a": 1, "b": 2},
+			limits:    Limits{MaxLoopIterations: 3},
+			wantLimit: LimitLoopIterations,
+			wantLine:  2,
+		},
+		{
+			name:      "output bytes",
+			template:  "{{range .}}{{.}}{{end}}",
+			data:      []string{"ab", "cd"},
+			limits:    Limits{MaxOutputBytes: 3},
+			wantLimit: LimitOutputBytes,
+			wantLine:  1,
+		},
+		{
+			name:      "nodes",
+			template:  "{{range .}}{{. + 1}}{{end}}",
+			data:      []int{1, 2, 3},
+			limits:    Limits{MaxNodes: 8},
+			wantLimit: LimitNodes,
+			wantLine:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parser.New(lexer.New(tt.template)).Parse()
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			rt := NewRuntime(NewContext(tt.data))
+			rt.SetTemplateName("email")
+			rt.SetLimits(tt.limits)
+			err = rt.ExecuteTemplate(tmpl)
+
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected *LimitError, got %v", err)
+			}
+			if limitErr.Limit != tt.wantLimit {
+				t.Errorf("Limit = %q, want %q", limitErr.Limit, tt.wantLimit)
+			}
+			if limitErr.Template != "email" || limitErr.Line != tt.wantLine {
+				t.Errorf("location = %s:%d, want email:%d", limitErr.Template, limitErr.Line, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestRuntime_OutputLimitStreaming(t *testing.T) {
+	tmpl, err := parser.New(lexer.New("{{range .}}{{.}}{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	rt := NewRuntime(NewContext([]string{"ab", "cd", "ef"}))
+	rt.SetLimits(Limits{MaxOutputBytes: 4})
+	rt.SetOutput(&buf)
+
+	err = rt.ExecuteTemplate(tmpl)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitOutputBytes {
+		t.Fatalf("expected %s limit error, got %v", LimitOutputBytes, err)
+	}
+
+	// Output accepted before the limit is still flushed
+	if err := rt.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if buf.String() != "abcd" {
+		t.Errorf("expected %q, got %q", "abcd", buf.String())
+	}
+}
+
+func TestCompositionRuntime_NodeLimit(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("page", `{{include "part"}}{{block "main"}}{{end}}`)
+	loader.Add("part", "x")
+
+	tmpl, err := loader.Load("page")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	// include, the text of part and block are three nodes
+	rt := NewCompositionRuntime(NewContext(nil), loader)
+	rt.SetLimits(Limits{MaxNodes: 3})
+	if err := rt.ExecuteTemplate(tmpl); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rt = NewCompositionRuntime(NewContext(nil), loader)
+	rt.SetLimits(Limits{MaxNodes: 2})
+	err = rt.ExecuteTemplate(tmpl)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitNodes {
+		t.Fatalf("expected %s limit error, got %v", LimitNodes, err)
+	}
+	if limitErr.Column != 21 {
+		t.Errorf("expected the block at column 21, got %d", limitErr.Column)
+	}
+}
+
+func TestCompositionRuntime_IncludeDepthLimit(t *testing.T) {
+	loader := newMockLoader()
+	loader.Add("a", `{{include "b"}}`)
+	loader.Add("b", "\n{{include \"c\"}}")
+	loader.Add("c", "C")
+
+	tmpl, err := loader.Load("a")
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	rt := NewCompositionRuntime(NewContext(nil), loader)
+	rt.SetLimits(Limits{MaxIncludeDepth: 1})
+
+	err = rt.ExecuteTemplate(tmpl)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected *LimitError, got %v", err)
+	}
+	if limitErr.Limit != LimitIncludeDepth || limitErr.Template != "b" || limitErr.Line != 2 {
+		t.Errorf("got %s at %s:%d, want %s at b:2", limitErr.Limit, limitErr.Template, limitErr.Line, LimitIncludeDepth)
+	}
+}
@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// errorType is the reflect.Type of the error interface.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// errMethodsDisabled is returned for method calls when they are switched off.
+var errMethodsDisabled = errors.New("method calls are disabled")
+
+// methodValue finds the exported method name of obj. Methods with pointer
+// receivers are found on non-pointer values too, by calling them on a copy.
+func methodValue(obj interface{}, name string) (reflect.Value, bool) {
+	val := reflect.ValueOf(obj)
+	if !val.IsValid() {
+		return reflect.Value{}, false
+	}
+	if method := val.MethodByName(name); method.IsValid() {
+		return method, true
+	}
+	if val.Kind() == reflect.Ptr {
+		return reflect.Value{}, false
+	}
+
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	method := ptr.MethodByName(name)
+	return method, method.IsValid()
+}
+
+// callMethod calls the method name of obj with args. The second result
+// reports whether the method exists. Methods must return one value, or a
+// value and an error; failures are returned as a *MethodError.
+func callMethod(obj interface{}, name string, args []interface{}) (interface{}, bool, error) {
+	method, ok := methodValue(obj, name)
+	if !ok {
+		return nil, false, nil
+	}
+	fail := func(err error) (interface{}, bool, error) {
+		return nil, true, &MethodError{Method: name, Type: fmt.Sprintf("%T", obj), Err: err}
+	}
+
+	t := method.Type()
+	if t.NumOut() != 1 && (t.NumOut() != 2 || t.Out(1) != errorType) {
+		return fail(errors.New("method must return one value, or a value and an error"))
+	}
+	in, err := methodArgs(t, args)
+	if err != nil {
+		return fail(err)
+	}
+
+	out := method.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return fail(out[1].Interface().(error))
+	}
+	return out[0].Interface(), true, nil
+}
+
+// methodArgs converts template values to the parameter types of a method.
+func methodArgs(t reflect.Type, args []interface{}) ([]reflect.Value, error) {
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("takes at least %d arguments, got %d", numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("takes %d arguments, got %d", numIn, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			param = t.In(numIn - 1).Elem()
+		} else {
+			param = t.In(i)
+		}
+		v, err := convertArg(arg, param)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+// convertArg converts a template value to the type t. Values are passed
+// as is when assignable; numbers convert between numeric kinds when t
+// holds the value exactly, so 2.0 may be passed as an int but 3.7 may not.
+func convertArg(arg interface{}, t reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use nil as %s", t)
+	}
+
+	v := reflect.ValueOf(arg)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if isNumericKind(t.Kind()) {
+		if n, ok := toNumber(arg); ok {
+			if converted, ok := n.convert(t); ok {
+				return converted, nil
+			}
+			return reflect.Value{}, fmt.Errorf("%v does not fit in %s", arg, t)
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %T as %s", arg, t)
+}
+
+// isNumericKind reports whether k is an integer or floating-point kind.
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// methodNames lists the exported method names of obj, including those with
+// pointer receivers.
+func methodNames(obj interface{}) []string {
+	t := reflect.TypeOf(obj)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PointerTo(t)
+	}
+
+	names := make([]string, t.NumMethod())
+	for i := range names {
+		names[i] = t.Method(i).Name
+	}
+	sort.Strings(names)
+	return names
+}
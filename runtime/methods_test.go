@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+type methodUser struct {
+	First, Last string
+	Roles       []string
+}
+
+func (u methodUser) FullName() string { return u.First + " " + u.Last }
+
+func (u *methodUser) Initials() string { return u.First[:1] + u.Last[:1] }
+
+func (u methodUser) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (u methodUser) Greet(greeting string, times int) string {
+	return strings.Repeat(greeting+" ", times) + u.First
+}
+
+func (u methodUser) Join(sep string, parts ...string) string { return strings.Join(parts, sep) }
+
+func (u methodUser) Posts() ([]string, error) { return []string{"a", "b"}, nil }
+
+func (u methodUser) Balance() (int, error) { return 0, errors.New("account locked") }
+
+func (u methodUser) Reset() {}
+
+func (u methodUser) Byte(b uint8) uint8 { return b }
+
+func TestRuntime_MethodCalls(t *testing.T) {
+	user := methodUser{First: "Ada", Last: "Lovelace", Roles: []string{"admin"}}
+	data := map[string]interface{}{
+		"User":    user,
+		"UserPtr": &user,
+		"Date":    time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{.User.FullName}}", "Ada Lovelace"},
+		{"{{.UserPtr.FullName}}", "Ada Lovelace"},
+		{"{{.User.Initials}}", "AL"},
+		{"{{.UserPtr.Initials}}", "AL"},
+		{"{{.Date.Year}}", "2024"},
+		{"{{.Date.Month}}", "March"},
+		{`{{.User.HasRole "admin"}}`, "true"},
+		{`{{if .User.HasRole "editor"}}yes{{else}}no{{end}}`, "no"},
+		{`{{if !.UserPtr.HasRole "editor" && .User.HasRole "admin"}}admin{{end}}`, "admin"},
+		{`{{.User.Greet "hi" 2}}`, "hi hi Ada"},
+		{`{{.User.Join "-" "a" "b" "c"}}`, "a-b-c"},
+		{`{{.User.FullName | upper}}`, "ADA LOVELACE"},
+		{`{{range .User.Posts}}{{.}}{{end}}`, "ab"},
+		{`{{set $u = .User}}{{$u.HasRole "admin"}}`, "true"},
+		{`{{upper (.User.Greet "hey" 1)}}`, "HEY ADA"},
+		{`{{.User.Greet "hi" 2.0}}`, "hi hi Ada"},
+		{`{{.User.Byte 200}}`, "200"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_MethodErrors(t *testing.T) {
+	data := map[string]interface{}{"User": methodUser{First: "Ada", Last: "Lovelace"}}
+
+	tests := []struct {
+		template string
+		message  string
+	}{
+		{"Hi\n  {{.User.Balance}}", "account locked"},
+		{`{{.User.HasRole 1}}`, "argument 1: cannot use int as string"},
+		{`{{.User.HasRole "a" "b"}}`, "takes 1 arguments, got 2"},
+		{`{{.User.HasRole}}`, "takes 1 arguments, got 0"},
+		{`{{.User.Reset}}`, "must return one value"},
+		{`{{.User.Greet "hi" 3.7}}`, "argument 2: 3.7 does not fit in int"},
+		{`{{.User.Byte 300}}`, "argument 1: 300 does not fit in uint8"},
+		{`{{.User.Byte (-1)}}`, "argument 1: -1 does not fit in uint8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := executeStrict(tt.template, data)
+			var methodErr *MethodError
+			if !errors.As(err, &methodErr) {
+				t.Fatalf("expected *MethodError, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error containing %q, got %v", tt.message, err)
+			}
+			if methodErr.Template != "test" || methodErr.Line == 0 {
+				t.Errorf("expected template location, got %v", err)
+			}
+		})
+	}
+
+	_, err := executeStrict("Hi\n  {{.User.Balance}}", data)
+	var methodErr *MethodError
+	if errors.As(err, &methodErr) && (methodErr.Line != 2 || methodErr.Column != 5) {
+		t.Errorf("expected location 2:5, got %d:%d", methodErr.Line, methodErr.Column)
+	}
+
+	// Unknown methods are undefined, with a suggestion in strict mode.
+	_, err = executeStrict(`{{.User.HasRoel "admin"}}`, data)
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) || undefined.Suggestion != "HasRole" {
+		t.Errorf("expected undefined error suggesting HasRole, got %v", err)
+	}
+	output, err := executeTemplate(`[{{.Missing.HasRole "admin"}}]`, data)
+	if err != nil || output != "[]" {
+		t.Errorf("expected empty output in lenient mode, got %q, %v", output, err)
+	}
+	output, err = executeStrict(`[{{.Missing?.HasRole "admin"}}]`, map[string]interface{}{"Missing": nil})
+	if err != nil || output != "[]" {
+		t.Errorf("expected empty output with safe navigation, got %q, %v", output, err)
+	}
+}
+
+func TestRuntime_MethodCallsDisabled(t *testing.T) {
+	data := map[string]interface{}{"User": methodUser{First: "Ada", Last: "Lovelace"}}
+
+	execute := func(input string) (string, error) {
+		ast, err := parser.New(lexer.New(input)).Parse()
+		if err != nil {
+			return "", err
+		}
+		rt := NewRuntime(NewContext(data))
+		rt.SetStrictMode(true)
+		rt.SetMethodCalls(false)
+		if err := rt.ExecuteTemplate(ast); err != nil {
+			return "", err
+		}
+		return rt.Output(), nil
+	}
+
+	_, err := execute("{{.User.FullName}}")
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) {
+		t.Errorf("expected undefined error for method in path, got %v", err)
+	}
+
+	_, err = execute(`{{.User.HasRole "admin"}}`)
+	if !errors.Is(err, errMethodsDisabled) {
+		t.Errorf("expected method calls disabled error, got %v", err)
+	}
+
+	output, err := execute("{{.User.First}}")
+	if err != nil || output != "Ada" {
+		t.Errorf("expected fields to resolve, got %q, %v", output, err)
+	}
+}
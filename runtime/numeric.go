@@ -0,0 +1,458 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+)
+
+// numberKind classifies an arithmetic operand. Kinds are ordered so that
+// an operation on two operands is carried out in the greater kind.
+type numberKind int
+
+const (
+	numInt      numberKind = iota // int64, from any int or uint kind that fits
+	numBigInt                     // *big.Int, or a uint64 beyond math.MaxInt64
+	numFloat                      // float64, from float32 or float64
+	numBigFloat                   // *big.Float
+	numRat                        // *big.Rat
+)
+
+// number is an operand of an arithmetic operator.
+type number struct {
+	kind numberKind
+	i    int64
+	f    float64
+	bi   *big.Int
+	bf   *big.Float
+	r    *big.Rat
+}
+
+// operatorVerbs names arithmetic operators in error messages.
+var operatorVerbs = map[lexer.TokenType]string{
+	lexer.TokenPlus:  "add",
+	lexer.TokenMinus: "subtract",
+	lexer.TokenMult:  "multiply",
+	lexer.TokenDiv:   "divide",
+	lexer.TokenMod:   "mod",
+}
+
+// toNumber classifies val as a number. Values of any int, uint or float
+// kind, pointers to them, json.Number and *big.Int, *big.Float and *big.Rat
+// are numbers.
+func toNumber(val interface{}) (number, bool) {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return number{kind: numInt, i: i}, true
+		}
+		if bi, ok := new(big.Int).SetString(string(v), 10); ok {
+			return number{kind: numBigInt, bi: bi}, true
+		}
+		f, err := v.Float64()
+		return number{kind: numFloat, f: f}, err == nil
+	case *big.Int:
+		return number{kind: numBigInt, bi: v}, v != nil
+	case *big.Float:
+		return number{kind: numBigFloat, bf: v}, v != nil
+	case *big.Rat:
+		return number{kind: numRat, r: v}, v != nil
+	}
+
+	v := indirect(reflect.ValueOf(val))
+	switch {
+	case !v.IsValid():
+		return number{}, false
+	case isFloatKind(v.Kind()):
+		return number{kind: numFloat, f: v.Float()}, true
+	case isUintKind(v.Kind()):
+		if u := v.Uint(); u > math.MaxInt64 {
+			return number{kind: numBigInt, bi: new(big.Int).SetUint64(u)}, true
+		}
+		return number{kind: numInt, i: int64(v.Uint())}, true
+	case isNumericKind(v.Kind()):
+		return number{kind: numInt, i: v.Int()}, true
+	}
+	return number{}, false
+}
+
+// isExactNumber reports whether val is a json.Number or a math/big value,
+// which Equal and Compare order through toNumber.
+func isExactNumber(val interface{}) bool {
+	switch val.(type) {
+	case json.Number, *big.Int, *big.Float, *big.Rat:
+		return true
+	}
+	return false
+}
+
+// arithmetic applies an arithmetic operator to two numbers.
+//
+// Integers of any kind stay integers: division truncates towards zero and
+// a result that overflows int64 becomes a *big.Int instead of wrapping.
+// An operation involving a float is carried out in float64, or, with
+// decimal set, exactly on the shortest decimal form of each operand and
+// then rounded back to float64, so 0.1 + 0.2 is 0.3. Operands of the
+// math/big types give a result of the same type. % is only defined for
+// integers.
+func arithmetic(op lexer.TokenType, a, b interface{}, decimal bool) (interface{}, error) {
+	verb, ok := operatorVerbs[op]
+	if !ok {
+		return nil, fmt.Errorf("unsupported operator: %v", op)
+	}
+	x, xOk := toNumber(a)
+	y, yOk := toNumber(b)
+	if !xOk || !yOk {
+		return nil, fmt.Errorf("cannot %s %T and %T", verb, a, b)
+	}
+
+	kind := max(x.kind, y.kind)
+	if kind == numFloat && min(x.kind, y.kind) == numBigInt {
+		kind = numBigFloat
+	}
+	if op == lexer.TokenMod && kind > numBigInt {
+		return nil, fmt.Errorf("cannot %s %T and %T", verb, a, b)
+	}
+
+	switch kind {
+	case numInt:
+		return intArithmetic(op, x.i, y.i)
+	case numBigInt:
+		return bigIntArithmetic(op, x.bigInt(), y.bigInt())
+	case numFloat:
+		if decimal {
+			if xr, yr := x.decimalRat(), y.decimalRat(); xr != nil && yr != nil {
+				result, err := ratArithmetic(op, xr, yr)
+				if err != nil {
+					return nil, err
+				}
+				f, _ := result.(*big.Rat).Float64()
+				return f, nil
+			}
+		}
+		return floatArithmetic(op, x.float(), y.float())
+	case numBigFloat:
+		xf, yf := x.bigFloat(), y.bigFloat()
+		if xf == nil || yf == nil {
+			return nil, fmt.Errorf("cannot %s %v and %v", verb, a, b)
+		}
+		return bigFloatArithmetic(op, xf, yf)
+	default:
+		xr, yr := x.decimalRat(), y.decimalRat()
+		if xr == nil || yr == nil {
+			return nil, fmt.Errorf("cannot %s %v and %v", verb, a, b)
+		}
+		return ratArithmetic(op, xr, yr)
+	}
+}
+
+// negate returns -val for a number.
+func negate(val interface{}) (interface{}, error) {
+	n, ok := toNumber(val)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %T", val)
+	}
+	switch n.kind {
+	case numInt:
+		if n.i == math.MinInt64 {
+			return new(big.Int).Neg(big.NewInt(n.i)), nil
+		}
+		return intResult(-n.i), nil
+	case numBigInt:
+		return new(big.Int).Neg(n.bi), nil
+	case numFloat:
+		return -n.f, nil
+	case numBigFloat:
+		return new(big.Float).Neg(n.bf), nil
+	default:
+		return new(big.Rat).Neg(n.r), nil
+	}
+}
+
+// compareNumber orders two numbers exactly. NaN is not ordered.
+func compareNumber(x, y number) (int, bool) {
+	if x.isNaN() || y.isNaN() {
+		return 0, false
+	}
+	if xInf, yInf := x.infSign(), y.infSign(); xInf != 0 || yInf != 0 {
+		return compareOrdered(int64(xInf), int64(yInf)), true
+	}
+	return x.exactRat().Cmp(y.exactRat()), true
+}
+
+// intArithmetic applies op to two int64 values, switching to *big.Int
+// when the result overflows.
+func intArithmetic(op lexer.TokenType, x, y int64) (interface{}, error) {
+	var result int64
+	switch op {
+	case lexer.TokenPlus:
+		result = x + y
+		if (x^result)&(y^result) < 0 {
+			return bigIntArithmetic(op, big.NewInt(x), big.NewInt(y))
+		}
+	case lexer.TokenMinus:
+		result = x - y
+		if (x^y)&(x^result) < 0 {
+			return bigIntArithmetic(op, big.NewInt(x), big.NewInt(y))
+		}
+	case lexer.TokenMult:
+		result = x * y
+		if x != 0 && (result/x != y || (x == -1 && y == math.MinInt64)) {
+			return bigIntArithmetic(op, big.NewInt(x), big.NewInt(y))
+		}
+	case lexer.TokenDiv:
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if x == math.MinInt64 && y == -1 {
+			return bigIntArithmetic(op, big.NewInt(x), big.NewInt(y))
+		}
+		result = x / y
+	default:
+		if y == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		result = x % y
+	}
+	return intResult(result), nil
+}
+
+// intResult returns i as an int, or as a *big.Int where int is 32 bits
+// and i does not fit.
+func intResult(i int64) interface{} {
+	if int64(int(i)) != i {
+		return big.NewInt(i)
+	}
+	return int(i)
+}
+
+// bigIntArithmetic applies op to two *big.Int values, truncating division
+// towards zero like Go's / and %.
+func bigIntArithmetic(op lexer.TokenType, x, y *big.Int) (interface{}, error) {
+	switch op {
+	case lexer.TokenPlus:
+		return new(big.Int).Add(x, y), nil
+	case lexer.TokenMinus:
+		return new(big.Int).Sub(x, y), nil
+	case lexer.TokenMult:
+		return new(big.Int).Mul(x, y), nil
+	case lexer.TokenDiv:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return new(big.Int).Quo(x, y), nil
+	default:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		return new(big.Int).Rem(x, y), nil
+	}
+}
+
+// floatArithmetic applies op to two float64 values.
+func floatArithmetic(op lexer.TokenType, x, y float64) (interface{}, error) {
+	switch op {
+	case lexer.TokenPlus:
+		return x + y, nil
+	case lexer.TokenMinus:
+		return x - y, nil
+	case lexer.TokenMult:
+		return x * y, nil
+	default:
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	}
+}
+
+// bigFloatArithmetic applies op to two *big.Float values, at the greater
+// of their precisions. Operations without a defined result, like adding
+// infinities of opposite sign, fail with big.ErrNaN.
+func bigFloatArithmetic(op lexer.TokenType, x, y *big.Float) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			nan, ok := p.(big.ErrNaN)
+			if !ok {
+				panic(p)
+			}
+			result, err = nil, nan
+		}
+	}()
+
+	switch op {
+	case lexer.TokenPlus:
+		return new(big.Float).Add(x, y), nil
+	case lexer.TokenMinus:
+		return new(big.Float).Sub(x, y), nil
+	case lexer.TokenMult:
+		return new(big.Float).Mul(x, y), nil
+	default:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return new(big.Float).Quo(x, y), nil
+	}
+}
+
+// ratArithmetic applies op to two *big.Rat values.
+func ratArithmetic(op lexer.TokenType, x, y *big.Rat) (interface{}, error) {
+	switch op {
+	case lexer.TokenPlus:
+		return new(big.Rat).Add(x, y), nil
+	case lexer.TokenMinus:
+		return new(big.Rat).Sub(x, y), nil
+	case lexer.TokenMult:
+		return new(big.Rat).Mul(x, y), nil
+	default:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return new(big.Rat).Quo(x, y), nil
+	}
+}
+
+// convert returns n as a value of numeric type t, if t holds it exactly.
+func (n number) convert(t reflect.Type) (reflect.Value, bool) {
+	v := reflect.New(t).Elem()
+	if isFloatKind(t.Kind()) {
+		r := n.exactRat()
+		if r == nil {
+			if n.kind != numFloat {
+				return reflect.Value{}, false
+			}
+			v.SetFloat(n.f) // NaN or an infinity
+			return v, true
+		}
+		f, exact := r.Float64()
+		if !exact || v.OverflowFloat(f) {
+			return reflect.Value{}, false
+		}
+		v.SetFloat(f)
+		return v, true
+	}
+
+	r := n.exactRat()
+	if r == nil || !r.IsInt() {
+		return reflect.Value{}, false
+	}
+	i := r.Num()
+	switch {
+	case isUintKind(t.Kind()):
+		if !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+			return reflect.Value{}, false
+		}
+		v.SetUint(i.Uint64())
+	default:
+		if !i.IsInt64() || v.OverflowInt(i.Int64()) {
+			return reflect.Value{}, false
+		}
+		v.SetInt(i.Int64())
+	}
+	return v, true
+}
+
+// bigInt returns an integer number as a *big.Int.
+func (n number) bigInt() *big.Int {
+	if n.kind == numInt {
+		return big.NewInt(n.i)
+	}
+	return n.bi
+}
+
+// float returns an int or float number as a float64.
+func (n number) float() float64 {
+	if n.kind == numInt {
+		return float64(n.i)
+	}
+	return n.f
+}
+
+// bigFloat returns n as a *big.Float, or nil for NaN.
+func (n number) bigFloat() *big.Float {
+	switch n.kind {
+	case numInt:
+		return new(big.Float).SetInt64(n.i)
+	case numBigInt:
+		return new(big.Float).SetInt(n.bi)
+	case numFloat:
+		if math.IsNaN(n.f) {
+			return nil
+		}
+		return new(big.Float).SetFloat64(n.f)
+	default:
+		return n.bf
+	}
+}
+
+// decimalRat returns n as a *big.Rat, taking a float64 to be the shortest
+// decimal that formats as it, so 0.1 is exactly 1/10. It returns nil for
+// NaN and infinities.
+func (n number) decimalRat() *big.Rat {
+	if n.kind == numFloat {
+		r, ok := new(big.Rat).SetString(strconv.FormatFloat(n.f, 'g', -1, 64))
+		if !ok {
+			return nil
+		}
+		return r
+	}
+	return n.exactRat()
+}
+
+// exactRat returns the exact value of n as a *big.Rat, or nil for NaN and
+// infinities.
+func (n number) exactRat() *big.Rat {
+	switch n.kind {
+	case numInt:
+		return new(big.Rat).SetInt64(n.i)
+	case numBigInt:
+		return new(big.Rat).SetInt(n.bi)
+	case numFloat:
+		if math.IsNaN(n.f) || math.IsInf(n.f, 0) {
+			return nil
+		}
+		return new(big.Rat).SetFloat64(n.f)
+	case numBigFloat:
+		r, _ := n.bf.Rat(nil)
+		return r
+	default:
+		return n.r
+	}
+}
+
+// sign returns -1, 0 or +1 as n is negative, zero or positive.
+func (n number) sign() int {
+	switch n.kind {
+	case numInt:
+		return compareOrdered(n.i, 0)
+	case numBigInt:
+		return n.bi.Sign()
+	case numFloat:
+		return compareOrdered(n.f, 0)
+	case numBigFloat:
+		return n.bf.Sign()
+	default:
+		return n.r.Sign()
+	}
+}
+
+// isNaN reports whether n is a float64 NaN.
+func (n number) isNaN() bool {
+	return n.kind == numFloat && math.IsNaN(n.f)
+}
+
+// infSign returns +1 or -1 if n is an infinity and 0 otherwise.
+func (n number) infSign() int {
+	switch {
+	case n.kind == numFloat && math.IsInf(n.f, 0):
+		return int(math.Copysign(1, n.f))
+	case n.kind == numBigFloat && n.bf.IsInf():
+		return n.bf.Sign()
+	}
+	return 0
+}
@@ -0,0 +1,181 @@
+package runtime
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+func TestArithmetic(t *testing.T) {
+	i := 4
+	tests := []struct {
+		name string
+		op   lexer.TokenType
+		a, b interface{}
+		want string
+	}{
+		{"int8 and uint16", lexer.TokenPlus, int8(100), uint16(100), "200"},
+		{"uint8 does not wrap", lexer.TokenMult, uint8(200), uint8(2), "400"},
+		{"int32 and float32", lexer.TokenPlus, int32(1), float32(0.5), "1.5"},
+		{"pointer", lexer.TokenMult, &i, 2, "8"},
+		{"integer division", lexer.TokenDiv, 7, 2, "3"},
+		{"negative integer division", lexer.TokenDiv, -7, 2, "-3"},
+		{"float division", lexer.TokenDiv, 7.0, 2, "3.5"},
+		{"modulo", lexer.TokenMod, int64(-7), uint(3), "-1"},
+		{"json integer", lexer.TokenPlus, json.Number("40"), 2, "42"},
+		{"json float", lexer.TokenMult, json.Number("1.5"), 2, "3"},
+		{"add overflow", lexer.TokenPlus, int64(math.MaxInt64), 1, "9223372036854775808"},
+		{"subtract overflow", lexer.TokenMinus, int64(math.MinInt64), 1, "-9223372036854775809"},
+		{"multiply overflow", lexer.TokenMult, int64(math.MaxInt64), 2, "18446744073709551614"},
+		{"divide overflow", lexer.TokenDiv, int64(math.MinInt64), -1, "9223372036854775808"},
+		{"large uint64", lexer.TokenMinus, uint64(math.MaxUint64), 1, "18446744073709551614"},
+		{"json big integer", lexer.TokenPlus, json.Number("18446744073709551616"), 1, "18446744073709551617"},
+		{"big.Int", lexer.TokenMult, big.NewInt(1 << 40), big.NewInt(1 << 40), "1208925819614629174706176"},
+		{"big.Int and float", lexer.TokenDiv, big.NewInt(3), 2.0, "1.5"},
+		{"big.Float", lexer.TokenPlus, big.NewFloat(1.25), 1, "2.25"},
+		{"big.Rat", lexer.TokenDiv, big.NewRat(1, 3), 2, "1/6"},
+		{"big.Rat and float", lexer.TokenPlus, big.NewRat(1, 2), 0.1, "3/5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := arithmetic(tt.op, tt.a, tt.b, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s := rendered(got); s != tt.want {
+				t.Errorf("got %s (%T), want %s", s, got, tt.want)
+			}
+		})
+	}
+
+	if got, _ := arithmetic(lexer.TokenPlus, 1, 2, false); got != 3 {
+		t.Errorf("expected int result, got %T", got)
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		op      lexer.TokenType
+		a, b    interface{}
+		message string
+	}{
+		{lexer.TokenDiv, 1, 0, "division by zero"},
+		{lexer.TokenDiv, 1.5, 0.0, "division by zero"},
+		{lexer.TokenDiv, big.NewInt(1), uint8(0), "division by zero"},
+		{lexer.TokenDiv, big.NewRat(1, 2), 0, "division by zero"},
+		{lexer.TokenMod, 1, 0, "modulo by zero"},
+		{lexer.TokenMod, 1.5, 1, "cannot mod float64 and int"},
+		{lexer.TokenPlus, "a", 1, "cannot add string and int"},
+		{lexer.TokenMinus, json.Number("x"), 1, "cannot subtract json.Number and int"},
+		{lexer.TokenPlus, big.NewFloat(math.Inf(1)), math.Inf(-1), "infinities"},
+	}
+
+	for _, tt := range tests {
+		_, err := arithmetic(tt.op, tt.a, tt.b, false)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%v %v %v: expected error containing %q, got %v", tt.a, tt.op, tt.b, tt.message, err)
+		}
+	}
+}
+
+func TestNegate(t *testing.T) {
+	tests := []struct {
+		val  interface{}
+		want string
+	}{
+		{uint8(5), "-5"},
+		{int64(math.MinInt64), "9223372036854775808"},
+		{float32(1.5), "-1.5"},
+		{big.NewInt(2), "-2"},
+		{big.NewRat(1, 2), "-1/2"},
+	}
+
+	for _, tt := range tests {
+		got, err := negate(tt.val)
+		if err != nil || rendered(got) != tt.want {
+			t.Errorf("negate(%v) = %v, %v, want %s", tt.val, got, err, tt.want)
+		}
+	}
+}
+
+func TestExactNumberComparisons(t *testing.T) {
+	if !Equal(json.Number("3"), 3.0) || !Equal(big.NewInt(3), uint8(3)) || !Equal(big.NewRat(1, 2), 0.5) {
+		t.Error("expected exact numbers to equal other numbers by value")
+	}
+	if !Equal(json.Number("3"), "3") {
+		t.Error("expected json.Number to equal a string with the same text")
+	}
+	huge, _ := new(big.Int).SetString("100000000000000000000", 10)
+	if order, ok := Compare(huge, math.MaxInt64); order != 1 || !ok {
+		t.Errorf("Compare(huge, MaxInt64) = %d, %v", order, ok)
+	}
+	if order, ok := Compare(big.NewFloat(1), math.Inf(1)); order != -1 || !ok {
+		t.Errorf("Compare(1, +Inf) = %d, %v", order, ok)
+	}
+	if _, ok := Compare(big.NewInt(1), math.NaN()); ok {
+		t.Error("expected NaN to be unordered")
+	}
+	if IsTruthy(big.NewInt(0)) || IsTruthy(json.Number("0")) || !IsTruthy(big.NewRat(1, 3)) {
+		t.Error("expected exact numbers to be truthy unless zero")
+	}
+}
+
+func TestRuntime_DecimalArithmetic(t *testing.T) {
+	data := map[string]interface{}{
+		"Price":    9.95,
+		"Qty":      3,
+		"Shipping": 0.15,
+		"Rate":     json.Number("0.1"),
+		"Total":    big.NewRat(1, 3),
+	}
+
+	execute := func(input string, decimal bool) (string, error) {
+		ast, err := parser.New(lexer.New(input)).Parse()
+		if err != nil {
+			return "", err
+		}
+		rt := NewRuntime(NewContext(data))
+		rt.SetDecimalArithmetic(decimal)
+		if err := rt.ExecuteTemplate(ast); err != nil {
+			return "", err
+		}
+		return rt.Output(), nil
+	}
+
+	tests := []struct {
+		template string
+		float    string
+		decimal  string
+	}{
+		{"{{0.1 + 0.2}}", "0.30000000000000004", "0.3"},
+		{"{{.Price * .Qty + .Shipping}}", "29.999999999999996", "30"},
+		{"{{.Rate * 3}}", "0.30000000000000004", "0.3"},
+		{"{{1.0 / 3}}", "0.3333333333333333", "0.3333333333333333"},
+		{"{{7 / 2}}", "3", "3"},
+		{"{{set $t = .Total * 3}}{{$t.FloatString 2}}", "1.00", "1.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if output, err := execute(tt.template, false); err != nil || output != tt.float {
+				t.Errorf("float: got %q, %v, want %q", output, err, tt.float)
+			}
+			if output, err := execute(tt.template, true); err != nil || output != tt.decimal {
+				t.Errorf("decimal: got %q, %v, want %q", output, err, tt.decimal)
+			}
+		})
+	}
+}
+
+// rendered formats an arithmetic result the way it renders.
+func rendered(val interface{}) string {
+	ast, _ := parser.New(lexer.New("{{.}}")).Parse()
+	output, _ := Execute(ast, NewContext(val))
+	return output
+}
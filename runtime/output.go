@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// outputBufferSize is the buffer size used when streaming output to a writer.
+const outputBufferSize = 4096
+
+// outputWriter collects rendered output, either in memory or streamed
+// through a small buffer to an io.Writer. The first write error is kept
+// and returned from every later write so execution can stop early.
+type outputWriter struct {
+	memory  *bytes.Buffer // Destination when rendering to memory
+	stream  *bufio.Writer // Buffered destination when streaming, nil otherwise
+	written int64         // Bytes that reached the streaming destination
+	size    int64         // Bytes accepted so far, buffered or not
+	limit   int64         // Maximum size in bytes, 0 for no limit
+	err     error         // First write error
+}
+
+// newMemoryOutput creates an output that renders into memory.
+func newMemoryOutput() *outputWriter {
+	return &outputWriter{memory: &bytes.Buffer{}}
+}
+
+// newStreamOutput creates an output that streams to w.
+func newStreamOutput(w io.Writer) *outputWriter {
+	o := &outputWriter{}
+	o.stream = bufio.NewWriterSize(&countingWriter{w: w, n: &o.written}, outputBufferSize)
+	return o
+}
+
+// WriteString writes s to the output.
+func (o *outputWriter) WriteString(s string) error {
+	if o.err != nil {
+		return o.err
+	}
+	if o.limit > 0 && o.size+int64(len(s)) > o.limit {
+		o.err = errOutputLimit
+		return o.err
+	}
+	o.size += int64(len(s))
+	if o.stream != nil {
+		_, o.err = o.stream.WriteString(s)
+		return o.err
+	}
+	o.memory.WriteString(s)
+	return nil
+}
+
+// Flush writes any buffered output to the destination writer. Output
+// accepted before the size limit was reached is still written.
+func (o *outputWriter) Flush() error {
+	if o.stream == nil {
+		return nil
+	}
+	if o.err != nil && o.err != errOutputLimit {
+		return o.err
+	}
+	if err := o.stream.Flush(); err != nil {
+		o.err = err
+		return err
+	}
+	return nil
+}
+
+// BytesWritten returns the number of bytes rendered so far. When streaming,
+// only bytes that reached the destination writer are counted.
+func (o *outputWriter) BytesWritten() int64 {
+	if o.stream != nil {
+		return o.written
+	}
+	return int64(o.memory.Len())
+}
+
+// String returns the output rendered into memory, or "" when streaming.
+func (o *outputWriter) String() string {
+	if o.memory == nil {
+		return ""
+	}
+	return o.memory.String()
+}
+
+// countingWriter counts the bytes successfully written to w.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
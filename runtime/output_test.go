@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+// failingWriter accepts limit bytes and then fails every write.
+type failingWriter struct {
+	limit  int
+	writes int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errWriteFailed
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestRuntime_StreamOutput(t *testing.T) {
+	ast, err := parser.New(lexer.New("{{range .}}{{.}},{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	rt := NewRuntime(NewContext([]int{1, 2, 3}))
+	rt.SetOutput(&buf)
+
+	if err := rt.ExecuteTemplate(ast); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Nothing reaches the writer until the buffer fills or is flushed
+	if buf.Len() != 0 {
+		t.Errorf("expected output to be buffered, got %q", buf.String())
+	}
+
+	if err := rt.Flush(); err != nil {
+		t.Fatalf("unexpected flush error: %v", err)
+	}
+	if buf.String() != "1,2,3," {
+		t.Errorf("expected %q, got %q", "1,2,3,", buf.String())
+	}
+	if rt.BytesWritten() != 6 {
+		t.Errorf("expected 6 bytes written, got %d", rt.BytesWritten())
+	}
+	if rt.Output() != "" {
+		t.Errorf("expected empty Output() when streaming, got %q", rt.Output())
+	}
+}
+
+func TestRuntime_StreamWriteErrorStopsExecution(t *testing.T) {
+	ast, err := parser.New(lexer.New("{{range .}}{{count}}{{.}}{{end}}")).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	items := make([]string, 10000)
+	for i := range items {
+		items[i] = strings.Repeat("x", 100)
+	}
+
+	calls := 0
+	w := &failingWriter{limit: outputBufferSize * 2}
+	rt := NewRuntime(NewContext(items))
+	rt.SetOutput(w)
+	rt.RegisterFunction("count", func(args ...interface{}) (interface{}, error) {
+		calls++
+		return "", nil
+	})
+
+	err = rt.ExecuteTemplate(ast)
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("expected write error, got %v", err)
+	}
+	if calls >= len(items) {
+		t.Errorf("expected execution to stop early, ran %d iterations", calls)
+	}
+	if rt.BytesWritten() != int64(outputBufferSize*2) {
+		t.Errorf("expected %d bytes written, got %d", outputBufferSize*2, rt.BytesWritten())
+	}
+}
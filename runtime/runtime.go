@@ -1,8 +1,11 @@
 package runtime
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
 
 	"github.com/toutaio/toutago-fith-renderer/lexer"
 	"github.com/toutaio/toutago-fith-renderer/parser"
@@ -11,17 +14,56 @@ import (
 // Runtime executes parsed templates with a given context.
 type Runtime struct {
 	context   *Context
-	output    *bytes.Buffer
+	output    *outputWriter
 	functions *FunctionRegistry
+	// dispatch executes nested nodes (if/range bodies). It defaults to
+	// executeNode and is replaced by CompositionRuntime so that includes
+	// and blocks inside control structures are handled too.
+	dispatch func(parser.Node) error
+	// escaper tracks the HTML context of the output when auto-escaping is enabled.
+	escaper *escaper
+	// strict makes undefined variables and missing fields an error.
+	strict bool
+	// strictComparisons makes comparing incomparable operands an error.
+	strictComparisons bool
+	// decimal computes arithmetic on floats exactly in decimal.
+	decimal bool
+	// methods allows templates to call methods of data values.
+	methods bool
+	// fields controls how path components name struct fields.
+	fields FieldOptions
+	// templateName is the slug of the template being executed, for error reporting.
+	templateName string
+	// cancelCtx cancels execution and is passed to context-aware functions.
+	cancelCtx context.Context
+	// limits bounds output size, loop iterations and nodes evaluated.
+	limits     Limits
+	iterations int64
+	nodes      int64
+	// pos is the position of the node being executed, for limit errors.
+	pos parser.Position
+	// loops holds the metadata of the enclosing range iterations, innermost last.
+	loops []map[string]interface{}
 }
 
+// errBreak and errContinue unwind execution from {{break}} and {{continue}}
+// to the innermost range loop.
+var (
+	errBreak    = errors.New("break outside range")
+	errContinue = errors.New("continue outside range")
+)
+
 // NewRuntime creates a new runtime with the given context.
 func NewRuntime(ctx *Context) *Runtime {
-	return &Runtime{
+	r := &Runtime{
 		context:   ctx,
-		output:    &bytes.Buffer{},
+		output:    newMemoryOutput(),
 		functions: NewFunctionRegistry(),
+		cancelCtx: context.Background(),
+		methods:   true,
 	}
+	r.dispatch = r.executeNode
+	return r
 }
 
 // RegisterFunction adds a custom function to the runtime.
@@ -29,6 +71,74 @@ func (r *Runtime) RegisterFunction(name string, fn Function) {
 	r.functions.Register(name, fn)
 }
 
+// RegisterContextFunction adds a custom function that receives the render's context.Context.
+func (r *Runtime) RegisterContextFunction(name string, fn ContextFunction) {
+	r.functions.RegisterContext(name, fn)
+}
+
+// SetPipeArg sets where pipelines pass the piped value to the named function.
+func (r *Runtime) SetPipeArg(name string, pos PipeArg) {
+	r.functions.SetPipeArg(name, pos)
+}
+
+// SetCancelContext sets the context.Context that cancels execution.
+// Loops and includes stop with a *CancelledError once ctx is done.
+// The context is also passed to functions registered with RegisterContextFunction.
+func (r *Runtime) SetCancelContext(ctx context.Context) {
+	r.cancelCtx = ctx
+}
+
+// SetAutoEscape enables or disables contextual auto-escaping.
+// When enabled, every value written to the output is escaped for the
+// position it lands in: HTML text, attribute value, URL, script or style.
+func (r *Runtime) SetAutoEscape(enabled bool) {
+	if enabled {
+		r.escaper = newEscaper()
+	} else {
+		r.escaper = nil
+	}
+}
+
+// SetStrictMode controls how undefined variables and missing fields are handled.
+// In strict mode they fail with an *UndefinedError; otherwise they render as empty.
+func (r *Runtime) SetStrictMode(strict bool) {
+	r.strict = strict
+}
+
+// SetStrictComparisons controls how operands that cannot be compared,
+// like a string and a number, are handled. By default they are unequal and
+// every ordering comparison is false; in strict mode comparing them fails.
+func (r *Runtime) SetStrictComparisons(strict bool) {
+	r.strictComparisons = strict
+}
+
+// SetDecimalArithmetic controls how arithmetic involving floats is
+// computed. When enabled, operands are taken as the shortest decimals
+// that format as them and the result is computed exactly before being
+// rounded back to float64, so 0.1 + 0.2 renders as 0.3 and sums of prices
+// do not drift.
+func (r *Runtime) SetDecimalArithmetic(enabled bool) {
+	r.decimal = enabled
+}
+
+// SetMethodCalls controls whether templates may call methods of data
+// values, like .User.FullName or .User.HasRole "admin". It is enabled by
+// default; disable it to sandbox untrusted templates.
+func (r *Runtime) SetMethodCalls(enabled bool) {
+	r.methods = enabled
+}
+
+// SetFieldOptions controls how path components name struct fields: by
+// struct tag and, optionally, ignoring case.
+func (r *Runtime) SetFieldOptions(opts FieldOptions) {
+	r.fields = opts
+}
+
+// SetTemplateName sets the slug of the template being executed, used in error messages.
+func (r *Runtime) SetTemplateName(name string) {
+	r.templateName = name
+}
+
 // GetContext returns the runtime's context.
 func (r *Runtime) GetContext() *Context {
 	return r.context
@@ -36,10 +146,33 @@ func (r *Runtime) GetContext() *Context {
 
 // ExecuteTemplate executes a template and stores the output.
 func (r *Runtime) ExecuteTemplate(template *parser.Template) error {
+	if err := r.checkCancelled(template.Pos()); err != nil {
+		return err
+	}
 	return r.executeTemplate(template)
 }
 
+// SetOutput streams rendered output to w through a small buffer instead of
+// collecting it in memory. Call Flush after execution to write the remainder.
+// A write error stops execution and is returned from ExecuteTemplate.
+func (r *Runtime) SetOutput(w io.Writer) {
+	r.output = newStreamOutput(w)
+	r.output.limit = r.limits.MaxOutputBytes
+}
+
+// Flush writes any buffered output to the writer set with SetOutput.
+func (r *Runtime) Flush() error {
+	return r.output.Flush()
+}
+
+// BytesWritten returns the number of bytes of output produced so far.
+// When streaming, only bytes that reached the writer are counted.
+func (r *Runtime) BytesWritten() int64 {
+	return r.output.BytesWritten()
+}
+
 // Output returns the rendered output.
+// It is empty when output is streamed with SetOutput.
 func (r *Runtime) Output() string {
 	return r.output.String()
 }
@@ -57,7 +190,7 @@ func Execute(template *parser.Template, ctx *Context) (string, error) {
 // executeTemplate executes the template root node.
 func (r *Runtime) executeTemplate(template *parser.Template) error {
 	for _, node := range template.Nodes {
-		if err := r.executeNode(node); err != nil {
+		if err := r.dispatch(node); err != nil {
 			return err
 		}
 	}
@@ -66,47 +199,93 @@ func (r *Runtime) executeTemplate(template *parser.Template) error {
 
 // executeNode executes a single AST node.
 func (r *Runtime) executeNode(node parser.Node) error {
+	if err := r.countNode(node); err != nil {
+		return err
+	}
+
 	switch n := node.(type) {
 	case *parser.TextNode:
 		return r.executeText(n)
+	case *parser.CommentNode:
+		return nil // Comments produce no output
 	case *parser.VariableNode:
 		return r.executeVariable(n)
 	case *parser.IfNode:
 		return r.executeIf(n)
 	case *parser.RangeNode:
 		return r.executeRange(n)
+	case *parser.WithNode:
+		return r.executeWith(n)
+	case *parser.SetNode:
+		return r.executeSet(n)
+	case *parser.BreakNode:
+		return errBreak
+	case *parser.ContinueNode:
+		return errContinue
 	case *parser.BinaryOpNode:
 		val, err := r.evaluateBinaryOp(n)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprint(r.output, val)
-		return nil
+		return r.writeValue(val)
+	case *parser.TernaryNode:
+		val, err := r.evaluateTernary(n)
+		if err != nil {
+			return err
+		}
+		return r.writeValue(val)
 	case *parser.UnaryOpNode:
 		val, err := r.evaluateUnaryOp(n)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprint(r.output, val)
-		return nil
+		return r.writeValue(val)
 	case *parser.LiteralNode:
 		val, err := r.evaluateLiteral(n)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprint(r.output, val)
-		return nil
+		return r.writeValue(val)
 	case *parser.CallNode:
 		return r.executeCall(n)
 	case *parser.PipeNode:
 		return r.executePipe(n)
+	case *parser.MethodNode:
+		val, err := r.evaluateMethod(n)
+		if err != nil {
+			return err
+		}
+		return r.writeValue(val)
 	case *parser.IndexNode:
 		val, err := r.evaluateIndex(n)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprint(r.output, val)
-		return nil
+		return r.writeValue(val)
+	case *parser.SliceNode:
+		val, err := r.evaluateSlice(n)
+		if err != nil {
+			return err
+		}
+		return r.writeValue(val)
+	case *parser.FieldNode:
+		val, err := r.evaluateField(n)
+		if err != nil {
+			return err
+		}
+		return r.writeValue(val)
+	case *parser.ListNode:
+		val, err := r.evaluateList(n)
+		if err != nil {
+			return err
+		}
+		return r.writeValue(val)
+	case *parser.MapNode:
+		val, err := r.evaluateMap(n)
+		if err != nil {
+			return err
+		}
+		return r.writeValue(val)
 	default:
 		return fmt.Errorf("unsupported node type: %T", node)
 	}
@@ -114,20 +293,33 @@ func (r *Runtime) executeNode(node parser.Node) error {
 
 // executeText executes a text node by writing it to output.
 func (r *Runtime) executeText(node *parser.TextNode) error {
-	r.output.WriteString(node.Value)
-	return nil
+	if r.escaper != nil {
+		r.escaper.feed(node.Value)
+	}
+	return r.write(node.Value)
+}
+
+// writeValue writes an evaluated value to the output, escaping it for the
+// current context when auto-escaping is enabled.
+func (r *Runtime) writeValue(val interface{}) error {
+	if val == nil {
+		return nil // nil renders as empty
+	}
+	if r.escaper != nil {
+		return r.write(r.escaper.escape(val))
+	}
+	return r.write(fmt.Sprint(val))
 }
 
 // executeVariable executes a variable node.
 func (r *Runtime) executeVariable(node *parser.VariableNode) error {
-	val, err := r.context.Get(node.Path)
+	val, err := r.lookupVariable(node)
 	if err != nil {
-		return fmt.Errorf("variable error at %d:%d: %v", node.Position.Line, node.Position.Column, err)
+		return err
 	}
 
 	// Convert value to string and write to output
-	_, _ = fmt.Fprint(r.output, val)
-	return nil
+	return r.writeValue(val)
 }
 
 // executeIf executes an if/else statement.
@@ -135,26 +327,36 @@ func (r *Runtime) executeIf(node *parser.IfNode) error {
 	// Evaluate condition
 	condVal, err := r.evaluateExpression(node.Condition)
 	if err != nil {
-		return fmt.Errorf("if condition error at %d:%d: %v", node.Position.Line, node.Position.Column, err)
+		return fmt.Errorf("if condition error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
 	}
 
 	// Check if condition is truthy
 	if IsTruthy(condVal) {
-		// Execute then branch
-		for _, n := range node.Then {
-			if err := r.executeNode(n); err != nil {
-				return err
-			}
+		return r.executeNodes(node.Then)
+	}
+
+	// Try else if branches in order
+	for _, clause := range node.ElseIfs {
+		val, err := r.evaluateExpression(clause.Condition)
+		if err != nil {
+			return fmt.Errorf("else if condition error at %d:%d: %w", clause.Position.Line, clause.Position.Column, err)
 		}
-	} else if node.Else != nil {
-		// Execute else branch
-		for _, n := range node.Else {
-			if err := r.executeNode(n); err != nil {
-				return err
-			}
+		if IsTruthy(val) {
+			return r.executeNodes(clause.Body)
 		}
 	}
 
+	// Execute else branch
+	return r.executeNodes(node.Else)
+}
+
+// executeNodes executes a list of nodes in order.
+func (r *Runtime) executeNodes(nodes []parser.Node) error {
+	for _, n := range nodes {
+		if err := r.dispatch(n); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -163,16 +365,27 @@ func (r *Runtime) executeRange(node *parser.RangeNode) error {
 	// Evaluate collection
 	collVal, err := r.evaluateExpression(node.Collection)
 	if err != nil {
-		return fmt.Errorf("range collection error at %d:%d: %v", node.Position.Line, node.Position.Column, err)
+		return fmt.Errorf("range collection error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
+	}
+
+	// Nil collections (e.g. undefined in lenient mode) are empty
+	if isNil(collVal) {
+		return r.executeNodes(node.Else)
 	}
 
 	// Try to convert to slice
 	if items, ok := ToSlice(collVal); ok {
+		if len(items) == 0 {
+			return r.executeNodes(node.Else)
+		}
 		return r.executeRangeSlice(node, items)
 	}
 
 	// Try to convert to map
 	if keys, vals, ok := ToMap(collVal); ok {
+		if len(keys) == 0 {
+			return r.executeNodes(node.Else)
+		}
 		return r.executeRangeMap(node, keys, vals)
 	}
 
@@ -182,25 +395,10 @@ func (r *Runtime) executeRange(node *parser.RangeNode) error {
 // executeRangeSlice executes a range loop over a slice.
 func (r *Runtime) executeRangeSlice(node *parser.RangeNode, items []interface{}) error {
 	for idx, item := range items {
-		// Push new scope
-		r.context.PushScope()
-
-		// Set loop variables
-		r.context.Set(".", item) // Current item
-		r.context.Set("@index", idx)
-		r.context.Set("@first", idx == 0)
-		r.context.Set("@last", idx == len(items)-1)
-
-		// Execute loop body
-		for _, n := range node.Body {
-			if err := r.executeNode(n); err != nil {
-				r.context.PopScope()
-				return err
-			}
+		loop := r.loopMeta(idx, len(items))
+		if done, err := r.executeIteration(node, loop, idx, item); done || err != nil {
+			return err
 		}
-
-		// Pop scope
-		r.context.PopScope()
 	}
 
 	return nil
@@ -212,28 +410,113 @@ func (r *Runtime) executeRangeMap(
 	keys, vals []interface{},
 ) error {
 	for idx, key := range keys {
-		// Push new scope
-		r.context.PushScope()
-
-		// Set loop variables
-		r.context.Set(".", vals[idx]) // Current value
-		r.context.Set("@key", key)
-		r.context.Set("@index", idx)
-		r.context.Set("@first", idx == 0)
-		r.context.Set("@last", idx == len(keys)-1)
-
-		// Execute loop body
-		for _, n := range node.Body {
-			if err := r.executeNode(n); err != nil {
-				r.context.PopScope()
-				return err
-			}
+		loop := r.loopMeta(idx, len(keys))
+		loop["key"] = key
+		if done, err := r.executeIteration(node, loop, key, vals[idx]); done || err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		// Pop scope
-		r.context.PopScope()
+// loopMeta returns the metadata of iteration idx of a loop over length
+// items, bound as @index, @first and so on. @parent is the metadata of the
+// enclosing loop, or nil at the outermost loop.
+func (r *Runtime) loopMeta(idx, length int) map[string]interface{} {
+	var parent interface{}
+	if len(r.loops) > 0 {
+		parent = r.loops[len(r.loops)-1]
+	}
+	return map[string]interface{}{
+		"index":    idx,
+		"first":    idx == 0,
+		"last":     idx == length-1,
+		"length":   length,
+		"revindex": length - 1 - idx,
+		"even":     idx%2 == 0,
+		"odd":      idx%2 == 1,
+		"depth":    len(r.loops) + 1,
+		"parent":   parent,
+	}
+}
+
+// executeIteration runs the body of a range loop once, in a new scope with
+// . bound to val and the loop metadata bound as @ variables. It reports
+// whether the body ended the loop with {{break}}.
+func (r *Runtime) executeIteration(
+	node *parser.RangeNode,
+	loop map[string]interface{},
+	key, val interface{},
+) (bool, error) {
+	if err := r.checkCancelled(node.Position); err != nil {
+		return false, err
+	}
+	if err := r.countIteration(node.Position); err != nil {
+		return false, err
+	}
+
+	// Push new scope
+	r.context.PushScope()
+	defer r.context.PopScope()
+	r.loops = append(r.loops, loop)
+	defer func() { r.loops = r.loops[:len(r.loops)-1] }()
+
+	// Set loop variables
+	r.context.Set(".", val) // Current item
+	r.setLoopVars(node, key, val)
+	for name, value := range loop {
+		r.context.Set("@"+name, value)
+	}
+
+	// Execute loop body
+	err := r.executeNodes(node.Body)
+	switch {
+	case errors.Is(err, errBreak):
+		return true, nil
+	case errors.Is(err, errContinue):
+		return false, nil
+	}
+	return false, err
+}
+
+// setLoopVars binds the named variables of a range loop ($k, $v := ...)
+// in the current scope.
+func (r *Runtime) setLoopVars(node *parser.RangeNode, key, val interface{}) {
+	if node.Variable != "" {
+		r.context.Set("$"+node.Variable, val)
+	}
+	if node.KeyVar != "" {
+		r.context.Set("$"+node.KeyVar, key)
+	}
+}
+
+// executeWith executes a with statement. When the value is truthy, the body
+// runs in a new scope with . bound to the value; otherwise the else branch runs.
+func (r *Runtime) executeWith(node *parser.WithNode) error {
+	val, err := r.evaluateExpression(node.Value)
+	if err != nil {
+		return fmt.Errorf("with value error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
 	}
 
+	if !IsTruthy(val) {
+		return r.executeNodes(node.Else)
+	}
+
+	r.context.PushScope()
+	defer r.context.PopScope()
+	r.context.Set(".", val)
+	return r.executeNodes(node.Body)
+}
+
+// executeSet evaluates the value of a set statement and binds it to the
+// variable in the current scope.
+func (r *Runtime) executeSet(node *parser.SetNode) error {
+	val, err := r.evaluateExpression(node.Value)
+	if err != nil {
+		return fmt.Errorf("set $%s error at %d:%d: %w", node.Variable, node.Position.Line, node.Position.Column, err)
+	}
+	r.context.assign("$"+node.Variable, val)
 	return nil
 }
 
@@ -242,171 +525,374 @@ func (r *Runtime) executeCall(node *parser.CallNode) error {
 	// Special case: if it's a no-arg call starting with @, treat it as a variable
 	if len(node.Args) == 0 && node.Function != "" && node.Function[0] == '@' {
 		// This is a special loop variable like @index, @first, @last
-		val, err := r.context.Get([]string{node.Function})
+		val, err := r.lookup([]string{node.Function}, node.Position)
 		if err != nil {
-			return fmt.Errorf("variable error at %d:%d: %v", node.Position.Line, node.Position.Column, err)
+			return err
 		}
-		_, _ = fmt.Fprint(r.output, val)
-		return nil
+		return r.writeValue(val)
 	}
 
 	// Evaluate all arguments
 	args := make([]interface{}, len(node.Args))
 	for i, argNode := range node.Args {
-		val, err := r.evaluateExpression(argNode)
+		val, err := r.evaluateArgument(node.Function, i, argNode)
 		if err != nil {
-			return fmt.Errorf("function argument error at %d:%d: %v", node.Position.Line, node.Position.Column, err)
+			return fmt.Errorf("function argument error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
 		}
 		args[i] = val
 	}
 
 	// Call the function
-	result, err := r.functions.Call(node.Function, args...)
+	result, err := r.functions.CallContext(r.cancelCtx, node.Function, args...)
 	if err != nil {
-		return fmt.Errorf("function call error at %d:%d: %v", node.Position.Line, node.Position.Column, err)
+		return fmt.Errorf("function call error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
 	}
 
 	// Output the result
-	_, _ = fmt.Fprint(r.output, result)
-	return nil
+	return r.writeValue(result)
 }
 
 // executePipe executes a pipe expression.
 func (r *Runtime) executePipe(node *parser.PipeNode) error {
+	val, err := r.evaluatePipe(node)
+	if err != nil {
+		return err
+	}
+
+	// Output the final result
+	return r.writeValue(val)
+}
+
+// evaluatePipe evaluates a pipe expression, calling each filter with its own
+// arguments and the piped value, which goes first or last depending on the
+// filter's PipeArg.
+func (r *Runtime) evaluatePipe(node *parser.PipeNode) (interface{}, error) {
 	// Evaluate the initial value
-	val, err := r.evaluateExpression(node.Value)
+	var first *parser.CallNode
+	if len(node.Filters) > 0 {
+		first = node.Filters[0]
+	}
+	val, err := r.evaluatePiped(first, node.Value)
 	if err != nil {
-		return fmt.Errorf("pipe value error: %v", err)
+		return nil, fmt.Errorf("pipe value error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
 	}
 
 	// Apply each filter in sequence
-	for _, filterName := range node.Filters {
-		val, err = r.functions.Call(filterName, val)
+	for i, filter := range node.Filters {
+		val, err = r.applyFilter(filter, val)
 		if err != nil {
-			return fmt.Errorf("filter error (%s): %v", filterName, err)
+			return nil, fmt.Errorf("pipeline stage %d (%s) error at %d:%d: %w",
+				i+1, filter.Function, filter.Position.Line, filter.Position.Column, err)
 		}
 	}
 
-	// Output the final result
-	_, _ = fmt.Fprint(r.output, val)
-	return nil
+	return val, nil
+}
+
+// evaluatePiped evaluates the initial value of a pipeline, which is passed
+// to filter (nil if there are no filters).
+func (r *Runtime) evaluatePiped(filter *parser.CallNode, node parser.Node) (interface{}, error) {
+	if filter == nil {
+		return r.evaluateExpression(node)
+	}
+	index := 0
+	if r.functions.PipeArg(filter.Function) == PipeLast {
+		index = len(filter.Args)
+	}
+	return r.evaluateArgument(filter.Function, index, node)
+}
+
+// applyFilter calls filter with its arguments and the piped value.
+func (r *Runtime) applyFilter(filter *parser.CallNode, piped interface{}) (interface{}, error) {
+	pipeLast := r.functions.PipeArg(filter.Function) == PipeLast
+
+	args := make([]interface{}, 0, len(filter.Args)+1)
+	if !pipeLast {
+		args = append(args, piped)
+	}
+	for i, argNode := range filter.Args {
+		index := i
+		if !pipeLast {
+			index++
+		}
+		val, err := r.evaluateArgument(filter.Function, index, argNode)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, val)
+	}
+	if pipeLast {
+		args = append(args, piped)
+	}
+
+	return r.functions.CallContext(r.cancelCtx, filter.Function, args...)
 }
 
 // evaluateExpression evaluates an expression node and returns its value.
 func (r *Runtime) evaluateExpression(node parser.Node) (interface{}, error) {
+	if err := r.countNode(node); err != nil {
+		return nil, err
+	}
+
 	switch n := node.(type) {
 	case *parser.VariableNode:
-		return r.context.Get(n.Path)
+		return r.lookupVariable(n)
 	case *parser.LiteralNode:
 		return r.evaluateLiteral(n)
 	case *parser.BinaryOpNode:
 		return r.evaluateBinaryOp(n)
+	case *parser.TernaryNode:
+		return r.evaluateTernary(n)
 	case *parser.UnaryOpNode:
 		return r.evaluateUnaryOp(n)
 	case *parser.IndexNode:
 		return r.evaluateIndex(n)
+	case *parser.SliceNode:
+		return r.evaluateSlice(n)
+	case *parser.FieldNode:
+		return r.evaluateField(n)
+	case *parser.ListNode:
+		return r.evaluateList(n)
+	case *parser.MapNode:
+		return r.evaluateMap(n)
+	case *parser.PipeNode:
+		return r.evaluatePipe(n)
+	case *parser.MethodNode:
+		return r.evaluateMethod(n)
 	case *parser.CallNode:
 		// Special case: @variables
 		if len(n.Args) == 0 && n.Function != "" && n.Function[0] == '@' {
-			return r.context.Get([]string{n.Function})
+			return r.lookup([]string{n.Function}, n.Position)
 		}
 		// Evaluate function calls
 		args := make([]interface{}, len(n.Args))
 		for i, argNode := range n.Args {
-			val, err := r.evaluateExpression(argNode)
+			val, err := r.evaluateArgument(n.Function, i, argNode)
 			if err != nil {
 				return nil, err
 			}
 			args[i] = val
 		}
-		return r.functions.Call(n.Function, args...)
+		return r.functions.CallContext(r.cancelCtx, n.Function, args...)
 	default:
 		return nil, fmt.Errorf("cannot evaluate node type: %T", node)
 	}
 }
 
+// checkCancelled returns a *CancelledError if the render context is done.
+func (r *Runtime) checkCancelled(pos parser.Position) error {
+	if err := r.cancelCtx.Err(); err != nil {
+		return &CancelledError{Template: r.templateName, Line: pos.Line, Column: pos.Column, Err: err}
+	}
+	return nil
+}
+
+// lookupVariable resolves a variable node, including components accessed with ?..
+func (r *Runtime) lookupVariable(node *parser.VariableNode) (interface{}, error) {
+	val, err := r.context.resolve(node.Path, node.Optional, r.resolveOptions())
+	return r.checkUndefined(val, err, node.Position)
+}
+
+// lookup resolves a variable path.
+func (r *Runtime) lookup(path []string, pos parser.Position) (interface{}, error) {
+	val, err := r.context.resolve(path, nil, r.resolveOptions())
+	return r.checkUndefined(val, err, pos)
+}
+
+// resolveOptions returns the options for resolving variable paths.
+func (r *Runtime) resolveOptions() resolveOptions {
+	return resolveOptions{methods: r.methods, fields: r.fields}
+}
+
+// checkUndefined handles the result of a lookup at pos. Undefined values are
+// nil in lenient mode; in strict mode the *UndefinedError is returned with
+// its template location. A *MethodError always gets the location.
+func (r *Runtime) checkUndefined(val interface{}, err error, pos parser.Position) (interface{}, error) {
+	if err == nil {
+		return val, nil
+	}
+
+	var method *MethodError
+	if errors.As(err, &method) {
+		method.Template = r.templateName
+		method.Line = pos.Line
+		method.Column = pos.Column
+		return nil, method
+	}
+
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) {
+		return nil, err
+	}
+	if !r.strict {
+		return nil, nil
+	}
+	undefined.Template = r.templateName
+	undefined.Line = pos.Line
+	undefined.Column = pos.Column
+	return nil, undefined
+}
+
+// evaluateArgument evaluates a function argument. The value tested by
+// default is always resolved leniently so that default can rescue
+// undefined values even in strict mode.
+func (r *Runtime) evaluateArgument(function string, index int, node parser.Node) (interface{}, error) {
+	if function != "default" || index != 0 {
+		return r.evaluateExpression(node)
+	}
+	return r.evaluateLenient(node)
+}
+
+// evaluateLenient evaluates an expression as in lenient mode, so undefined
+// values are nil even in strict mode.
+func (r *Runtime) evaluateLenient(node parser.Node) (interface{}, error) {
+	if !r.strict {
+		return r.evaluateExpression(node)
+	}
+
+	r.strict = false
+	defer func() { r.strict = true }()
+	return r.evaluateExpression(node)
+}
+
 // evaluateLiteral evaluates a literal node.
 func (r *Runtime) evaluateLiteral(node *parser.LiteralNode) (interface{}, error) {
 	return node.Value, nil
 }
 
 // evaluateBinaryOp evaluates a binary operation.
+// && and || short-circuit: the right operand is only evaluated if the left
+// one does not decide the result, and the deciding operand is returned.
 func (r *Runtime) evaluateBinaryOp(node *parser.BinaryOpNode) (interface{}, error) {
+	if node.Operator == lexer.TokenCoalesce {
+		return r.evaluateCoalesce(node)
+	}
+
 	left, err := r.evaluateExpression(node.Left)
 	if err != nil {
 		return nil, err
 	}
 
+	switch node.Operator {
+	case lexer.TokenAnd:
+		if !IsTruthy(left) {
+			return left, nil
+		}
+		return r.evaluateExpression(node.Right)
+	case lexer.TokenOr:
+		if IsTruthy(left) {
+			return left, nil
+		}
+		return r.evaluateExpression(node.Right)
+	}
+
 	right, err := r.evaluateExpression(node.Right)
 	if err != nil {
 		return nil, err
 	}
 
-	return r.applyBinaryOperator(node.Operator, left, right)
+	return r.applyBinaryOperator(node, left, right)
 }
 
-// applyBinaryOperator applies a binary operator to two values.
-func (r *Runtime) applyBinaryOperator(op lexer.TokenType, left, right interface{}) (interface{}, error) {
+// evaluateCoalesce evaluates a ?? b: the left operand unless it is nil or
+// undefined, in which case the right operand. Unlike ||, falsy values such
+// as 0 and "" are kept. The left operand never fails as undefined, even in
+// strict mode.
+func (r *Runtime) evaluateCoalesce(node *parser.BinaryOpNode) (interface{}, error) {
+	left, err := r.evaluateLenient(node.Left)
+	if err != nil {
+		return nil, err
+	}
+	if !isNil(left) {
+		return left, nil
+	}
+	return r.evaluateExpression(node.Right)
+}
+
+// evaluateTernary evaluates cond ? a : b, evaluating only the chosen branch.
+func (r *Runtime) evaluateTernary(node *parser.TernaryNode) (interface{}, error) {
+	cond, err := r.evaluateExpression(node.Condition)
+	if err != nil {
+		return nil, err
+	}
+	if IsTruthy(cond) {
+		return r.evaluateExpression(node.Then)
+	}
+	return r.evaluateExpression(node.Else)
+}
+
+// applyBinaryOperator applies the operator of node to two values.
+func (r *Runtime) applyBinaryOperator(node *parser.BinaryOpNode, left, right interface{}) (interface{}, error) {
 	// Comparison operators
-	if result, ok := r.tryComparisonOp(op, left, right); ok {
+	if result, ok, err := r.tryComparisonOp(node.Operator, left, right); ok {
+		if err != nil {
+			return nil, fmt.Errorf("comparison error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
+		}
 		return result, nil
 	}
 
-	// Logical operators
-	if result, ok := r.tryLogicalOp(op, left, right); ok {
-		return result, nil
+	// Membership operators
+	if node.Operator == lexer.TokenIn || node.Operator == lexer.TokenNotIn {
+		found, err := membership(right, left)
+		if err != nil {
+			return nil, fmt.Errorf("membership error at %d:%d: %w", node.Position.Line, node.Position.Column, err)
+		}
+		return found == (node.Operator == lexer.TokenIn), nil
 	}
 
 	// Arithmetic operators
-	return r.tryArithmeticOp(op, left, right)
+	return r.tryArithmeticOp(node.Operator, left, right)
 }
 
-// tryComparisonOp attempts to apply a comparison operator.
-func (r *Runtime) tryComparisonOp(op lexer.TokenType, left, right interface{}) (interface{}, bool) {
+// tryComparisonOp attempts to apply a comparison operator, following Equal
+// and Compare. Operands that cannot be compared are unequal and unordered,
+// or an error with strict comparisons.
+func (r *Runtime) tryComparisonOp(op lexer.TokenType, left, right interface{}) (interface{}, bool, error) {
 	switch op {
-	case lexer.TokenEqual:
-		return compareEqual(left, right), true
-	case lexer.TokenNotEqual:
-		return !compareEqual(left, right), true
-	case lexer.TokenLess:
-		return compareLess(left, right), true
-	case lexer.TokenGreater:
-		return compareLess(right, left), true
-	case lexer.TokenLessEq:
-		return !compareLess(right, left), true
-	case lexer.TokenGreaterEq:
-		return !compareLess(left, right), true
+	case lexer.TokenEqual, lexer.TokenNotEqual:
+		equal, ok := equalValues(reflect.ValueOf(left), reflect.ValueOf(right))
+		if !ok && r.strictComparisons {
+			return nil, true, fmt.Errorf("cannot compare %T and %T", left, right)
+		}
+		return equal == (op == lexer.TokenEqual), true, nil
+	case lexer.TokenLess, lexer.TokenGreater, lexer.TokenLessEq, lexer.TokenGreaterEq:
+	default:
+		return nil, false, nil
 	}
-	return nil, false
-}
 
-// tryLogicalOp attempts to apply a logical operator.
-func (r *Runtime) tryLogicalOp(op lexer.TokenType, left, right interface{}) (interface{}, bool) {
+	order, ok := Compare(left, right)
+	if !ok {
+		if r.strictComparisons {
+			return nil, true, fmt.Errorf("cannot order %T and %T", left, right)
+		}
+		return false, true, nil
+	}
 	switch op {
-	case lexer.TokenAnd:
-		return IsTruthy(left) && IsTruthy(right), true
-	case lexer.TokenOr:
-		return IsTruthy(left) || IsTruthy(right), true
+	case lexer.TokenLess:
+		return order < 0, true, nil
+	case lexer.TokenGreater:
+		return order > 0, true, nil
+	case lexer.TokenLessEq:
+		return order <= 0, true, nil
+	default:
+		return order >= 0, true, nil
 	}
-	return nil, false
 }
 
-// tryArithmeticOp attempts to apply an arithmetic operator.
+// tryArithmeticOp attempts to apply an arithmetic operator. + also
+// concatenates strings and * repeats a string.
 func (r *Runtime) tryArithmeticOp(op lexer.TokenType, left, right interface{}) (interface{}, error) {
 	switch op {
 	case lexer.TokenPlus:
-		return addValues(left, right)
-	case lexer.TokenMinus:
-		return subtractValues(left, right)
+		if s, ok := concatenate(left, right); ok {
+			return s, nil
+		}
 	case lexer.TokenMult:
-		return multiplyValues(left, right)
-	case lexer.TokenDiv:
-		return divideValues(left, right)
-	case lexer.TokenMod:
-		return modValues(left, right)
-	default:
-		return nil, fmt.Errorf("unsupported operator: %v", op)
+		if s, count, ok := repetition(left, right); ok {
+			return r.repeat(s, count)
+		}
 	}
+	return arithmetic(op, left, right, r.decimal)
 }
 
 // evaluateUnaryOp evaluates a unary operation.
@@ -420,141 +906,150 @@ func (r *Runtime) evaluateUnaryOp(node *parser.UnaryOpNode) (interface{}, error)
 	case lexer.TokenNot:
 		return !IsTruthy(operand), nil
 	case lexer.TokenMinus:
-		return negateValue(operand)
+		return negate(operand)
 	default:
 		return nil, fmt.Errorf("unsupported unary operator: %v", node.Operator)
 	}
 }
 
-// evaluateIndex evaluates an index expression.
-func (r *Runtime) evaluateIndex(node *parser.IndexNode) (interface{}, error) {
-	obj, err := r.evaluateExpression(node.Object)
-	if err != nil {
-		return nil, err
+// evaluateMethod calls a method with arguments, like .User.HasRole "admin".
+// A missing method or nil receiver is handled like an undefined field.
+func (r *Runtime) evaluateMethod(node *parser.MethodNode) (interface{}, error) {
+	path, optional := node.Method.Path, node.Method.Optional
+	last := len(path) - 1
+	receiver := &parser.VariableNode{Position: node.Position, Path: path[:last]}
+	if optional != nil {
+		receiver.Optional = optional[:last]
 	}
 
-	idx, err := r.evaluateExpression(node.Index)
+	obj, err := r.lookupVariable(receiver)
 	if err != nil {
 		return nil, err
 	}
+	name := path[last]
+	if isNil(obj) {
+		if isOptional(optional, last) {
+			return nil, nil
+		}
+		undefined := &UndefinedError{Path: formatPath(path), Name: name, NilRef: formatPath(path[:last])}
+		return r.checkUndefined(nil, undefined, node.Position)
+	}
 
-	return r.context.GetIndex(obj, idx)
-}
-
-// Helper functions for arithmetic and comparison
+	args := make([]interface{}, len(node.Args))
+	for i, argNode := range node.Args {
+		val, err := r.evaluateExpression(argNode)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
 
-func compareEqual(a, b interface{}) bool {
-	return fmt.Sprint(a) == fmt.Sprint(b)
+	if !r.methods {
+		disabled := &MethodError{Method: name, Type: fmt.Sprintf("%T", obj), Err: errMethodsDisabled}
+		return r.checkUndefined(nil, disabled, node.Position)
+	}
+	val, ok, err := callMethod(obj, name, args)
+	if !ok {
+		undefined := &UndefinedError{
+			Path:       formatPath(path),
+			Name:       name,
+			Suggestion: closestName(name, methodNames(obj)),
+		}
+		return r.checkUndefined(nil, undefined, node.Position)
+	}
+	return r.checkUndefined(val, err, node.Position)
 }
 
-func compareLess(a, b interface{}) bool {
-	aNum, aOk := toFloat(a)
-	bNum, bOk := toFloat(b)
-	if aOk && bOk {
-		return aNum < bNum
+// evaluateList evaluates a list literal to a []interface{}.
+func (r *Runtime) evaluateList(node *parser.ListNode) (interface{}, error) {
+	list := make([]interface{}, len(node.Elements))
+	for i, element := range node.Elements {
+		val, err := r.evaluateExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = val
 	}
-	return fmt.Sprint(a) < fmt.Sprint(b)
+	return list, nil
 }
 
-func toFloat(val interface{}) (float64, bool) {
-	switch v := val.(type) {
-	case int:
-		return float64(v), true
-	case int64:
-		return float64(v), true
-	case float64:
-		return v, true
-	case float32:
-		return float64(v), true
-	default:
-		return 0, false
+// evaluateMap evaluates a map literal to a map[string]interface{}.
+func (r *Runtime) evaluateMap(node *parser.MapNode) (interface{}, error) {
+	m := make(map[string]interface{}, len(node.Keys))
+	for i, key := range node.Keys {
+		val, err := r.evaluateExpression(node.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		m[key] = val
 	}
+	return m, nil
 }
 
-func toInt(val interface{}) (int, bool) {
-	switch v := val.(type) {
-	case int:
-		return v, true
-	case int64:
-		return int(v), true
-	default:
-		return 0, false
+// evaluateIndex evaluates an index expression.
+func (r *Runtime) evaluateIndex(node *parser.IndexNode) (interface{}, error) {
+	obj, err := r.evaluateExpression(node.Object)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func addValues(a, b interface{}) (interface{}, error) {
-	aNum, aOk := toFloat(a)
-	bNum, bOk := toFloat(b)
-	if aOk && bOk {
-		// Check if both were integers
-		if aInt, aIsInt := toInt(a); aIsInt {
-			if bInt, bIsInt := toInt(b); bIsInt {
-				return aInt + bInt, nil
-			}
-		}
-		return aNum + bNum, nil
+	idx, err := r.evaluateExpression(node.Index)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("cannot add %T and %T", a, b)
+
+	val, err := r.context.GetIndex(obj, idx)
+	return r.checkNilAccess(val, err, node, node.Object)
 }
 
-func subtractValues(a, b interface{}) (interface{}, error) {
-	aNum, aOk := toFloat(a)
-	bNum, bOk := toFloat(b)
-	if aOk && bOk {
-		if aInt, aIsInt := toInt(a); aIsInt {
-			if bInt, bIsInt := toInt(b); bIsInt {
-				return aInt - bInt, nil
-			}
-		}
-		return aNum - bNum, nil
+// evaluateSlice evaluates a slice expression like .Items[1:3].
+func (r *Runtime) evaluateSlice(node *parser.SliceNode) (interface{}, error) {
+	obj, err := r.evaluateExpression(node.Object)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("cannot subtract %T and %T", a, b)
-}
 
-func multiplyValues(a, b interface{}) (interface{}, error) {
-	aNum, aOk := toFloat(a)
-	bNum, bOk := toFloat(b)
-	if aOk && bOk {
-		if aInt, aIsInt := toInt(a); aIsInt {
-			if bInt, bIsInt := toInt(b); bIsInt {
-				return aInt * bInt, nil
-			}
+	var low, high interface{}
+	if node.Low != nil {
+		if low, err = r.evaluateExpression(node.Low); err != nil {
+			return nil, err
 		}
-		return aNum * bNum, nil
 	}
-	return nil, fmt.Errorf("cannot multiply %T and %T", a, b)
-}
-
-func divideValues(a, b interface{}) (interface{}, error) {
-	aNum, aOk := toFloat(a)
-	bNum, bOk := toFloat(b)
-	if aOk && bOk {
-		if bNum == 0 {
-			return nil, fmt.Errorf("division by zero")
+	if node.High != nil {
+		if high, err = r.evaluateExpression(node.High); err != nil {
+			return nil, err
 		}
-		return aNum / bNum, nil
 	}
-	return nil, fmt.Errorf("cannot divide %T and %T", a, b)
+
+	val, err := r.context.GetSlice(obj, low, high)
+	return r.checkNilAccess(val, err, node, node.Object)
 }
 
-func modValues(a, b interface{}) (interface{}, error) {
-	aInt, aOk := toInt(a)
-	bInt, bOk := toInt(b)
-	if aOk && bOk {
-		if bInt == 0 {
-			return nil, fmt.Errorf("modulo by zero")
-		}
-		return aInt % bInt, nil
+// checkNilAccess handles the result of indexing or slicing object in node.
+// Indexing a nil value is undefined, like a field of a nil value: empty in
+// lenient mode and an *UndefinedError naming the expressions in strict mode.
+func (r *Runtime) checkNilAccess(val interface{}, err error, node, object parser.Node) (interface{}, error) {
+	var undefined *UndefinedError
+	if errors.As(err, &undefined) {
+		undefined.Path = describeNode(node)
+		undefined.NilRef = describeNode(object)
 	}
-	return nil, fmt.Errorf("cannot mod %T and %T", a, b)
+	return r.checkUndefined(val, err, node.Pos())
 }
 
-func negateValue(val interface{}) (interface{}, error) {
-	if num, ok := toFloat(val); ok {
-		if intVal, isInt := toInt(val); isInt {
-			return -intVal, nil
-		}
-		return -num, nil
+// evaluateField evaluates field access on an indexed value, like
+// .Users[0].Name, resolving the fields like the rest of a path.
+func (r *Runtime) evaluateField(node *parser.FieldNode) (interface{}, error) {
+	obj, err := r.evaluateExpression(node.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	path := append([]string{describeNode(node.Object)}, node.Path...)
+	var optional []bool
+	if node.Optional != nil {
+		optional = append([]bool{false}, node.Optional...)
 	}
-	return nil, fmt.Errorf("cannot negate %T", val)
+	val, err := r.context.walk(obj, path, 1, optional, r.resolveOptions())
+	return r.checkUndefined(val, err, node.Position)
 }
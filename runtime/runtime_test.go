@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/toutaio/toutago-fith-renderer/lexer"
@@ -20,6 +21,24 @@ func executeTemplate(input string, data interface{}) (string, error) {
 	return Execute(ast, ctx)
 }
 
+// Helper function to parse and execute a template in strict mode
+func executeStrict(input string, data interface{}) (string, error) {
+	l := lexer.New(input)
+	p := parser.New(l)
+	ast, err := p.Parse()
+	if err != nil {
+		return "", err
+	}
+
+	rt := NewRuntime(NewContext(data))
+	rt.SetStrictMode(true)
+	rt.SetTemplateName("test")
+	if err := rt.ExecuteTemplate(ast); err != nil {
+		return "", err
+	}
+	return rt.Output(), nil
+}
+
 func TestRuntime_SimpleText(t *testing.T) {
 	output, err := executeTemplate("Hello, World!", nil)
 	if err != nil {
@@ -238,6 +257,187 @@ func TestRuntime_LogicalOperators(t *testing.T) {
 	}
 }
 
+func TestRuntime_Precedence(t *testing.T) {
+	data := map[string]interface{}{"A": 2, "B": 3, "C": 10}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{.A + .B * 2}}", "8"},
+		{"{{(.A + .B) * 2}}", "10"},
+		{"{{.C - .B - .A}}", "5"},
+		{"{{.C - .A * .B + 1}}", "5"},
+		{"{{-.A + .C}}", "8"},
+		{"{{if .A + .B == 5 && .C > .A * 4}}yes{{end}}", "yes"},
+		{"{{if .A > 5 || .B < 5 && .C == 10}}yes{{end}}", "yes"},
+		{"{{if !(.A > 1)}}no{{else}}yes{{end}}", "yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeTemplate(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_ShortCircuit(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "and guards nil access",
+			template: "[{{if .User && .User.Name}}{{.User.Name}}{{end}}]",
+			data:     map[string]interface{}{"User": nil},
+			expected: "[]",
+		},
+		{
+			name:     "or skips failing right operand",
+			template: "{{if .Ok || .Missing.Field}}ok{{end}}",
+			data:     map[string]interface{}{"Ok": true},
+			expected: "ok",
+		},
+		{
+			name:     "or returns first truthy operand",
+			template: "{{.Nick || .Name}}",
+			data:     map[string]interface{}{"Nick": "", "Name": "Ada"},
+			expected: "Ada",
+		},
+		{
+			name:     "or returns left operand",
+			template: "{{.Nick || .Name}}",
+			data:     map[string]interface{}{"Nick": "ada99", "Name": "Ada"},
+			expected: "ada99",
+		},
+		{
+			name:     "and returns right operand",
+			template: "{{.User && .User.Name}}",
+			data:     map[string]interface{}{"User": map[string]string{"Name": "Ada"}},
+			expected: "Ada",
+		},
+		{
+			name:     "and returns falsy left operand",
+			template: "{{.Count && .Label}}",
+			data:     map[string]interface{}{"Count": 0, "Label": "items"},
+			expected: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeStrict(tt.template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_ConditionalOperators(t *testing.T) {
+	user := map[string]interface{}{
+		"Name":    "Ada",
+		"Profile": map[string]interface{}{"Avatar": "ada.png"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "ternary picks then branch",
+			template: `{{.Count > 1 ? "items" : "item"}}`,
+			data:     map[string]interface{}{"Count": 2},
+			expected: "items",
+		},
+		{
+			name:     "ternary only evaluates chosen branch",
+			template: `{{.User ? .User.Name : "guest"}}`,
+			data:     map[string]interface{}{"User": nil},
+			expected: "guest",
+		},
+		{
+			name:     "nested ternary",
+			template: `{{.N > 10 ? "many" : .N > 1 ? "few" : "one"}}`,
+			data:     map[string]interface{}{"N": 3},
+			expected: "few",
+		},
+		{
+			name:     "coalesce keeps zero value",
+			template: `{{.Count ?? 5}}|{{.Count || 5}}`,
+			data:     map[string]interface{}{"Count": 0},
+			expected: "0|5",
+		},
+		{
+			name:     "coalesce replaces nil",
+			template: `{{.Nick ?? .Name}}`,
+			data:     map[string]interface{}{"Nick": nil, "Name": "Ada"},
+			expected: "Ada",
+		},
+		{
+			name:     "coalesce replaces undefined",
+			template: `{{.Missing ?? "fallback"}}`,
+			data:     map[string]interface{}{},
+			expected: "fallback",
+		},
+		{
+			name:     "safe navigation through nil",
+			template: `[{{.User?.Profile?.Avatar}}]`,
+			data:     map[string]interface{}{"User": nil},
+			expected: "[]",
+		},
+		{
+			name:     "safe navigation resolves value",
+			template: `{{.User?.Profile?.Avatar}}`,
+			data:     map[string]interface{}{"User": user},
+			expected: "ada.png",
+		},
+		{
+			name:     "safe navigation on missing field",
+			template: `{{.User?.Nickname ?? "anon"}}`,
+			data:     map[string]interface{}{"User": user},
+			expected: "anon",
+		},
+		{
+			name:     "safe navigation on variable",
+			template: `{{set $u = .User}}[{{$u?.Name}}]`,
+			data:     map[string]interface{}{"User": nil},
+			expected: "[]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeStrict(tt.template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	// Only the components after ?. are optional.
+	_, err := executeStrict("{{.User.Profile?.Avatar}}", map[string]interface{}{"User": nil})
+	if err == nil {
+		t.Error("expected error for nil access before safe navigation in strict mode")
+	}
+}
+
 func TestRuntime_Not(t *testing.T) {
 	data := map[string]interface{}{
 		"Active": false,
@@ -302,6 +502,79 @@ func TestRuntime_RangeFirstLast(t *testing.T) {
 	}
 }
 
+func TestRuntime_LoopControl(t *testing.T) {
+	data := map[string]interface{}{
+		"Items":  []int{1, 2, 3, 4, 5},
+		"Scores": map[string]int{"a": 1, "b": 2, "c": 3},
+		"Grid":   [][]int{{1, 2, 3}, {4, 5, 6}},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{range .Items}}{{if . == 3}}{{break}}{{end}}{{.}}{{end}}", "12"},
+		{"{{range .Items}}{{if . % 2 == 0}}{{continue}}{{end}}{{.}}{{end}}", "135"},
+		{"{{range .Scores}}{{if @first}}{{continue}}{{end}}{{@index}}{{end}}", "12"},
+		{"{{range .Scores}}{{@index}}{{break}}{{end}}", "0"},
+		{"{{range .Grid}}{{range .}}{{if . == 2 || . == 5}}{{break}}{{end}}{{.}}{{end}};{{end}}", "1;4;"},
+		{"{{range .Items}}{{with .}}{{if . > 1}}{{break}}{{end}}{{end}}{{.}}{{end}}[{{.Items | len}}]", "1[5]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_LoopMetadata(t *testing.T) {
+	data := map[string]interface{}{
+		"Items":  []string{"a", "b", "c"},
+		"Scores": map[string]int{"x": 1, "y": 2},
+		"Grid":   [][]int{{1, 2}, {3}},
+		"Nested": map[string][]int{"k": {1, 2}},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{range .Items}}{{@index}}/{{@length}}/{{@revindex}} {{end}}", "0/3/2 1/3/1 2/3/0 "},
+		{"{{range .Items}}{{if @even}}e{{end}}{{if @odd}}o{{end}}{{end}}", "eoe"},
+		{"{{range .Items}}{{@odd}}{{@index}} {{end}}", "false0 true1 false2 "},
+		{"{{range .Scores}}{{@index}}:{{@length}}:{{@last}} {{end}}", "0:2:false 1:2:true "},
+		{"{{range .Items}}{{@depth}}{{end}}", "111"},
+		{"{{range .Grid}}{{range .}}{{@parent.index}}.{{@index}}@{{@depth}} {{end}}{{end}}", "0.0@2 0.1@2 1.0@2 "},
+		{"{{range .Grid}}{{range .}}{{if @parent.last && @last}}{{.}}{{end}}{{end}}{{end}}", "3"},
+		{"{{range .Items}}[{{@parent?.index}}]{{end}}", "[][][]"},
+		{"{{set $items = .Items}}{{range .Grid}}{{range .}}{{range $items}}{{if @first}}{{@parent.parent.index}}{{end}}{{end}}{{end}}{{end}}", "001"},
+		{"{{range .Nested}}{{range .}}{{@parent.key}}{{@depth}}{{end}}{{end}}", "k2k2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	if _, err := executeStrict("{{range .Items}}{{@parent.index}}{{end}}", data); err == nil {
+		t.Error("expected error for @parent of the outermost loop in strict mode")
+	}
+}
+
 func TestRuntime_RangeEmptySlice(t *testing.T) {
 	data := map[string]interface{}{
 		"Items": []string{},
@@ -338,6 +611,335 @@ func TestRuntime_RangeMap(t *testing.T) {
 	}
 }
 
+func TestRuntime_Comments(t *testing.T) {
+	l := lexer.New("Hello{{/* {{.Secret}} */}} {{.Name /* who */}}{{/* bye */}}")
+	l.SetKeepComments(true)
+	ast, err := parser.New(l).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	output, err := Execute(ast, NewContext(map[string]interface{}{"Name": "Bob", "Secret": "x"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "Hello Bob" {
+		t.Errorf("expected %q, got %q", "Hello Bob", output)
+	}
+}
+
+func TestRuntime_ElseIf(t *testing.T) {
+	template := "{{if .Score >= 90}}A{{else if .Score >= 80}}B{{elif .Score >= 70}}C{{else}}F{{end}}"
+
+	tests := []struct {
+		score    int
+		expected string
+	}{
+		{95, "A"},
+		{85, "B"},
+		{75, "C"},
+		{10, "F"},
+	}
+
+	for _, tt := range tests {
+		output, err := executeTemplate(template, map[string]interface{}{"Score": tt.score})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if output != tt.expected {
+			t.Errorf("score %d: expected %q, got %q", tt.score, tt.expected, output)
+		}
+	}
+}
+
+func TestRuntime_RangeElse(t *testing.T) {
+	template := "{{range .Items}}<{{.}}>{{else}}No items{{end}}"
+
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		expected string
+	}{
+		{"non-empty slice", map[string]interface{}{"Items": []string{"a", "b"}}, "<a><b>"},
+		{"empty slice", map[string]interface{}{"Items": []string{}}, "No items"},
+		{"nil slice", map[string]interface{}{"Items": []string(nil)}, "No items"},
+		{"empty map", map[string]interface{}{"Items": map[string]int{}}, "No items"},
+		{"nil value", map[string]interface{}{"Items": nil}, "No items"},
+		{"missing", map[string]interface{}{}, "No items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeTemplate(template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_RangeVariables(t *testing.T) {
+	data := map[string]interface{}{
+		"Groups": []map[string]interface{}{
+			{"Name": "A", "Items": []string{"x", "y"}},
+			{"Name": "B", "Items": []string{"z"}},
+		},
+		"Scores": map[string]int{"alice": 3},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "element variable",
+			template: "{{range $g := .Groups}}{{$g.Name}}{{end}}",
+			expected: "AB",
+		},
+		{
+			name:     "index and element",
+			template: "{{range $i, $g := .Groups}}{{$i}}={{$g.Name}} {{end}}",
+			expected: "0=A 1=B ",
+		},
+		{
+			name:     "key and value",
+			template: "{{range $k, $v := .Scores}}{{$k}}:{{$v}}@{{@key}} {{end}}",
+			expected: "alice:3@alice ",
+		},
+		{
+			name:     "nested loops reach outer variable",
+			template: "{{range $g := .Groups}}{{range .Items}}{{$g.Name}}{{.}}{{@index}} {{end}}{{end}}",
+			expected: "Ax0 Ay1 Bz0 ",
+		},
+		{
+			name:     "variable as function argument",
+			template: "{{range $g := .Groups}}{{lower $g.Name}}{{end}}",
+			expected: "ab",
+		},
+		{
+			name:     "variable as collection",
+			template: "{{range $g := .Groups}}{{range $item := $g.Items}}{{$item}}{{end}}{{end}}",
+			expected: "xyz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeTemplate(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_RangeVariableOutOfScope(t *testing.T) {
+	_, err := executeStrict("{{range $x := .}}{{end}}{{$x}}", []int{1})
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) || undefined.Path != "$x" {
+		t.Errorf("expected undefined $x after the loop, got %v", err)
+	}
+}
+
+func TestRuntime_Set(t *testing.T) {
+	data := map[string]interface{}{
+		"Price": 3,
+		"Qty":   4,
+		"Order": map[string]interface{}{
+			"Customer": map[string]interface{}{"City": "Paris"},
+		},
+		"Items": []string{"a", "b"},
+		"Nums":  []int{1, 2, 3},
+		"Rows":  [][]int{{1, 2}, {3, 4}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "arithmetic",
+			template: "{{set $total = .Price * .Qty}}{{$total}}",
+			expected: "12",
+		},
+		{
+			name:     "reused path",
+			template: "{{set $city = .Order.Customer.City}}{{$city}}/{{$city}}",
+			expected: "Paris/Paris",
+		},
+		{
+			name:     "function call",
+			template: `{{set $city = upper .Order.Customer.City}}{{$city}}`,
+			expected: "PARIS",
+		},
+		{
+			name:     "reassignment",
+			template: "{{set $x = 1}}{{set $x = 2}}{{$x}}",
+			expected: "2",
+		},
+		{
+			name:     "visible in loop",
+			template: "{{set $sep = \"-\"}}{{range .Items}}{{.}}{{$sep}}{{end}}",
+			expected: "a-b-",
+		},
+		{
+			name:     "assigned in loop scope",
+			template: "{{set $x = \"out\"}}{{range .Items}}{{set $x = .}}{{$x}}{{end}}{{$x}}",
+			expected: "abb",
+		},
+		{
+			name:     "accumulated in loop",
+			template: "{{set $t = 0}}{{range .Nums}}{{set $t = $t + .}}{{end}}{{$t}}",
+			expected: "6",
+		},
+		{
+			name:     "accumulated in nested loops",
+			template: "{{set $n = 0}}{{range .Rows}}{{range .}}{{set $n = $n + .}}{{end}}{{end}}{{$n}}",
+			expected: "10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeTemplate(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_SetOutOfScope(t *testing.T) {
+	_, err := executeStrict("{{range .}}{{set $x = .}}{{end}}{{$x}}", []int{1})
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) || undefined.Path != "$x" {
+		t.Errorf("expected undefined $x after the loop, got %v", err)
+	}
+}
+
+func TestRuntime_With(t *testing.T) {
+	template := "{{with .User}}{{.Name}}{{set $x = 1}}{{else}}guest{{end}}[{{$x}}]"
+
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		expected string
+	}{
+		{"truthy", map[string]interface{}{"User": map[string]string{"Name": "Ada"}}, "Ada[]"},
+		{"nil", map[string]interface{}{"User": nil}, "guest[]"},
+		{"missing", map[string]interface{}{}, "guest[]"},
+		{"empty string", map[string]interface{}{"User": ""}, "guest[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeTemplate(template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	output, err := executeTemplate("{{with .A}}{{with .B}}{{.}}{{end}}{{.B}}{{end}}{{.A.B}}", map[string]interface{}{
+		"A": map[string]string{"B": "b"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "bbb" {
+		t.Errorf("expected nested with to restore ., got %q", output)
+	}
+}
+
+func TestRuntime_PipelinesAsExpressions(t *testing.T) {
+	data := map[string]interface{}{
+		"Tags":  []string{"go", "web"},
+		"Empty": []string{},
+		"Rows":  [][]string{{"a", "b"}, {"c", "d"}},
+		"Keys":  []string{"x"},
+		"Map":   map[string]string{"x": "found"},
+		"X":     "  hi  ",
+		"A":     2,
+		"B":     3,
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"if condition", "{{if .Tags | len}}yes{{end}}{{if .Empty | len}}no{{end}}", "yes"},
+		{"else if condition", "{{if .Empty | len}}a{{else if .Tags | len}}b{{end}}", "b"},
+		{"range collection", "{{range .Rows | first}}{{.}}{{end}}", "ab"},
+		{"with value", "{{with .Rows | last}}{{len .}}{{end}}", "2"},
+		{"set value", "{{set $n = .Tags | len}}{{$n}}", "2"},
+		{"nested call", "[{{upper (trim .X)}}]", "[HI]"},
+		{"pipeline argument", "{{join (.Rows | last) \"-\"}}", "c-d"},
+		{"index expression", "{{.Map[.Keys | first]}}", "found"},
+		{"indexed group", "{{(.Rows | last)[1]}}", "d"},
+		{"call as operand", "{{if len .Tags > 1}}many{{end}}", "many"},
+		{"operation piped", "{{.A + .B | default 0}}", "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeTemplate(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+func TestRuntime_CollectionLiterals(t *testing.T) {
+	data := map[string]interface{}{"C": "c", "T": "Title", "N": 2}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{range ["a", "b", .C]}}{{.}};{{end}}`, "a;b;c;"},
+		{`{{range $i, $v := [.N, .N * 2]}}{{$i}}={{$v}};{{end}}`, "0=2;1=4;"},
+		{`{{range $k, $v := {"title": .T}}}{{$k}}={{$v}};{{end}}`, "title=Title;"},
+		{`{{set $m = {"size": 3, "title": .T}}}{{$m.title}} {{$m.size}}`, "Title 3"},
+		{`{{range []}}x{{else}}empty{{end}}`, "empty"},
+		{`{{len ["a", upper .C, 3]}}`, "3"},
+		{`{{join [.T, .C] "-"}}`, "Title-c"},
+		{`{{["x", "y"] | last}}`, "y"},
+		{`{{set $card = {"title": .T, "tags": ["a", "b"]}}}{{$card.title}} {{len $card.tags}}`, "Title 2"},
+		{`{{with {"name": .C}}}{{.name}}{{end}}`, "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
 func TestRuntime_ArrayAccess(t *testing.T) {
 	data := map[string]interface{}{
 		"Items": []string{"first", "second", "third"},
@@ -373,6 +975,106 @@ func TestRuntime_MapAccess(t *testing.T) {
 	}
 }
 
+func TestRuntime_IndexAndSlice(t *testing.T) {
+	type user struct{ Name string }
+	data := map[string]interface{}{
+		"Items":  []string{"a", "b", "c", "d"},
+		"Name":   "Ådne",
+		"Users":  []user{{"Ada"}, {"Grace"}},
+		"Grid":   [][]int{{1, 2}, {3, 4}},
+		"Counts": map[int64]string{1: "one"},
+		"Rows":   []map[string]interface{}{{"id": 7}},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{.Items[-1]}}", "d"},
+		{"{{.Items[1:3]}}", "[b c]"},
+		{"{{.Items[:5]}}", "[a b c d]"},
+		{"{{.Items[-2:]}}", "[c d]"},
+		{"{{.Name[0:1]}}", "Å"},
+		{"{{.Name[-1]}}", "e"},
+		{"{{.Users[1].Name}}", "Grace"},
+		{"{{.Users[-1].Name[:3]}}", "Gra"},
+		{"{{.Grid[1][0]}}", "3"},
+		{"{{.Counts[1]}}", "one"},
+		{"{{.Rows[0].id}}", "7"},
+		{`{{range .Items[1:]}}{{.}}{{end}}`, "bcd"},
+		{`{{join .Items[:2] "-"}}`, "a-b"},
+		{`{{len .Items[:3]}}`, "3"},
+		{`{{set $u = .Users}}{{$u[0].Name}}`, "Ada"},
+		{`{{if .Users[0].Name == "Ada"}}yes{{end}}`, "yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	_, err := executeStrict("{{.Users[1].Nmae}}", data)
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) || undefined.Path != ".Users[1].Nmae" || undefined.Suggestion != "Name" {
+		t.Errorf("expected undefined .Users[1].Nmae suggesting Name, got %v", err)
+	}
+	output, err := executeTemplate("[{{.Users[1].Nmae}}]", data)
+	if err != nil || output != "[]" {
+		t.Errorf("expected empty output in lenient mode, got %q, %v", output, err)
+	}
+}
+
+func TestRuntime_KeywordFields(t *testing.T) {
+	data := map[string]interface{}{
+		"set":  "a",
+		"in":   "b",
+		"with": map[string]interface{}{"range": "c", "not": "d"},
+	}
+
+	output, err := executeStrict(`{{.set}}{{.in}}{{.with.range}}{{.with?.not}}{{if "a" in .set}}!{{end}}`, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "abcd!" {
+		t.Errorf("expected %q, got %q", "abcd!", output)
+	}
+}
+
+func TestRuntime_IndexNil(t *testing.T) {
+	var nilUser *struct{ Tags []string }
+	data := map[string]interface{}{"Nil": nil, "NilUser": nilUser}
+
+	for _, input := range []string{
+		"{{.Missing[0]}}",
+		"{{.Nil[0].Name}}",
+		"{{.Missing[1:2]}}",
+		`{{.NilUser["Tags"]}}`,
+		"{{if .Missing[0]}}yes{{end}}",
+	} {
+		output, err := executeTemplate("["+input+"]", data)
+		if err != nil || output != "[]" {
+			t.Errorf("%s: expected empty output in lenient mode, got %q, %v", input, output, err)
+		}
+	}
+
+	_, err := executeStrict("{{.Nil[0]}}", data)
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) || undefined.Path != ".Nil[0]" || undefined.NilRef != ".Nil" || undefined.Line != 1 {
+		t.Errorf("expected undefined .Nil[0] on nil .Nil, got %v", err)
+	}
+	_, err = executeStrict("{{.Nil[:2]}}", data)
+	if !errors.As(err, &undefined) || undefined.Path != ".Nil[:2]" {
+		t.Errorf("expected undefined .Nil[:2], got %v", err)
+	}
+}
+
 func TestRuntime_Arithmetic(t *testing.T) {
 	tests := []struct {
 		template string
@@ -531,9 +1233,17 @@ func TestRuntime_InvalidVariable(t *testing.T) {
 		"Name": "Alice",
 	}
 
-	_, err := executeTemplate("{{.NonExistent}}", data)
+	output, err := executeTemplate("[{{.NonExistent}}]", data)
+	if err != nil {
+		t.Fatalf("unexpected error in lenient mode: %v", err)
+	}
+	if output != "[]" {
+		t.Errorf("expected empty output for non-existent variable, got %q", output)
+	}
+
+	_, err = executeStrict("{{.NonExistent}}", data)
 	if err == nil {
-		t.Error("expected error for non-existent variable")
+		t.Error("expected error for non-existent variable in strict mode")
 	}
 }
 
@@ -542,9 +1252,17 @@ func TestRuntime_NilFieldAccess(t *testing.T) {
 		"User": nil,
 	}
 
-	_, err := executeTemplate("{{.User.Name}}", data)
+	output, err := executeTemplate("[{{.User.Name}}]", data)
+	if err != nil {
+		t.Fatalf("unexpected error in lenient mode: %v", err)
+	}
+	if output != "[]" {
+		t.Errorf("expected empty output for nil field access, got %q", output)
+	}
+
+	_, err = executeStrict("{{.User.Name}}", data)
 	if err == nil {
-		t.Error("expected error for nil field access")
+		t.Error("expected error for nil field access in strict mode")
 	}
 }
 
@@ -0,0 +1,58 @@
+package runtime
+
+// Trusted value types mark content that has already been sanitised.
+// When auto-escaping is enabled, a trusted value is written untouched if it
+// is used in the context it is trusted for; in any other context it is
+// escaped like a plain string.
+
+// SafeHTML is trusted HTML markup, such as rendered Markdown.
+// It is written untouched in HTML text and quoted attribute values.
+type SafeHTML string
+
+// SafeURL is a trusted URL. It bypasses scheme filtering and
+// normalization in URL attributes such as href and src.
+type SafeURL string
+
+// SafeJS is trusted JavaScript. It is written untouched inside
+// <script> elements and event handler attributes.
+type SafeJS string
+
+// SafeCSS is trusted CSS. It is written untouched inside <style>
+// elements and style attributes.
+type SafeCSS string
+
+// SafeAttr is one or more trusted attributes, such as `disabled` or
+// `class="active"`. It is written untouched inside a tag, between
+// attributes, and escaped like a plain string inside an attribute value.
+type SafeAttr string
+
+// trusted returns val as a string if its type is trusted for the
+// escaper's current context.
+func (e *escaper) trusted(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case SafeHTML:
+		ok := e.state == stateText || e.state == stateComment ||
+			(e.state == stateAttrValue && e.attr == attrNormal && e.delim != 0)
+		return string(v), ok
+	case SafeAttr:
+		return string(v), e.state == stateTag || e.state == stateAfterName
+	case SafeURL:
+		return string(v), e.inAttrValue(attrURL)
+	case SafeJS:
+		return string(v), e.state == stateScript || e.inAttrValue(attrJS)
+	case SafeCSS:
+		return string(v), e.state == stateStyle || e.inAttrValue(attrCSS)
+	}
+	return "", false
+}
+
+// inAttrValue reports whether the escaper is at or inside an attribute value of the given kind.
+func (e *escaper) inAttrValue(kind attrKind) bool {
+	switch e.state {
+	case stateAttrValue:
+		return e.attr == kind
+	case stateBeforeValue:
+		return classifyAttr(e.attrName) == kind
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package runtime
+
+import "testing"
+
+func TestSafeValues_BypassEscaping(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     interface{}
+		expected string
+	}{
+		{
+			name:     "safe html in text",
+			template: `<div>{{.}}</div>`,
+			data:     SafeHTML("<em>hi</em>"),
+			expected: `<div><em>hi</em></div>`,
+		},
+		{
+			name:     "safe html in script is escaped",
+			template: `<script>var x = {{.}};</script>`,
+			data:     SafeHTML("<em>"),
+			expected: `<script>var x = "\u003cem\u003e";</script>`,
+		},
+		{
+			name:     "safe url skips scheme filter",
+			template: `<a href="{{.}}">`,
+			data:     SafeURL("data:image/png;base64,AAAA"),
+			expected: `<a href="data:image/png;base64,AAAA">`,
+		},
+		{
+			name:     "safe js in script",
+			template: `<script>{{.}}</script>`,
+			data:     SafeJS("init();"),
+			expected: `<script>init();</script>`,
+		},
+		{
+			name:     "safe css in style attribute",
+			template: `<p style="{{.}}">`,
+			data:     SafeCSS("color: red; margin: 0"),
+			expected: `<p style="color: red; margin: 0">`,
+		},
+		{
+			name:     "safe attr inside tag",
+			template: `<input {{.}}>`,
+			data:     SafeAttr(`type="checkbox" checked`),
+			expected: `<input type="checkbox" checked>`,
+		},
+		{
+			name:     "safe attr in quoted attribute value is escaped",
+			template: `<a title="{{.}}">`,
+			data:     SafeAttr(`x" onclick="evil`),
+			expected: `<a title="x&#34; onclick=&#34;evil">`,
+		},
+		{
+			name:     "safe attr in unquoted attribute value is escaped",
+			template: `<a title={{.}}>`,
+			data:     SafeAttr(`x onclick=evil`),
+			expected: `<a title=x&#32;onclick&#61;evil>`,
+		},
+		{
+			name:     "safe html opening an attribute",
+			template: `{{safe "<a href='"}}{{.}}'>`,
+			data:     "javascript:alert(1)",
+			expected: `<a href='#ZfithZ'>`,
+		},
+		{
+			name:     "safe attr opening a value",
+			template: `<input {{.Attr}}{{.Value}}">`,
+			data:     map[string]interface{}{"Attr": SafeAttr(`value="`), "Value": `x" onfocus="y`},
+			expected: `<input value="x&#34; onfocus=&#34;y">`,
+		},
+		{
+			name:     "safe js opening a string",
+			template: `<script>{{.}}{{"');alert(1);//"}}');</script>`,
+			data:     SafeJS(`go('`),
+			expected: `<script>go('\u0027);alert(1);\u002f\u002f');</script>`,
+		},
+		{
+			name:     "htmlEscape stays trusted through upper and trim",
+			template: `<p>{{upper (htmlEscape .)}}|{{htmlEscape . | trim | lower}}</p>`,
+			data:     " a<b ",
+			expected: `<p> A&LT;B |a&lt;b</p>`,
+		},
+		{
+			name:     "other functions return plain strings",
+			template: `<p>{{replace (htmlEscape .) "a" "c"}}</p>`,
+			data:     "a<b",
+			expected: `<p>c&amp;lt;b</p>`,
+		},
+		{
+			name:     "safe function",
+			template: `{{safe "<b>bold</b>"}}`,
+			expected: `<b>bold</b>`,
+		},
+		{
+			name:     "raw function",
+			template: `{{raw "<i>x</i>"}}`,
+			expected: `<i>x</i>`,
+		},
+		{
+			name:     "htmlEscape is not escaped twice",
+			template: `<p title="{{htmlEscape .}}">{{htmlEscape .}}</p>`,
+			data:     "a & b",
+			expected: `<p title="a &amp; b">a &amp; b</p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeEscaped(tt.template, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
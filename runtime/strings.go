@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// concatenate joins a and b for the + operator when both are strings or
+// fmt.Stringers and not both numbers, which add instead. The result is a
+// plain string, so joining a SafeHTML value with another string is escaped
+// like any other string.
+func concatenate(a, b interface{}) (string, bool) {
+	if _, ok := toNumber(a); ok {
+		if _, ok := toNumber(b); ok {
+			return "", false
+		}
+	}
+	x, xOk := stringOperand(a)
+	y, yOk := stringOperand(b)
+	if !xOk || !yOk {
+		return "", false
+	}
+	return x + y, true
+}
+
+// stringOperand returns the text of a string or non-nil fmt.Stringer.
+func stringOperand(val interface{}) (string, bool) {
+	v := reflect.ValueOf(val)
+	if v.Kind() == reflect.String {
+		return v.String(), true
+	}
+	if s, ok := val.(fmt.Stringer); ok && !isNilValue(v) {
+		return s.String(), true
+	}
+	return "", false
+}
+
+// repetition matches the operands of s * n or n * s, where s is a string
+// and n an integer, returning the string and the count.
+func repetition(a, b interface{}) (string, int64, bool) {
+	if v := reflect.ValueOf(b); v.Kind() == reflect.String {
+		a, b = b, a
+	}
+	v := reflect.ValueOf(a)
+	if v.Kind() != reflect.String {
+		return "", 0, false
+	}
+	if _, ok := toNumber(a); ok {
+		return "", 0, false
+	}
+	n, ok := toNumber(b)
+	if !ok || n.kind != numInt {
+		return "", 0, false
+	}
+	return v.String(), n.i, true
+}
+
+// repeat evaluates s * count. The result counts against MaxOutputBytes
+// before it is built, so a template cannot allocate a huge string.
+func (r *Runtime) repeat(s string, count int64) (interface{}, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("negative repeat count: %d", count)
+	}
+	if limit := r.limits.MaxOutputBytes; limit > 0 && count > 0 && int64(len(s)) > limit/count {
+		return nil, r.limitError(LimitOutputBytes, limit)
+	}
+	if count > 0 && int64(len(s)) > math.MaxInt/count {
+		return nil, fmt.Errorf("repeat count too large: %d", count)
+	}
+	return strings.Repeat(s, int(count)), nil
+}
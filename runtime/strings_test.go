@@ -0,0 +1,151 @@
+package runtime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toutaio/toutago-fith-renderer/lexer"
+	"github.com/toutaio/toutago-fith-renderer/parser"
+)
+
+func TestRuntime_StringOperators(t *testing.T) {
+	data := map[string]interface{}{
+		"First": "Ada",
+		"Last":  "Lovelace",
+		"Count": 3,
+		"Since": time.Minute,
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{.First + " " + .Last}}`, "Ada Lovelace"},
+		{`{{"took " + .Since}}`, "took 1m0s"},
+		{`{{"-" * 3}}`, "---"},
+		{`{{.Count * "ab"}}`, "ababab"},
+		{`{{"x" * 0}}[]`, "[]"},
+		{`{{upper (.First + "!")}}`, "ADA!"},
+		{`{{if .First + .Last == "AdaLovelace"}}yes{{end}}`, "yes"},
+		{`{{.Count + 1}}`, "4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		template string
+		message  string
+	}{
+		{`{{.First + 1}}`, "cannot add string and int"},
+		{`{{"a" * 1.5}}`, "cannot multiply string and float64"},
+		{`{{"a" * -1}}`, "negative repeat count"},
+		{`{{"a" * "b"}}`, "cannot multiply string and string"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := executeStrict(tt.template, data)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.template, tt.message, err)
+		}
+	}
+}
+
+func TestRuntime_ConcatenationIsNotSafe(t *testing.T) {
+	ast, err := parser.New(lexer.New(`<p>{{.Bold + "<i>"}}</p>`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rt := NewRuntime(NewContext(map[string]interface{}{"Bold": SafeHTML("<b>")}))
+	rt.SetAutoEscape(true)
+	if err := rt.ExecuteTemplate(ast); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output := rt.Output(); output != "<p>&lt;b&gt;&lt;i&gt;</p>" {
+		t.Errorf("expected concatenation to be escaped, got %q", output)
+	}
+}
+
+func TestRuntime_RepeatLimit(t *testing.T) {
+	ast, err := parser.New(lexer.New(`{{"abc" * 1000000000}}`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rt := NewRuntime(NewContext(nil))
+	rt.SetLimits(Limits{MaxOutputBytes: 1024})
+	err = rt.ExecuteTemplate(ast)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitOutputBytes {
+		t.Errorf("expected output limit error, got %v", err)
+	}
+}
+
+func TestRuntime_Membership(t *testing.T) {
+	data := map[string]interface{}{
+		"Title":  "Hello, World",
+		"Tags":   []string{"go", "templates"},
+		"IDs":    []int{1, 2, 3},
+		"Roles":  map[string]bool{"admin": true},
+		"Scores": map[int]string{7: "seven"},
+		"Any":    map[interface{}]int{1: 1},
+		"Nil":    []string(nil),
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{"World" in .Title}}`, "true"},
+		{`{{"world" in .Title}}`, "false"},
+		{`{{"world" not in .Title}}`, "true"},
+		{`{{"go" in .Tags}}`, "true"},
+		{`{{"rust" not in .Tags}}`, "true"},
+		{`{{2.0 in .IDs}}`, "true"},
+		{`{{"2" in .IDs}}`, "false"},
+		{`{{"admin" in .Roles}}`, "true"},
+		{`{{"editor" in .Roles}}`, "false"},
+		{`{{7 in .Scores}}`, "true"},
+		{`{{7.0 in .Scores}}`, "true"},
+		{`{{1.0 in .Any}}`, "true"},
+		{`{{"x" in .Nil}}`, "false"},
+		{`{{"b" in ["a", "b"]}}`, "true"},
+		{`{{"k" in {"k": nil}}}`, "true"},
+		{`{{if "go" in .Tags && "admin" in .Roles}}yes{{end}}`, "yes"},
+		{`{{if "rust" in .Tags}}yes{{else}}no{{end}}`, "no"},
+		{`{{"Hello" + ", " in .Title}}`, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			output, err := executeStrict(tt.template, data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	output, err := executeTemplate(`[{{"a" in .Missing}}]`, data)
+	if err != nil || output != "[false]" {
+		t.Errorf("expected undefined collection to contain nothing, got %q, %v", output, err)
+	}
+
+	for _, input := range []string{`{{1 in .Title}}`, `{{"a" in 5}}`} {
+		if _, err := executeStrict(input, data); err == nil || !strings.Contains(err.Error(), "membership error at 1:") {
+			t.Errorf("%s: expected membership error, got %v", input, err)
+		}
+	}
+}